@@ -0,0 +1,108 @@
+package audio
+
+import "encoding/binary"
+
+// IMA ADPCM in WAV (WAVE_FORMAT_IMA_ADPCM): 4 bits a sample, about
+// 32 kbit/s at 8 kHz. Mono only, which is all OP25 sends.
+const (
+	adpcmBlockAlign      = 256
+	adpcmSamplesPerBlock = (adpcmBlockAlign-4)*2 + 1
+)
+
+var adpcmStepTable = [89]int{
+	7, 8, 9, 10, 11, 12, 13, 14, 16, 17,
+	19, 21, 23, 25, 28, 31, 34, 37, 41, 45,
+	50, 55, 60, 66, 73, 80, 88, 97, 107, 118,
+	130, 143, 157, 173, 190, 209, 230, 253, 279, 307,
+	337, 371, 408, 449, 494, 544, 598, 658, 724, 796,
+	876, 963, 1060, 1166, 1282, 1411, 1552, 1707, 1878, 2066,
+	2272, 2499, 2749, 3024, 3327, 3660, 4026, 4428, 4871, 5358,
+	5894, 6484, 7132, 7845, 8630, 9493, 10442, 11487, 12635, 13899,
+	15289, 16818, 18500, 20350, 22385, 24623, 27086, 29794, 32767,
+}
+
+var adpcmIndexTable = [16]int{-1, -1, -1, -1, 2, 4, 6, 8, -1, -1, -1, -1, 2, 4, 6, 8}
+
+type adpcmEncoder struct {
+	pending   []int16
+	predictor int
+	index     int
+}
+
+func (e *adpcmEncoder) Encode(pcm []int16) []byte {
+	e.pending = append(e.pending, pcm...)
+	var out []byte
+	for len(e.pending) >= adpcmSamplesPerBlock {
+		out = append(out, e.block(e.pending[:adpcmSamplesPerBlock])...)
+		e.pending = e.pending[adpcmSamplesPerBlock:]
+	}
+	e.pending = append([]int16(nil), e.pending...)
+	return out
+}
+
+// block encodes one block. The first sample goes in the block header
+// as-is and restarts the predictor, so every block decodes on its own.
+func (e *adpcmEncoder) block(pcm []int16) []byte {
+	out := make([]byte, adpcmBlockAlign)
+	e.predictor = int(pcm[0])
+	binary.LittleEndian.PutUint16(out[0:2], uint16(pcm[0]))
+	out[2] = byte(e.index)
+	for i := 1; i < len(pcm); i++ {
+		nibble := e.encodeSample(int(pcm[i]))
+		pos := 4 + (i-1)/2
+		if (i-1)%2 == 0 {
+			out[pos] = nibble
+		} else {
+			out[pos] |= nibble << 4
+		}
+	}
+	return out
+}
+
+func (e *adpcmEncoder) encodeSample(sample int) byte {
+	step := adpcmStepTable[e.index]
+	diff := sample - e.predictor
+	var nibble byte
+	if diff < 0 {
+		nibble = 8
+		diff = -diff
+	}
+	delta := step >> 3
+	if diff >= step {
+		nibble |= 4
+		diff -= step
+		delta += step
+	}
+	step >>= 1
+	if diff >= step {
+		nibble |= 2
+		diff -= step
+		delta += step
+	}
+	step >>= 1
+	if diff >= step {
+		nibble |= 1
+		delta += step
+	}
+	if nibble&8 != 0 {
+		e.predictor -= delta
+	} else {
+		e.predictor += delta
+	}
+	e.predictor = min(max(e.predictor, -32768), 32767)
+	e.index = min(max(e.index+adpcmIndexTable[nibble], 0), len(adpcmStepTable)-1)
+	return nibble
+}
+
+func adpcmHeader(sampleRate, channels int) []byte {
+	fmtChunk := make([]byte, 20)
+	binary.LittleEndian.PutUint16(fmtChunk[0:2], 0x11)
+	binary.LittleEndian.PutUint16(fmtChunk[2:4], uint16(channels))
+	binary.LittleEndian.PutUint32(fmtChunk[4:8], uint32(sampleRate))
+	binary.LittleEndian.PutUint32(fmtChunk[8:12], uint32(sampleRate*adpcmBlockAlign/adpcmSamplesPerBlock))
+	binary.LittleEndian.PutUint16(fmtChunk[12:14], adpcmBlockAlign)
+	binary.LittleEndian.PutUint16(fmtChunk[14:16], 4)
+	binary.LittleEndian.PutUint16(fmtChunk[16:18], 2)
+	binary.LittleEndian.PutUint16(fmtChunk[18:20], adpcmSamplesPerBlock)
+	return streamingWAVHeader(fmtChunk)
+}
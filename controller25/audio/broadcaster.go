@@ -28,12 +28,19 @@ type Broadcaster struct {
     Channels   int
     tgGetter   TalkgroupGetter
     HLS        *HLSBroadcaster  // HLS streamer (exported)
+    streams    map[string]*codecStream // shared encoders, by codec and filter
+    calls      CallSource              // followed by filtered streams and HLS
+    dsp        *dspChain               // processing before broadcast, or nil
+    mixer      *streamMixer            // mixes the streams of a multi-stream broadcaster
+    alphaTag   func(tgid int) string   // names talkgroups in stream titles
+    lastAudio  time.Time
 }
 
 func NewBroadcaster(udpAddr string) *Broadcaster {
     b := &Broadcaster{
         udpAddr:    udpAddr,
         clients:    make(map[chan []byte]struct{}),
+        streams:    make(map[string]*codecStream),
         quit:       make(chan struct{}),
         SampleRate: 8000,
         Channels:   1,
@@ -42,22 +49,50 @@ func NewBroadcaster(udpAddr string) *Broadcaster {
     return b
 }
 
+// SetTalkgroupGetter sets what labels the audio with its talkgroup. A
+// multi-stream broadcaster labels its streams from their calls instead.
 func (a *Broadcaster) SetTalkgroupGetter(tg TalkgroupGetter) {
+    if a.mixer != nil {
+        return
+    }
     a.mu.Lock()
     defer a.mu.Unlock()
     a.tgGetter = tg
-    a.HLS.SetTalkgroupGetter(tg)
 }
 
-func (a *Broadcaster) Start() {
+// SetAlphaTag sets how talkgroups are named in call markers and stream
+// titles when OP25 doesn't supply a tag.
+func (a *Broadcaster) SetAlphaTag(fn func(tgid int) string) {
+    a.HLS.SetAlphaTag(fn)
+    for _, s := range a.Streams() {
+        s.SetAlphaTag(fn)
+    }
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    a.alphaTag = fn
+}
+
+// Start listens for OP25's audio. It fails if the UDP port can't be
+// bound, e.g. because another program holds it; Shutdown cleans up then.
+func (a *Broadcaster) Start() error {
+    go a.observeCalls()
+
+    // HLS keeps a live window of segments, so it encodes all the time
+    frames, _ := a.Subscribe(256)
+    go a.HLS.run(frames)
+
+    if a.mixer != nil {
+        return a.mixer.start(a.quit)
+    }
+
     addr, err := net.ResolveUDPAddr("udp", a.udpAddr)
     if err != nil {
-        log.Fatalf("Failed to resolve UDP address: %v", err)
+        return fmt.Errorf("failed to resolve UDP address %s: %v", a.udpAddr, err)
     }
 
     conn, err := net.ListenUDP("udp", addr)
     if err != nil {
-        log.Fatalf("Failed to listen on UDP: %v", err)
+        return fmt.Errorf("failed to listen on UDP %s: %v", a.udpAddr, err)
     }
     a.conn = conn
 
@@ -87,19 +122,18 @@ func (a *Broadcaster) Start() {
                     if n%2 != 0 {
                         n--
                     }
-                    a.broadcast(buf[:n])
+                    a.broadcast(a.process(buf[:n]))
                 }
             }
         }
     }()
+    return nil
 }
 
 func (a *Broadcaster) broadcast(data []byte) {
     a.mu.Lock()
     defer a.mu.Unlock()
-    
-    // Feed HLS broadcaster
-    a.HLS.AddAudioData(data)
+    a.lastAudio = time.Now()
     
     for ch := range a.clients {
         select {
@@ -109,21 +143,50 @@ func (a *Broadcaster) broadcast(data []byte) {
     }
 }
 
+// Subscribe returns a channel receiving a copy of every PCM frame and a
+// function to unsubscribe. Frames are dropped when the reader falls
+// behind, so a slow subscriber never holds up the live stream. The
+// channel is closed on Shutdown.
+func (a *Broadcaster) Subscribe(buffer int) (<-chan []byte, func()) {
+    ch := make(chan []byte, buffer)
+    a.mu.Lock()
+    a.clients[ch] = struct{}{}
+    a.mu.Unlock()
+    return ch, func() {
+        a.mu.Lock()
+        defer a.mu.Unlock()
+        if _, ok := a.clients[ch]; ok {
+            delete(a.clients, ch)
+            close(ch)
+        }
+    }
+}
+
+// ServeWAV streams the live audio as 16-bit PCM WAV, or with
+// ?codec=ulaw|adpcm|mp3 in a smaller encoding shared by all its clients.
 func (a *Broadcaster) ServeWAV(w http.ResponseWriter, r *http.Request) {
+    a.ServeFilteredWAV(w, r, Filter{})
+}
+
+// ServeFilteredWAV is ServeWAV with only the calls f allows; other calls
+// are heard as silence. Its audio runs 1.5 seconds behind.
+func (a *Broadcaster) ServeFilteredWAV(w http.ResponseWriter, r *http.Request, f Filter) {
+    if name := r.URL.Query().Get("codec"); name != "" && name != "wav" {
+        c, ok := codecs[name]
+        if !ok {
+            http.Error(w, fmt.Sprintf("Unknown codec %q (wav, %s)", name, strings.Join(Codecs(), ", ")), http.StatusBadRequest)
+            return
+        }
+        a.serveCodec(w, r, c, f)
+        return
+    }
+
     w.Header().Set("Content-Type", "audio/wav")
     w.Header().Set("Cache-Control", "no-cache")
     w.Header().Set("Connection", "keep-alive")
     // Don't set Content-Length for infinite streaming - use chunked transfer encoding
     
-    // Add talkgroup metadata headers
-    a.mu.Lock()
-    if a.tgGetter != nil {
-        if tg := a.tgGetter.GetActiveTalkgroup(); tg != nil {
-            w.Header().Set("X-Talkgroup-ID", fmt.Sprintf("%d", tg.GetTgid()))
-            w.Header().Set("X-Source-ID", fmt.Sprintf("%d", tg.GetSrcid()))
-        }
-    }
-    a.mu.Unlock()
+    a.setTalkgroupHeaders(w, f)
     
     flusher, ok := w.(http.Flusher)
     if !ok {
@@ -137,17 +200,8 @@ func (a *Broadcaster) ServeWAV(w http.ResponseWriter, r *http.Request) {
     }
     flusher.Flush()
 
-    ch := make(chan []byte, 100)
-    a.mu.Lock()
-    a.clients[ch] = struct{}{}
-    a.mu.Unlock()
-
-    defer func() {
-        a.mu.Lock()
-        delete(a.clients, ch)
-        a.mu.Unlock()
-        close(ch)
-    }()
+    ch, unsubscribe := a.subscribeFiltered(f, 100)
+    defer unsubscribe()
 
     notify := r.Context().Done()
     ticker := time.NewTicker(50 * time.Millisecond)
@@ -158,7 +212,10 @@ func (a *Broadcaster) ServeWAV(w http.ResponseWriter, r *http.Request) {
     
     for {
         select {
-        case data := <-ch:
+        case data, ok := <-ch:
+            if !ok {
+                return
+            }
             if _, err := w.Write(data); err != nil {
                 return
             }
@@ -178,6 +235,39 @@ func (a *Broadcaster) ServeWAV(w http.ResponseWriter, r *http.Request) {
     }
 }
 
+// observeCalls marks the calls the stream carries in the HLS playlist.
+func (a *Broadcaster) observeCalls() {
+    a.mu.Lock()
+    calls := a.calls
+    a.mu.Unlock()
+    if calls == nil {
+        return
+    }
+    events, unsubscribe := calls.Subscribe()
+    defer unsubscribe()
+    for {
+        select {
+        case e := <-events:
+            a.HLS.Observe(e)
+        case <-a.quit:
+            return
+        }
+    }
+}
+
+// setTalkgroupHeaders adds the talkgroup metadata headers, unless f
+// filters that talkgroup out.
+func (a *Broadcaster) setTalkgroupHeaders(w http.ResponseWriter, f Filter) {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    if a.tgGetter != nil {
+        if tg := a.tgGetter.GetActiveTalkgroup(); tg != nil && f.Allows(tg.GetTgid()) {
+            w.Header().Set("X-Talkgroup-ID", fmt.Sprintf("%d", tg.GetTgid()))
+            w.Header().Set("X-Source-ID", fmt.Sprintf("%d", tg.GetSrcid()))
+        }
+    }
+}
+
 func makeWavHeader(sampleRate, channels int) []byte {
     header := make([]byte, 44)
     copy(header[0:4], "RIFF")
@@ -206,6 +296,9 @@ func (a *Broadcaster) Shutdown() {
     if a.conn != nil {
         a.conn.Close()
     }
+    if a.mixer != nil {
+        a.mixer.shutdown()
+    }
     
     // Clear all client connections
     a.mu.Lock()
@@ -0,0 +1,48 @@
+package audio
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+// A taken audio port fails Start, for a single stream and for any stream
+// of several, rather than the whole controller.
+func TestStartPortTaken(t *testing.T) {
+	taken, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+	free, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	freeAddr := free.LocalAddr().String()
+	free.Close()
+
+	for _, tc := range []struct {
+		name  string
+		addrs []string
+		err   string
+	}{
+		{"single stream", []string{taken.LocalAddr().String()}, "failed to listen on UDP " + taken.LocalAddr().String()},
+		{"second of two", []string{freeAddr, taken.LocalAddr().String()}, "audio stream 1: failed to listen"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ab := NewMultiBroadcaster(tc.addrs)
+			err := ab.Start()
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("Start: %v, want %q", err, tc.err)
+			}
+			ab.Shutdown()
+		})
+	}
+
+	// Nothing is left holding the free port
+	again, err := net.ListenUDP("udp", free.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatalf("port of the stream that did start is still taken: %v", err)
+	}
+	again.Close()
+}
@@ -0,0 +1,244 @@
+package audio
+
+import (
+	"encoding/binary"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// Codec is a compressed format for the live stream.
+type Codec struct {
+	Name        string
+	ContentType string
+	Bitrate     int // bits per second, for the docs and the status page
+	// header starts a client's stream, or is nil
+	header func(sampleRate, channels int) []byte
+	// newEncoder returns an encoder for one shared stream
+	newEncoder func(sampleRate, channels int) encoder
+}
+
+// encoder compresses PCM. Encode returns whole frames or blocks only and
+// keeps the remainder for the next call, so a client can join between
+// any two outputs.
+type encoder interface {
+	Encode(pcm []int16) []byte
+}
+
+var codecs = map[string]*Codec{
+	"ulaw": {
+		Name:        "ulaw",
+		ContentType: "audio/wav",
+		Bitrate:     64000,
+		header:      ulawHeader,
+		newEncoder:  func(int, int) encoder { return ulawEncoder{} },
+	},
+	"adpcm": {
+		Name:        "adpcm",
+		ContentType: "audio/wav",
+		Bitrate:     32000,
+		header:      adpcmHeader,
+		newEncoder:  func(int, int) encoder { return &adpcmEncoder{} },
+	},
+	"mp3": {
+		Name:        "mp3",
+		ContentType: "audio/mpeg",
+		Bitrate:     mp3Bitrate * 1000,
+		newEncoder:  newMP3Encoder,
+	},
+}
+
+// Codecs returns the codec names accepted by ?codec=, besides wav.
+func Codecs() []string {
+	names := make([]string, 0, len(codecs))
+	for name := range codecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// codecStream encodes the live stream once for every client of one codec
+// and filter. It runs while it has clients.
+type codecStream struct {
+	codec   *Codec
+	filter  Filter
+	clients map[chan []byte]struct{} // guarded by Broadcaster.mu
+	quit    chan struct{}
+}
+
+// joinCodec adds a client to the shared stream for c and f, starting it
+// if needed. The returned function removes the client.
+func (a *Broadcaster) joinCodec(c *Codec, f Filter) (<-chan []byte, func()) {
+	ch := make(chan []byte, 64)
+	key := c.Name + f.key()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	s, ok := a.streams[key]
+	if !ok {
+		s = &codecStream{codec: c, filter: f, clients: make(map[chan []byte]struct{}), quit: make(chan struct{})}
+		a.streams[key] = s
+		go a.runCodec(s)
+	}
+	s.clients[ch] = struct{}{}
+	return ch, func() {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		delete(s.clients, ch)
+		if len(s.clients) == 0 && a.streams[key] == s {
+			delete(a.streams, key)
+			close(s.quit)
+		}
+	}
+}
+
+// runCodec feeds one shared encoder from the PCM stream. Like ServeWAV it
+// fills gaps with silence, here in real time, so players don't stall.
+func (a *Broadcaster) runCodec(s *codecStream) {
+	pcm, unsubscribe := a.subscribeFiltered(s.filter, 256)
+	defer unsubscribe()
+	enc := s.codec.newEncoder(a.SampleRate, a.Channels)
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	silence := make([]int16, a.SampleRate*a.Channels/20) // 50ms
+	lastData := time.Now()
+
+	for {
+		var out []byte
+		select {
+		case data, ok := <-pcm:
+			if !ok {
+				return
+			}
+			out = enc.Encode(samples(data))
+			lastData = time.Now()
+		case <-ticker.C:
+			if time.Since(lastData) <= 100*time.Millisecond {
+				continue
+			}
+			out = enc.Encode(silence)
+		case <-s.quit:
+			return
+		}
+		if len(out) == 0 {
+			continue
+		}
+		a.mu.Lock()
+		for ch := range s.clients {
+			select {
+			case ch <- out:
+			default:
+				// Slow client; it skips whole frames and resyncs
+			}
+		}
+		a.mu.Unlock()
+	}
+}
+
+// serveCodec streams the live audio in codec c, filtered by f.
+func (a *Broadcaster) serveCodec(w http.ResponseWriter, r *http.Request, c *Codec, f Filter) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", c.ContentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	a.setTalkgroupHeaders(w, f)
+
+	ch, leave := a.joinCodec(c, f)
+	defer leave()
+
+	if c.header != nil {
+		if _, err := w.Write(c.header(a.SampleRate, a.Channels)); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	notify := r.Context().Done()
+	for {
+		select {
+		case data := <-ch:
+			if _, err := w.Write(data); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-a.quit:
+			return
+		case <-notify:
+			return
+		}
+	}
+}
+
+// samples converts S16_LE bytes to samples.
+func samples(pcm []byte) []int16 {
+	out := make([]int16, len(pcm)/2)
+	for i := range out {
+		out[i] = int16(binary.LittleEndian.Uint16(pcm[2*i:]))
+	}
+	return out
+}
+
+// ulawEncoder is G.711 µ-law: 8 bits a sample, 64 kbit/s at 8 kHz.
+type ulawEncoder struct{}
+
+func (ulawEncoder) Encode(pcm []int16) []byte {
+	out := make([]byte, len(pcm))
+	for i, s := range pcm {
+		out[i] = linearToULaw(s)
+	}
+	return out
+}
+
+func linearToULaw(sample int16) byte {
+	const bias, clip = 0x84, 32635
+	s := int(sample)
+	sign := 0
+	if s < 0 {
+		s = -s
+		sign = 0x80
+	}
+	if s > clip {
+		s = clip
+	}
+	s += bias
+	exponent := 7
+	for mask := 0x4000; s&mask == 0 && exponent > 0; mask >>= 1 {
+		exponent--
+	}
+	mantissa := (s >> (exponent + 3)) & 0x0F
+	return ^byte(sign | exponent<<4 | mantissa)
+}
+
+// ulawHeader is a WAV header for an endless µ-law stream
+// (WAVE_FORMAT_MULAW).
+func ulawHeader(sampleRate, channels int) []byte {
+	fmtChunk := make([]byte, 18)
+	binary.LittleEndian.PutUint16(fmtChunk[0:2], 7)
+	binary.LittleEndian.PutUint16(fmtChunk[2:4], uint16(channels))
+	binary.LittleEndian.PutUint32(fmtChunk[4:8], uint32(sampleRate))
+	binary.LittleEndian.PutUint32(fmtChunk[8:12], uint32(sampleRate*channels))
+	binary.LittleEndian.PutUint16(fmtChunk[12:14], uint16(channels))
+	binary.LittleEndian.PutUint16(fmtChunk[14:16], 8)
+	return streamingWAVHeader(fmtChunk)
+}
+
+// streamingWAVHeader wraps a fmt chunk in a WAV header with unknown
+// lengths. Non-PCM formats also need a fact chunk.
+func streamingWAVHeader(fmtChunk []byte) []byte {
+	header := make([]byte, 0, 12+8+len(fmtChunk)+12+8)
+	header = append(header, "RIFF"...)
+	header = binary.LittleEndian.AppendUint32(header, 0xFFFFFFFF)
+	header = append(header, "WAVEfmt "...)
+	header = binary.LittleEndian.AppendUint32(header, uint32(len(fmtChunk)))
+	header = append(header, fmtChunk...)
+	header = append(header, "fact"...)
+	header = binary.LittleEndian.AppendUint32(header, 4)
+	header = binary.LittleEndian.AppendUint32(header, 0xFFFFFFFF)
+	header = append(header, "data"...)
+	header = binary.LittleEndian.AppendUint32(header, 0xFFFFFFFF)
+	return header
+}
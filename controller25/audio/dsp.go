@@ -0,0 +1,287 @@
+package audio
+
+import (
+	"encoding/binary"
+	"fmt"
+	"maps"
+	"math"
+	"sync"
+
+	"controller25/config"
+)
+
+const (
+	// Limits on what the API accepts
+	minHighPassHz    = 20
+	maxHighPassHz    = 1000
+	maxAGCGain       = 40 // dB
+	maxTalkgroupGain = 24 // dB, either way
+
+	// The AGC never cuts by more than this
+	agcMinGain = -24 // dB
+	// Without the gate, audio below this is treated as silence the AGC
+	// must not level up
+	agcSilence = -55 // dBFS
+
+	// Time constants, in seconds
+	levelWindow = 0.020 // fast level the gate opens on
+	gateHold    = 0.200 // the gate stays open this long after speech
+	gateAttack  = 0.002
+	gateRelease = 0.050
+	agcWindow   = 0.100 // speech level the AGC levels
+	agcAttack   = 0.050 // gain drops this fast on loud audio
+	agcRelease  = 2.0   // and recovers this slowly
+)
+
+// DSPSettings holds the processing chain configuration and the
+// per-talkgroup gain offsets. One is shared by every receiver, and changes
+// apply to audio already playing.
+type DSPSettings struct {
+	mu    sync.RWMutex
+	cfg   config.DSPConfig
+	gains map[int]float64
+}
+
+func NewDSPSettings(cfg config.DSPConfig, gains map[int]float64) *DSPSettings {
+	s := &DSPSettings{cfg: cfg, gains: make(map[int]float64)}
+	for tgid, gain := range gains {
+		s.gains[tgid] = clampGain(gain)
+	}
+	return s
+}
+
+// Config returns the processing chain configuration.
+func (s *DSPSettings) Config() config.DSPConfig {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cfg
+}
+
+// SetConfig replaces the processing chain configuration.
+func (s *DSPSettings) SetConfig(cfg config.DSPConfig) error {
+	switch {
+	case cfg.HighPassHz < minHighPassHz || cfg.HighPassHz > maxHighPassHz:
+		return fmt.Errorf("high-pass cutoff must be between %d and %d Hz", minHighPassHz, maxHighPassHz)
+	case cfg.GateThreshold < -90 || cfg.GateThreshold > 0:
+		return fmt.Errorf("gate threshold must be between -90 and 0 dBFS")
+	case cfg.AGCTarget < -40 || cfg.AGCTarget > 0:
+		return fmt.Errorf("AGC target must be between -40 and 0 dBFS")
+	case cfg.AGCMaxGain < 0 || cfg.AGCMaxGain > maxAGCGain:
+		return fmt.Errorf("AGC maximum gain must be between 0 and %d dB", maxAGCGain)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cfg = cfg
+	return nil
+}
+
+// Gains returns the per-talkgroup gain offsets in dB.
+func (s *DSPSettings) Gains() map[int]float64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return maps.Clone(s.gains)
+}
+
+// SetGain sets the gain offset applied to a talkgroup's audio, after the
+// AGC.
+func (s *DSPSettings) SetGain(tgid int, gain float64) error {
+	if gain != clampGain(gain) {
+		return fmt.Errorf("gain must be between -%d and %d dB", maxTalkgroupGain, maxTalkgroupGain)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gains[tgid] = gain
+	return nil
+}
+
+// DeleteGain removes a talkgroup's gain offset, reporting whether it had
+// one.
+func (s *DSPSettings) DeleteGain(tgid int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.gains[tgid]
+	delete(s.gains, tgid)
+	return ok
+}
+
+func (s *DSPSettings) snapshot(tgid int) (config.DSPConfig, float64) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cfg, s.gains[tgid]
+}
+
+func clampGain(gain float64) float64 {
+	return min(max(gain, -maxTalkgroupGain), maxTalkgroupGain)
+}
+
+// SetDSP runs the live audio through the processing chain in settings
+// before it reaches any client.
+func (a *Broadcaster) SetDSP(settings *DSPSettings) {
+	// Each stream is processed on its own, before it is mixed
+	for _, s := range a.Streams() {
+		s.SetDSP(settings)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.dsp = &dspChain{settings: settings, rate: float64(a.SampleRate), gateGain: 1}
+}
+
+// process runs a frame through the processing chain, in place.
+func (a *Broadcaster) process(pcm []byte) []byte {
+	a.mu.Lock()
+	d, tg := a.dsp, a.tgGetter
+	a.mu.Unlock()
+	if d == nil {
+		return pcm
+	}
+	tgid := 0
+	if tg != nil {
+		if active := tg.GetActiveTalkgroup(); active != nil {
+			tgid = active.GetTgid()
+		}
+	}
+	d.process(pcm, tgid)
+	return pcm
+}
+
+// dspChain is one stream's processing state: high-pass filter, noise
+// gate, AGC and talkgroup gain, in that order. The gate comes before the
+// AGC so that the AGC never levels up the noise between transmissions.
+type dspChain struct {
+	settings *DSPSettings
+	rate     float64
+	cfg      config.DSPConfig // what the state below was set up for
+
+	highPass biquad
+
+	level    float64 // fast mean square
+	hold     int     // samples the gate stays open for
+	gateGain float64
+
+	speech  float64 // slow mean square of speech only
+	agcGain float64 // dB
+}
+
+func (d *dspChain) process(pcm []byte, tgid int) {
+	cfg, offset := d.settings.snapshot(tgid)
+	if !cfg.HighPass && !cfg.Gate && !cfg.AGC && offset == 0 {
+		d.cfg = cfg
+		return
+	}
+	d.configure(cfg)
+
+	levelCoef := smoothing(levelWindow, d.rate)
+	gateOpen, gateClose := smoothing(gateAttack, d.rate), smoothing(gateRelease, d.rate)
+	speechCoef := smoothing(agcWindow, d.rate)
+	agcDown, agcUp := smoothing(agcAttack, d.rate), smoothing(agcRelease, d.rate)
+	holdSamples := int(gateHold * d.rate)
+	threshold := float64(agcSilence)
+	if cfg.Gate {
+		threshold = cfg.GateThreshold
+	}
+	tgGain := dbToGain(offset)
+
+	for i := 0; i+1 < len(pcm); i += 2 {
+		x := float64(int16(binary.LittleEndian.Uint16(pcm[i:]))) / 32768
+		if cfg.HighPass {
+			x = d.highPass.filter(x)
+		}
+
+		d.level += levelCoef * (x*x - d.level)
+		if powerToDB(d.level) > threshold {
+			d.hold = holdSamples
+		} else if d.hold > 0 {
+			d.hold--
+		}
+		speaking := d.hold > 0
+
+		if cfg.Gate {
+			target, coef := 0.0, gateClose
+			if speaking {
+				target, coef = 1, gateOpen
+			}
+			d.gateGain += coef * (target - d.gateGain)
+			x *= d.gateGain
+		}
+
+		if cfg.AGC {
+			// Only speech moves the AGC; it holds its gain through pauses
+			if speaking {
+				d.speech += speechCoef * (x*x - d.speech)
+				want := min(max(cfg.AGCTarget-powerToDB(d.speech), agcMinGain), cfg.AGCMaxGain)
+				coef := agcUp
+				if want < d.agcGain {
+					coef = agcDown
+				}
+				d.agcGain += coef * (want - d.agcGain)
+			}
+			x *= dbToGain(d.agcGain)
+		}
+
+		x *= tgGain
+		binary.LittleEndian.PutUint16(pcm[i:], uint16(int16(min(max(x*32768, -32768), 32767))))
+	}
+}
+
+// configure resets the state of stages that were just turned on or
+// changed.
+func (d *dspChain) configure(cfg config.DSPConfig) {
+	old := d.cfg
+	d.cfg = cfg
+	if cfg.HighPass && (!old.HighPass || cfg.HighPassHz != old.HighPassHz) {
+		d.highPass = newHighPass(cfg.HighPassHz, d.rate)
+	}
+	if cfg.Gate && !old.Gate {
+		d.gateGain = 1
+	}
+	if cfg.AGC && (!old.AGC || cfg.AGCTarget != old.AGCTarget) {
+		// Start out at unity gain
+		d.speech = dbToPower(cfg.AGCTarget)
+		d.agcGain = 0
+	}
+}
+
+// biquad is a second order IIR filter in transposed direct form II.
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	z1, z2             float64
+}
+
+// newHighPass returns a Butterworth high-pass filter (RBJ cookbook).
+func newHighPass(cutoff, rate float64) biquad {
+	w0 := 2 * math.Pi * min(cutoff, rate/2-1) / rate
+	alpha := math.Sin(w0) / math.Sqrt2 // sin(w0) / 2Q, Q = 1/√2
+	cos := math.Cos(w0)
+	a0 := 1 + alpha
+	return biquad{
+		b0: (1 + cos) / 2 / a0,
+		b1: -(1 + cos) / a0,
+		b2: (1 + cos) / 2 / a0,
+		a1: -2 * cos / a0,
+		a2: (1 - alpha) / a0,
+	}
+}
+
+func (f *biquad) filter(x float64) float64 {
+	y := f.b0*x + f.z1
+	f.z1 = f.b1*x - f.a1*y + f.z2
+	f.z2 = f.b2*x - f.a2*y
+	return y
+}
+
+// smoothing is the one-pole coefficient for a time constant in seconds.
+func smoothing(seconds, rate float64) float64 {
+	return 1 - math.Exp(-1/(seconds*rate))
+}
+
+func powerToDB(p float64) float64 {
+	return 10 * math.Log10(p+1e-12)
+}
+
+func dbToPower(db float64) float64 {
+	return math.Pow(10, db/10)
+}
+
+func dbToGain(db float64) float64 {
+	return math.Pow(10, db/20)
+}
@@ -0,0 +1,205 @@
+package audio
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"controller25/talkgroup"
+)
+
+const (
+	// filterDelay is how long a filtered client's audio is held back, so
+	// that audio heard before the call detector reports the call can still
+	// be attributed to it
+	filterDelay = 1500 * time.Millisecond
+	// As in the recorder: a pause separates one run of audio from the next
+	filterGap = 300 * time.Millisecond
+)
+
+// Filter selects the talkgroups a client hears. The zero Filter passes
+// everything.
+type Filter struct {
+	Talkgroups []int
+	Categories []string // RadioReference categories, e.g. "Fire Dispatch"
+	// Category looks up a talkgroup's category
+	Category func(tgid int) string
+}
+
+// Empty reports whether f passes everything.
+func (f Filter) Empty() bool {
+	return len(f.Talkgroups) == 0 && len(f.Categories) == 0
+}
+
+// Allows reports whether a call on tgid passes f.
+func (f Filter) Allows(tgid int) bool {
+	if f.Empty() {
+		return true
+	}
+	if slices.Contains(f.Talkgroups, tgid) {
+		return true
+	}
+	if len(f.Categories) > 0 && f.Category != nil {
+		category := f.Category(tgid)
+		for _, c := range f.Categories {
+			if category != "" && strings.EqualFold(c, category) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// key identifies the filter, so clients with the same one can share an
+// encoder.
+func (f Filter) key() string {
+	if f.Empty() {
+		return ""
+	}
+	tgids := slices.Clone(f.Talkgroups)
+	slices.Sort(tgids)
+	categories := make([]string, len(f.Categories))
+	for i, c := range f.Categories {
+		categories[i] = strings.ToLower(c)
+	}
+	slices.Sort(categories)
+	return fmt.Sprint(slices.Compact(tgids), slices.Compact(categories))
+}
+
+// SetCalls sets the calls that filtered streams and the HLS call markers
+// follow. A multi-stream broadcaster labels its streams from them.
+func (a *Broadcaster) SetCalls(calls CallSource) {
+	if a.mixer != nil {
+		a.mixer.setUpstream(calls)
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.calls = calls
+}
+
+// Calls returns the calls the stream carries, with Playing set while it
+// carries them, or nil before SetCalls. For a multi-stream broadcaster,
+// and for each of its streams, these are its own calls and not the ones
+// given to SetCalls.
+func (a *Broadcaster) Calls() CallSource {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.calls
+}
+
+// subscribeFiltered is Subscribe with the frames of calls f doesn't allow
+// replaced by silence of the same length, so the stream keeps time.
+func (a *Broadcaster) subscribeFiltered(f Filter, buffer int) (<-chan []byte, func()) {
+	a.mu.Lock()
+	calls := a.calls
+	a.mu.Unlock()
+	if f.Empty() || calls == nil {
+		return a.Subscribe(buffer)
+	}
+
+	frames, unsubFrames := a.Subscribe(256)
+	events, unsubEvents := calls.Subscribe()
+	out := make(chan []byte, buffer)
+	quit := make(chan struct{})
+	go func() {
+		defer close(out)
+		defer unsubEvents()
+		defer unsubFrames()
+		ff := &frameFilter{filter: f}
+		for _, rec := range calls.Active() {
+			if rec.Playing {
+				ff.playing, ff.tgid = rec.ID, rec.Tgid
+			}
+		}
+		ticker := time.NewTicker(50 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case pcm, ok := <-frames:
+				if !ok {
+					return
+				}
+				ff.add(pcm)
+			case e := <-events:
+				ff.observe(e)
+			case <-ticker.C:
+			case <-quit:
+				return
+			}
+			for _, pcm := range ff.release() {
+				select {
+				case out <- pcm:
+				default:
+				}
+			}
+		}
+	}()
+	return out, func() { close(quit) }
+}
+
+type filteredFrame struct {
+	at   time.Time
+	run  int
+	tgid int // call the frame belongs to, 0 while unknown
+	pcm  []byte
+}
+
+// frameFilter labels frames with the call they belong to and releases
+// them once old enough, silencing those of calls the filter drops.
+type frameFilter struct {
+	filter    Filter
+	pending   []filteredFrame
+	playing   string // ID of the call holding the stream
+	tgid      int
+	run       int
+	lastFrame time.Time
+}
+
+func (ff *frameFilter) add(pcm []byte) {
+	now := time.Now()
+	if now.Sub(ff.lastFrame) > filterGap {
+		ff.run++
+	}
+	ff.lastFrame = now
+	ff.pending = append(ff.pending, filteredFrame{at: now, run: ff.run, tgid: ff.tgid, pcm: pcm})
+}
+
+func (ff *frameFilter) observe(e talkgroup.CallEvent) {
+	c := e.Call
+	over := !c.Playing || e.Type == talkgroup.CallEnd
+	switch {
+	case c.ID == ff.playing && over:
+		ff.playing, ff.tgid = "", 0
+	case c.ID != ff.playing && !over:
+		ff.playing, ff.tgid = c.ID, c.Tgid
+		// The unclaimed start of the current run is this call's
+		if time.Since(ff.lastFrame) < filterGap {
+			for i := range ff.pending {
+				if p := &ff.pending[i]; p.run == ff.run && p.tgid == 0 {
+					p.tgid = c.Tgid
+				}
+			}
+		}
+	}
+}
+
+// release returns the frames that have waited long enough, silenced
+// unless they pass.
+func (ff *frameFilter) release() [][]byte {
+	var out [][]byte
+	cutoff := time.Now().Add(-filterDelay)
+	n := 0
+	for ; n < len(ff.pending) && ff.pending[n].at.Before(cutoff); n++ {
+		p := ff.pending[n]
+		if p.tgid != 0 && ff.filter.Allows(p.tgid) {
+			out = append(out, p.pcm)
+		} else {
+			out = append(out, make([]byte, len(p.pcm)))
+		}
+	}
+	clear(ff.pending[:n])
+	ff.pending = ff.pending[n:]
+	return out
+}
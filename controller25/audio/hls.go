@@ -2,160 +2,404 @@ package audio
 
 import (
 	"bytes"
-	"encoding/binary"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
+	"path"
+	"strings"
 	"sync"
 	"time"
+
+	"controller25/talkgroup"
+)
+
+// HLS segments are MPEG-TS carrying the MP3 stream (see mp3.go), which
+// Safari, iOS and ExoPlayer all play. Every segment has a wall-clock
+// time and an exact duration, and calls are marked twice: as
+// EXT-X-DATERANGE tags in the playlist and as ID3 timed metadata in the
+// segments.
+const (
+	hlsSegmentFrames = 56 // 2.016s of MP3 frames
+	hlsMaxSegments   = 10 // about 20 seconds of live window
+	hlsPESFrames     = 8  // MP3 frames per PES packet
+	hlsDateRangeCls  = "controller25.call"
+
+	// As in the WebSocket stream: audio heard shortly before a call is
+	// known to be playing belongs to it, and a pause separates runs
+	hlsPreroll = 2 * time.Second
+	hlsGap     = 300 * time.Millisecond
 )
 
+// mp3FrameTicks is the length of an MP3 frame in 90 kHz units.
+const mp3FrameTicks = mp3FrameSamples * tsClock / mp3SampleRate
+
+type hlsSegment struct {
+	seq      int
+	start    time.Time
+	duration time.Duration
+	data     []byte
+}
+
+func (s *hlsSegment) end() time.Time {
+	return s.start.Add(s.duration)
+}
+
+// hlsCall is a stretch of the stream a call was heard in. A call that
+// loses the stream and gets it back has one per stretch.
+type hlsCall struct {
+	id       string // DATERANGE ID, the call ID with a suffix after the first stretch
+	callID   string
+	tgid     int
+	srcid    int // latest source
+	firstSrc int
+	tag      string
+	start    time.Time
+	end      *time.Time
+}
+
+func (c *hlsCall) covers(t time.Time) bool {
+	return !t.Before(c.start) && (c.end == nil || t.Before(*c.end))
+}
+
+// overlap returns how long c was on the air between from and to.
+func (c *hlsCall) overlap(from, to time.Time) time.Duration {
+	if c.end != nil && c.end.Before(to) {
+		to = *c.end
+	}
+	if c.start.After(from) {
+		from = c.start
+	}
+	return max(to.Sub(from), 0)
+}
+
 type HLSBroadcaster struct {
-	mu           sync.RWMutex
-	segments     [][]byte          // Audio segments
-	segmentIndex int               // Current segment being written
-	maxSegments  int               // Max segments to keep (e.g., 5)
-	segmentDuration time.Duration  // Duration per segment (e.g., 2 seconds)
-	sampleRate   int
-	channels     int
-	buffer       *bytes.Buffer     // Current segment buffer
-	tgGetter     TalkgroupGetter
+	mu         sync.RWMutex
+	sampleRate int
+	channels   int
+	alphaTag   func(tgid int) string
+
+	segments []*hlsSegment // oldest first
+	nextSeq  int
+
+	calls     []*hlsCall // oldest first
+	open      *hlsCall   // the call holding the stream
+	stretches map[string]int
+	lastAudio time.Time // last frame of real audio, not silence
+	runAt     time.Time // when the current run of audio began
 }
 
 func NewHLSBroadcaster(sampleRate, channels int) *HLSBroadcaster {
 	return &HLSBroadcaster{
-		segments:        make([][]byte, 0),
-		maxSegments:     10, // Keep last 10 segments (20 seconds)
-		segmentDuration: 2 * time.Second,
-		sampleRate:      sampleRate,
-		channels:        channels,
-		buffer:          &bytes.Buffer{},
+		sampleRate: sampleRate,
+		channels:   channels,
+		stretches:  make(map[string]int),
 	}
 }
 
-func (h *HLSBroadcaster) SetTalkgroupGetter(tg TalkgroupGetter) {
+// SetAlphaTag sets how talkgroups are named in call markers when OP25
+// doesn't supply a tag.
+func (h *HLSBroadcaster) SetAlphaTag(fn func(tgid int) string) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
-	h.tgGetter = tg
+	h.alphaTag = fn
 }
 
-// AddAudioData adds PCM audio data and creates segments
-func (h *HLSBroadcaster) AddAudioData(data []byte) {
+// run encodes the live stream into segments until pcm is closed. Gaps are
+// filled with silence so the stream keeps pace with the wall clock.
+func (h *HLSBroadcaster) run(pcm <-chan []byte) {
+	enc := newMP3Encoder(h.sampleRate, h.channels).(*mp3Encoder)
+	mux := newTSMuxer()
+	rate := h.sampleRate * h.channels
+	frameIn := int64(mp3FrameSamples / enc.upsample) // input samples per frame
+	duration := func(samples int64) time.Duration {
+		return time.Duration(samples) * time.Second / time.Duration(rate)
+	}
+
+	clock := time.Now() // wall time of the next input sample
+	var pos int64       // input samples so far
+	var frame int64     // frames so far
+	var seg [][]byte    // frames of the segment being built
+	var segStart time.Time
+	var segFirst int64
+
+	encode := func(samples []int16) {
+		pos += int64(len(samples))
+		clock = clock.Add(duration(int64(len(samples))))
+		for _, f := range enc.encodeFrames(samples) {
+			if len(seg) == 0 {
+				segStart = clock.Add(-duration(pos - frame*frameIn))
+				segFirst = frame
+			}
+			seg = append(seg, f)
+			frame++
+			if len(seg) == hlsSegmentFrames {
+				h.addSegment(mux, seg, segStart, segFirst)
+				seg = nil
+			}
+		}
+	}
+
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	lastData := time.Now()
+	for {
+		select {
+		case data, ok := <-pcm:
+			if !ok {
+				return
+			}
+			now := time.Now()
+			samples := samples(data)
+			d := duration(int64(len(samples)))
+			// Resync after a stall rather than fall ever further behind
+			if now.Add(-d).Sub(clock) > 250*time.Millisecond {
+				clock = now.Add(-d)
+			}
+			h.mu.Lock()
+			if now.Sub(h.lastAudio) > hlsGap {
+				h.runAt = now.Add(-d)
+			}
+			h.lastAudio = now
+			h.mu.Unlock()
+			encode(samples)
+			lastData = now
+		case now := <-ticker.C:
+			if now.Sub(lastData) <= 100*time.Millisecond || !clock.Before(now) {
+				continue
+			}
+			n := min(int64(now.Sub(clock).Seconds()*float64(rate)), int64(rate))
+			encode(make([]int16, n))
+		}
+	}
+}
+
+// addSegment muxes a segment of MP3 frames, the first being frame number
+// first of the stream, and adds it to the live window.
+func (h *HLSBroadcaster) addSegment(mux *tsMuxer, frames [][]byte, start time.Time, first int64) {
+	pts := tsPTSOffset + first*mp3FrameTicks
+	duration := time.Duration(hlsSegmentFrames) * time.Second * mp3FrameSamples / mp3SampleRate
+	end := start.Add(duration)
+	ptsAt := func(t time.Time) int64 {
+		return pts + int64(t.Sub(start).Seconds()*tsClock)
+	}
+
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
-	// Add data to current buffer
-	h.buffer.Write(data)
-
-	// Calculate bytes needed for segment duration
-	// PCM S16_LE: 2 bytes per sample * sampleRate * channels * duration
-	bytesPerSegment := 2 * h.sampleRate * h.channels * int(h.segmentDuration.Seconds())
-
-	// If buffer has enough data, create a segment
-	if h.buffer.Len() >= bytesPerSegment {
-		segmentData := h.buffer.Next(bytesPerSegment)
-		
-		// Create WAV segment with header
-		wavData := h.makeWAVSegment(segmentData)
-		
-		h.segments = append(h.segments, wavData)
-		
-		// Keep only max segments
-		if len(h.segments) > h.maxSegments {
-			h.segments = h.segments[1:]
-		} else {
-			h.segmentIndex++
+	data := mux.segment()
+	// The call playing as the segment starts, so a player joining here
+	// has it, then any call starting within the segment
+	for _, c := range h.calls {
+		switch {
+		case c.covers(start):
+			data = mux.id3(data, callID3(c), pts)
+		case c.start.After(start) && c.start.Before(end):
+			data = mux.id3(data, callID3(c), ptsAt(c.start))
 		}
 	}
+	for i := 0; i < len(frames); i += hlsPESFrames {
+		pes := bytes.Join(frames[i:min(i+hlsPESFrames, len(frames))], nil)
+		data = mux.audio(data, pes, pts+int64(i)*mp3FrameTicks, i == 0)
+	}
+
+	h.segments = append(h.segments, &hlsSegment{seq: h.nextSeq, start: start, duration: duration, data: data})
+	h.nextSeq++
+	if len(h.segments) > hlsMaxSegments {
+		h.segments[0] = nil
+		h.segments = h.segments[1:]
+	}
+
+	// Forget calls that ended before the window
+	oldest := h.segments[0].start
+	n := 0
+	for _, c := range h.calls {
+		if c.end == nil || c.end.After(oldest) {
+			h.calls[n] = c
+			n++
+		}
+	}
+	clear(h.calls[n:])
+	h.calls = h.calls[:n]
 }
 
-func (h *HLSBroadcaster) makeWAVSegment(pcmData []byte) []byte {
-	buf := &bytes.Buffer{}
-	
-	// WAV header
-	dataSize := len(pcmData)
-	buf.WriteString("RIFF")
-	binary.Write(buf, binary.LittleEndian, uint32(36+dataSize))
-	buf.WriteString("WAVE")
-	
-	// fmt chunk
-	buf.WriteString("fmt ")
-	binary.Write(buf, binary.LittleEndian, uint32(16))        // chunk size
-	binary.Write(buf, binary.LittleEndian, uint16(1))         // PCM
-	binary.Write(buf, binary.LittleEndian, uint16(h.channels))
-	binary.Write(buf, binary.LittleEndian, uint32(h.sampleRate))
-	binary.Write(buf, binary.LittleEndian, uint32(h.sampleRate*h.channels*2)) // byte rate
-	binary.Write(buf, binary.LittleEndian, uint16(h.channels*2))              // block align
-	binary.Write(buf, binary.LittleEndian, uint16(16))                        // bits per sample
-	
-	// data chunk
-	buf.WriteString("data")
-	binary.Write(buf, binary.LittleEndian, uint32(dataSize))
-	buf.Write(pcmData)
-	
-	return buf.Bytes()
+func callID3(c *hlsCall) []byte {
+	fields := [][2]string{
+		{"call_id", c.callID},
+		{"tgid", fmt.Sprint(c.tgid)},
+		{"srcid", fmt.Sprint(c.srcid)},
+	}
+	if c.tag != "" {
+		fields = append(fields, [2]string{"alpha_tag", c.tag})
+	}
+	return id3Tag(callTitle(c), fields)
+}
+
+func callTitle(c *hlsCall) string {
+	if c.tag != "" {
+		return fmt.Sprintf("%s (%d)", c.tag, c.tgid)
+	}
+	return fmt.Sprint(c.tgid)
+}
+
+// Observe follows call events to mark which call each stretch of the
+// stream belongs to.
+func (h *HLSBroadcaster) Observe(e talkgroup.CallEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	c := e.Call
+	over := !c.Playing || e.Type == talkgroup.CallEnd
+	now := time.Now()
+	switch {
+	case h.open != nil && h.open.callID == c.ID:
+		h.open.srcid = c.Srcid
+		if over {
+			h.closeLocked(now)
+		}
+	case c.Playing && !over:
+		if h.open != nil {
+			h.closeLocked(now)
+		}
+		// The call began with the run of audio it is part of, if that
+		// started recently enough to be its own
+		start := now
+		if now.Sub(h.lastAudio) < hlsGap && now.Sub(h.runAt) < hlsPreroll {
+			start = h.runAt
+		}
+		if n := len(h.calls); n > 0 && h.calls[n-1].end != nil && h.calls[n-1].end.After(start) {
+			start = *h.calls[n-1].end
+		}
+		h.stretches[c.ID]++
+		id := c.ID
+		if n := h.stretches[c.ID]; n > 1 {
+			id = fmt.Sprintf("%s.%d", c.ID, n)
+		}
+		tag := c.Tag
+		if h.alphaTag != nil {
+			if name := h.alphaTag(c.Tgid); name != "" {
+				tag = name
+			}
+		}
+		h.open = &hlsCall{id: id, callID: c.ID, tgid: c.Tgid, srcid: c.Srcid, firstSrc: c.Srcid, tag: tag, start: start}
+		h.calls = append(h.calls, h.open)
+	}
+	if e.Type == talkgroup.CallEnd {
+		delete(h.stretches, c.ID)
+	}
+}
+
+// closeLocked ends the open call. If audio has moved on to a new run since
+// the call started, the call ended where that run began.
+func (h *HLSBroadcaster) closeLocked(now time.Time) {
+	end := now
+	if now.Sub(h.lastAudio) < hlsGap && h.runAt.After(h.open.start) {
+		end = h.runAt
+	}
+	h.open.end = &end
+	h.open = nil
 }
 
 // ServePlaylist serves the HLS playlist (.m3u8)
 func (h *HLSBroadcaster) ServePlaylist(w http.ResponseWriter, r *http.Request) {
 	h.mu.RLock()
-	numSegments := len(h.segments)
-	startIndex := h.segmentIndex - numSegments
-	if startIndex < 0 {
-		startIndex = 0
+	segments := append([]*hlsSegment{}, h.segments...)
+	calls := make([]hlsCall, len(h.calls))
+	for i, c := range h.calls {
+		calls[i] = *c
 	}
 	h.mu.RUnlock()
 
 	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
 	w.Header().Set("Cache-Control", "no-cache")
-	
-	// Write HLS playlist
+
+	segmentDuration := float64(hlsSegmentFrames*mp3FrameSamples) / mp3SampleRate
 	fmt.Fprintf(w, "#EXTM3U\n")
 	fmt.Fprintf(w, "#EXT-X-VERSION:3\n")
-	fmt.Fprintf(w, "#EXT-X-TARGETDURATION:%d\n", int(h.segmentDuration.Seconds())+1)
-	fmt.Fprintf(w, "#EXT-X-MEDIA-SEQUENCE:%d\n", startIndex)
-	
-	for i := 0; i < numSegments; i++ {
-		fmt.Fprintf(w, "#EXTINF:%.1f,\n", h.segmentDuration.Seconds())
-		fmt.Fprintf(w, "/audio/segment%d.wav\n", startIndex+i)
+	fmt.Fprintf(w, "#EXT-X-TARGETDURATION:%d\n", int(math.Round(segmentDuration)))
+	seq := 0
+	if len(segments) > 0 {
+		seq = segments[0].seq
+	}
+	fmt.Fprintf(w, "#EXT-X-MEDIA-SEQUENCE:%d\n", seq)
+
+	// Each call is marked just before the segment it starts in. Segment
+	// URIs are relative so the same playlist works under /audio.m3u8 and
+	// /receivers/{id}/audio.m3u8
+	next := 0
+	for _, seg := range segments {
+		for ; next < len(calls) && calls[next].start.Before(seg.end()); next++ {
+			if c := calls[next]; c.end == nil || c.end.After(seg.start) {
+				fmt.Fprintln(w, dateRange(c))
+			}
+		}
+		fmt.Fprintf(w, "#EXT-X-PROGRAM-DATE-TIME:%s\n", hlsTime(seg.start))
+		fmt.Fprintf(w, "#EXTINF:%.3f,\n", seg.duration.Seconds())
+		fmt.Fprintf(w, "audio/segment%d.ts\n", seg.seq)
 	}
 }
 
-// ServeSegment serves an audio segment
+func dateRange(c hlsCall) string {
+	attrs := []string{
+		fmt.Sprintf("ID=%q", c.id),
+		fmt.Sprintf("CLASS=%q", hlsDateRangeCls),
+		fmt.Sprintf("START-DATE=%q", hlsTime(c.start)),
+	}
+	if c.end != nil {
+		attrs = append(attrs, fmt.Sprintf("END-DATE=%q", hlsTime(*c.end)))
+	}
+	attrs = append(attrs,
+		fmt.Sprintf("X-CALL-ID=%q", c.callID),
+		fmt.Sprintf("X-TGID=%d", c.tgid),
+		fmt.Sprintf("X-SRCID=%d", c.firstSrc))
+	if c.tag != "" {
+		// Quoted strings can't hold quotes or line breaks
+		tag := strings.NewReplacer(`"`, "'", "\n", " ", "\r", " ").Replace(c.tag)
+		attrs = append(attrs, `X-ALPHA-TAG="`+tag+`"`)
+	}
+	return "#EXT-X-DATERANGE:" + strings.Join(attrs, ",")
+}
+
+func hlsTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+// ServeSegment serves an audio segment. Its talkgroup headers describe
+// the call heard for most of the segment, not whatever is live now.
 func (h *HLSBroadcaster) ServeSegment(w http.ResponseWriter, r *http.Request) {
 	var segmentNum int
-	fmt.Sscanf(r.URL.Path, "/audio/segment%d.wav", &segmentNum)
-	
+	fmt.Sscanf(path.Base(r.URL.Path), "segment%d.ts", &segmentNum)
+
 	h.mu.RLock()
-	startIndex := h.segmentIndex - len(h.segments)
-	if startIndex < 0 {
-		startIndex = 0
-	}
-	
-	segmentOffset := segmentNum - startIndex
-	if segmentOffset < 0 || segmentOffset >= len(h.segments) {
-		h.mu.RUnlock()
+	var seg *hlsSegment
+	for _, s := range h.segments {
+		if s.seq == segmentNum {
+			seg = s
+		}
+	}
+	var call *hlsCall
+	if seg != nil {
+		var best time.Duration
+		for _, c := range h.calls {
+			if d := c.overlap(seg.start, seg.end()); d > best {
+				best, call = d, c
+			}
+		}
+	}
+	h.mu.RUnlock()
+
+	if seg == nil {
 		http.Error(w, "Segment not found", http.StatusNotFound)
 		return
 	}
-	
-	segmentData := h.segments[segmentOffset]
-	h.mu.RUnlock()
-	
-	w.Header().Set("Content-Type", "audio/wav")
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(segmentData)))
+
+	w.Header().Set("Content-Type", "video/mp2t")
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(seg.data)))
 	w.Header().Set("Cache-Control", "no-cache")
-	
-	// Add talkgroup metadata
-	h.mu.RLock()
-	if h.tgGetter != nil {
-		if tg := h.tgGetter.GetActiveTalkgroup(); tg != nil {
-			w.Header().Set("X-Talkgroup-ID", fmt.Sprintf("%d", tg.GetTgid()))
-			w.Header().Set("X-Source-ID", fmt.Sprintf("%d", tg.GetSrcid()))
-		}
+	if call != nil {
+		w.Header().Set("X-Talkgroup-ID", fmt.Sprintf("%d", call.tgid))
+		w.Header().Set("X-Source-ID", fmt.Sprintf("%d", call.srcid))
 	}
-	h.mu.RUnlock()
-	
-	w.Write(segmentData)
-	log.Printf("Served HLS segment %d (%d bytes)", segmentNum, len(segmentData))
+
+	w.Write(seg.data)
+	log.Printf("Served HLS segment %d (%d bytes)", segmentNum, len(seg.data))
 }
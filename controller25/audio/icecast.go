@@ -0,0 +1,367 @@
+package audio
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"controller25/config"
+	"controller25/talkgroup"
+)
+
+const (
+	icecastDialTimeout  = 10 * time.Second
+	icecastWriteTimeout = 10 * time.Second
+	// Reconnect delays double from the first to the last on each failure
+	icecastMinBackoff = time.Second
+	icecastMaxBackoff = time.Minute
+	// A connection that lasted this long resets the backoff
+	icecastStable = time.Minute
+)
+
+// IcecastStatus is the state of an Icecast source connection.
+type IcecastStatus struct {
+	Connected bool
+	Since     time.Time // when the source connected or disconnected
+	Title     string    // last stream title sent
+	Error     string    // why the last connection failed or ended
+	Bytes     uint64    // MP3 sent over the current connection
+	Failures  int       // connection attempts that failed in a row
+}
+
+// IcecastSource pushes the live audio as MP3 to an Icecast mount point,
+// as a source client like darkice or ffmpeg would, and sets the stream
+// title to the talkgroup being heard. It reconnects with backoff when the
+// server goes away or refuses the mount.
+type IcecastSource struct {
+	cfg      config.IcecastConfig
+	endpoint *url.URL
+	client   *http.Client // for title updates
+
+	mu     sync.Mutex
+	status IcecastStatus
+
+	quit chan struct{}
+	once sync.Once
+}
+
+// NewIcecastSource checks a mount point's settings. Call Run to connect.
+func NewIcecastSource(cfg config.IcecastConfig) (*IcecastSource, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || u.Scheme != "http" || u.Host == "" {
+		return nil, fmt.Errorf("invalid Icecast URL %q: want http://host:port/mount", cfg.URL)
+	}
+	if u.Path == "" || u.Path == "/" {
+		return nil, fmt.Errorf("invalid Icecast URL %q: missing mount point", cfg.URL)
+	}
+	// Credentials may be given in the URL instead
+	if u.User != nil {
+		if cfg.Password == "" {
+			cfg.Password, _ = u.User.Password()
+		}
+		if name := u.User.Username(); name != "" {
+			cfg.Username = name
+		}
+		u.User = nil
+	}
+	if cfg.Username == "" {
+		cfg.Username = "source"
+	}
+	if u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), "8000")
+	}
+	return &IcecastSource{
+		cfg:      cfg,
+		endpoint: u,
+		client:   &http.Client{Timeout: icecastDialTimeout},
+		quit:     make(chan struct{}),
+	}, nil
+}
+
+// Config returns the mount point's settings.
+func (s *IcecastSource) Config() config.IcecastConfig {
+	return s.cfg
+}
+
+// Mount returns the server and mount point the source feeds, without
+// credentials.
+func (s *IcecastSource) Mount() string {
+	return s.endpoint.String()
+}
+
+// Status returns the state of the connection.
+func (s *IcecastSource) Status() IcecastStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
+
+// Run feeds the mount point from whichever broadcaster source returns
+// until Close, reconnecting whenever the connection drops. Like the RTP
+// sender it follows the receiver across OP25 restarts; there is no
+// source connection while OP25 is stopped.
+func (s *IcecastSource) Run(source func() *Broadcaster) {
+	backoff := icecastMinBackoff
+	for {
+		wait := rtpSubscribeWait
+		if ab := source(); ab != nil {
+			start := time.Now()
+			err := s.stream(ab, func() bool { return source() == ab })
+			s.disconnected(err)
+			if err != nil {
+				log.Printf("Icecast %s: %v", s.cfg.Name, err)
+				if time.Since(start) > icecastStable {
+					backoff = icecastMinBackoff
+				}
+				wait = backoff
+				backoff = min(2*backoff, icecastMaxBackoff)
+			} else {
+				backoff = icecastMinBackoff
+			}
+		}
+		select {
+		case <-s.quit:
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// Close disconnects from the server and stops the source.
+func (s *IcecastSource) Close() {
+	s.once.Do(func() { close(s.quit) })
+}
+
+// stream runs one source connection. It returns nil when the broadcaster
+// went away or the source was closed, and the error that ended it
+// otherwise.
+func (s *IcecastSource) stream(ab *Broadcaster, current func() bool) error {
+	conn, err := s.connect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// Stop writing as soon as Close is called
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-s.quit:
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	filter := Filter{Talkgroups: s.cfg.Talkgroups}
+	frames, leave := ab.joinCodec(codecs["mp3"], filter)
+	defer leave()
+
+	s.mu.Lock()
+	s.status = IcecastStatus{Connected: true, Since: time.Now(), Title: s.status.Title}
+	s.mu.Unlock()
+	log.Printf("Icecast %s: streaming to %s", s.cfg.Name, s.Mount())
+
+	titles := make(chan string, 1)
+	defer close(titles)
+	go s.updateTitles(titles)
+	ab.mu.Lock()
+	calls, alphaTag := ab.calls, ab.alphaTag
+	ab.mu.Unlock()
+	last := ""
+	retitle := func(c talkgroup.CallRecord) {
+		if title := callStreamTitle(c, alphaTag); title != last {
+			last = title
+			queueTitle(titles, title)
+		}
+	}
+	var events <-chan talkgroup.CallEvent
+	if calls != nil {
+		var unsubscribe func()
+		events, unsubscribe = calls.Subscribe()
+		defer unsubscribe()
+		for _, c := range calls.Active() {
+			if c.Playing && filter.Allows(c.Tgid) {
+				retitle(c)
+			}
+		}
+	}
+
+	check := time.NewTicker(rtpSubscribeWait)
+	defer check.Stop()
+	for {
+		select {
+		case data := <-frames:
+			_ = conn.SetWriteDeadline(time.Now().Add(icecastWriteTimeout))
+			if _, err := conn.Write(data); err != nil {
+				select {
+				case <-s.quit:
+					return nil
+				default:
+					return fmt.Errorf("connection lost: %v", err)
+				}
+			}
+			s.mu.Lock()
+			s.status.Bytes += uint64(len(data))
+			s.mu.Unlock()
+		case e := <-events:
+			c := e.Call
+			if c.Playing && e.Type != talkgroup.CallEnd && filter.Allows(c.Tgid) {
+				retitle(c)
+			}
+		case <-check.C:
+			if !current() {
+				return nil
+			}
+		case <-ab.quit:
+			return nil
+		case <-s.quit:
+			return nil
+		}
+	}
+}
+
+// connect opens the source connection with an HTTP PUT, which Icecast
+// 2.4 and later accept, and waits for the server to take the mount.
+func (s *IcecastSource) connect() (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", s.endpoint.Host, icecastDialTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("connect failed: %v", err)
+	}
+	public := "0"
+	if s.cfg.Public {
+		public = "1"
+	}
+	header := http.Header{}
+	header.Set("Authorization", "Basic "+basicAuth(s.cfg.Username, s.cfg.Password))
+	header.Set("User-Agent", "controller25")
+	header.Set("Content-Type", codecs["mp3"].ContentType)
+	header.Set("Expect", "100-continue")
+	header.Set("Ice-Public", public)
+	header.Set("Ice-Name", s.cfg.StreamName)
+	header.Set("Ice-Genre", s.cfg.Genre)
+	if s.cfg.Description != "" {
+		header.Set("Ice-Description", s.cfg.Description)
+	}
+	header.Set("Ice-Audio-Info", fmt.Sprintf("channels=1;samplerate=%d;bitrate=%d", mp3SampleRate, mp3Bitrate))
+
+	_ = conn.SetDeadline(time.Now().Add(icecastDialTimeout))
+	w := bufio.NewWriter(conn)
+	fmt.Fprintf(w, "PUT %s HTTP/1.1\r\nHost: %s\r\n", s.endpoint.RequestURI(), s.endpoint.Host)
+	_ = header.Write(w)
+	_, _ = w.WriteString("\r\n")
+	if err := w.Flush(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("connect failed: %v", err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("no response from server: %v", err)
+	}
+	// Don't close resp.Body: that would read until the server hangs up
+	switch resp.StatusCode {
+	case http.StatusContinue, http.StatusOK:
+	case http.StatusUnauthorized:
+		conn.Close()
+		return nil, fmt.Errorf("server refused the source password")
+	default:
+		// 403 is a mount point already in use, or one the server doesn't allow
+		conn.Close()
+		return nil, fmt.Errorf("server refused mount %s: %s", s.endpoint.Path, resp.Status)
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+func (s *IcecastSource) disconnected(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	wasConnected := s.status.Connected
+	s.status.Connected = false
+	s.status.Since = time.Now()
+	s.status.Error = ""
+	if err != nil {
+		s.status.Error = err.Error()
+		if !wasConnected {
+			s.status.Failures++
+		}
+	}
+}
+
+// updateTitles sends stream titles to the server's metadata endpoint
+// until titles is closed. Titles that pile up while a request is in
+// flight are skipped in favour of the newest.
+func (s *IcecastSource) updateTitles(titles <-chan string) {
+	for title := range titles {
+		if err := s.sendTitle(title); err != nil {
+			log.Printf("Icecast %s: failed to update title: %v", s.cfg.Name, err)
+			continue
+		}
+		s.mu.Lock()
+		s.status.Title = title
+		s.mu.Unlock()
+	}
+}
+
+func (s *IcecastSource) sendTitle(title string) error {
+	u := *s.endpoint
+	u.Path = "/admin/metadata"
+	u.RawQuery = url.Values{
+		"mount":   {s.endpoint.Path},
+		"mode":    {"updinfo"},
+		"song":    {title},
+		"charset": {"UTF-8"},
+	}.Encode()
+	ctx, cancel := context.WithTimeout(context.Background(), icecastDialTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(s.cfg.Username, s.cfg.Password)
+	req.Header.Set("User-Agent", "controller25")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("server answered %s", resp.Status)
+	}
+	return nil
+}
+
+// queueTitle queues a title for updateTitles, replacing one not yet sent.
+func queueTitle(titles chan string, title string) {
+	select {
+	case <-titles:
+	default:
+	}
+	titles <- title
+}
+
+// callStreamTitle names a call for stream titles: its alpha tag and
+// talkgroup, e.g. "Fire Dispatch (1001)".
+func callStreamTitle(c talkgroup.CallRecord, alphaTag func(tgid int) string) string {
+	tag := c.Tag
+	if alphaTag != nil {
+		if name := alphaTag(c.Tgid); name != "" {
+			tag = name
+		}
+	}
+	if tag == "" {
+		return fmt.Sprint(c.Tgid)
+	}
+	return fmt.Sprintf("%s (%d)", tag, c.Tgid)
+}
+
+func basicAuth(username, password string) string {
+	return base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+}
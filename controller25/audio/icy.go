@@ -0,0 +1,135 @@
+package audio
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"controller25/talkgroup"
+)
+
+const (
+	// icyMetaInt is how many bytes of audio go between metadata blocks
+	icyMetaInt = 8192
+	// A metadata block holds at most 255 16-byte units
+	icyMaxMeta = 255 * 16
+)
+
+// ServeICY streams the live audio as MP3 the way Icecast serves a mount
+// point. Players that ask for it with Icy-MetaData: 1 get the call being
+// heard as StreamTitle in the stream, e.g. "Fire Dispatch (1001) src
+// 1234567", updated as calls change. Calls f drops are silent and never
+// named.
+func (a *Broadcaster) ServeICY(w http.ResponseWriter, r *http.Request, f Filter, name string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	metadata := r.Header.Get("Icy-MetaData") == "1"
+	c := codecs["mp3"]
+	w.Header().Set("Content-Type", c.ContentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// Set as-is: some players match the icy- headers case-sensitively
+	icy := map[string]string{
+		"icy-name":  name,
+		"icy-genre": "Scanner",
+		"icy-br":    strconv.Itoa(mp3Bitrate),
+		"icy-sr":    strconv.Itoa(mp3SampleRate),
+		"icy-pub":   "0",
+	}
+	if metadata {
+		icy["icy-metaint"] = strconv.Itoa(icyMetaInt)
+	}
+	for k, v := range icy {
+		w.Header()[k] = []string{v}
+	}
+
+	ch, leave := a.joinCodec(c, f)
+	defer leave()
+
+	a.mu.Lock()
+	calls, alphaTag := a.calls, a.alphaTag
+	a.mu.Unlock()
+	title := ""
+	var events <-chan talkgroup.CallEvent
+	if metadata && calls != nil {
+		var unsubscribe func()
+		events, unsubscribe = calls.Subscribe()
+		defer unsubscribe()
+		for _, rec := range calls.Active() {
+			if rec.Playing && f.Allows(rec.Tgid) {
+				title = icyTitle(rec, alphaTag)
+			}
+		}
+	}
+	flusher.Flush()
+
+	sent := ""              // title in the last metadata block
+	untilMeta := icyMetaInt // audio bytes before the next block
+	notify := r.Context().Done()
+	for {
+		select {
+		case data := <-ch:
+			for len(data) > 0 {
+				n := len(data)
+				if metadata {
+					n = min(n, untilMeta)
+				}
+				if _, err := w.Write(data[:n]); err != nil {
+					return
+				}
+				data = data[n:]
+				untilMeta -= n
+				if metadata && untilMeta == 0 {
+					block := []byte{0} // nothing new
+					if title != sent {
+						block, sent = icyBlock(title), title
+					}
+					if _, err := w.Write(block); err != nil {
+						return
+					}
+					untilMeta = icyMetaInt
+				}
+			}
+			flusher.Flush()
+		case e := <-events:
+			rec := e.Call
+			if rec.Playing && e.Type != talkgroup.CallEnd && f.Allows(rec.Tgid) {
+				title = icyTitle(rec, alphaTag)
+			}
+		case <-a.quit:
+			return
+		case <-notify:
+			return
+		}
+	}
+}
+
+// icyTitle names a call in stream metadata: alpha tag, talkgroup and the
+// unit talking.
+func icyTitle(c talkgroup.CallRecord, alphaTag func(tgid int) string) string {
+	title := callStreamTitle(c, alphaTag)
+	if c.Srcid != 0 {
+		title += fmt.Sprintf(" src %d", c.Srcid)
+	}
+	return title
+}
+
+// icyBlock is a metadata block: a length byte counting 16-byte units,
+// then StreamTitle='...'; padded with zeros.
+func icyBlock(title string) []byte {
+	// Players read the title up to the next quote
+	title = strings.ReplaceAll(title, "'", "’")
+	meta := "StreamTitle='" + title + "';"
+	if len(meta) > icyMaxMeta {
+		meta = strings.ToValidUTF8(meta[:icyMaxMeta-2], "") + "';"
+	}
+	units := (len(meta) + 15) / 16
+	block := make([]byte, 1+units*16)
+	block[0] = byte(units)
+	copy(block[1:], meta)
+	return block
+}
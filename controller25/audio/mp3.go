@@ -0,0 +1,81 @@
+package audio
+
+import (
+	"bytes"
+
+	"github.com/braheezy/shine-mp3/pkg/mp3"
+)
+
+// MP3 is encoded at 16 kHz (MPEG-2 layer III) from the 8 kHz stream:
+// browsers and most decoders don't play MPEG-2.5, the only MP3 flavour
+// that runs at 8 kHz. Voice needs no more than 24 kbit/s.
+const (
+	mp3SampleRate = 16000
+	mp3Bitrate    = 24 // kbit/s
+	// mp3BitrateIndex is 24 kbit/s in the MPEG-2 bitrate table
+	mp3BitrateIndex = 3
+	// mp3FrameSamples is one MPEG-2 layer III frame (a single granule)
+	mp3FrameSamples = 576
+)
+
+type mp3Encoder struct {
+	enc      *mp3.Encoder
+	upsample int
+	last     int16 // previous input sample, for interpolation
+	pending  []int16
+	out      bytes.Buffer
+}
+
+func newMP3Encoder(sampleRate, channels int) encoder {
+	enc := mp3.NewEncoder(mp3SampleRate, channels)
+	// The encoder only offers 128 kbit/s; set the frame size for ours
+	enc.Mpeg.Bitrate = mp3Bitrate
+	enc.Mpeg.BitrateIndex = mp3BitrateIndex
+	// Counted in integers: 24 kbit/s at 16 kHz is exactly 108 bytes a
+	// frame, which floating point makes 107.999... and shine then pads
+	// every frame in the header but not in the data
+	enc.Mpeg.WholeSlotsPerFrame = enc.Mpeg.GranulesPerFrame * mp3FrameSamples * mp3Bitrate * 1000 / 8 / mp3SampleRate
+	enc.Mpeg.FracSlotsPerFrame = 0
+	enc.Mpeg.Slot_lag = 0
+	enc.Mpeg.Padding = 0
+	return &mp3Encoder{enc: enc, upsample: max(mp3SampleRate/sampleRate, 1)}
+}
+
+func (e *mp3Encoder) Encode(pcm []int16) []byte {
+	return bytes.Join(e.encodeFrames(pcm), nil)
+}
+
+// encodeFrames returns each whole MP3 frame the samples complete. A frame
+// covers mp3FrameSamples/upsample input samples.
+func (e *mp3Encoder) encodeFrames(pcm []int16) [][]byte {
+	// Linear interpolation is enough: the source has nothing above 4 kHz
+	for _, s := range pcm {
+		for i := 1; i <= e.upsample; i++ {
+			e.pending = append(e.pending, int16(int(e.last)+(int(s)-int(e.last))*i/e.upsample))
+		}
+		e.last = s
+	}
+	var frames [][]byte
+	// Write is only reliable when handed exactly one frame at a time
+	for len(e.pending) >= mp3FrameSamples {
+		e.out.Reset()
+		e.enc.Write(&e.out, e.pending[:mp3FrameSamples])
+		e.pending = e.pending[mp3FrameSamples:]
+		if e.out.Len() > 0 {
+			frames = append(frames, bytes.Clone(e.out.Bytes()))
+		}
+	}
+	e.pending = append([]int16(nil), e.pending...)
+	return frames
+}
+
+// EncodeMP3 compresses a whole recording of S16_LE PCM, padding the last
+// frame with silence.
+func EncodeMP3(pcm []byte, sampleRate, channels int) []byte {
+	e := newMP3Encoder(sampleRate, channels).(*mp3Encoder)
+	out := e.Encode(samples(pcm))
+	if n := len(e.pending); n > 0 {
+		out = append(out, e.Encode(make([]int16, (mp3FrameSamples-n+e.upsample-1)/e.upsample))...)
+	}
+	return out
+}
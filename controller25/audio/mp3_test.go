@@ -0,0 +1,133 @@
+package audio
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"testing"
+)
+
+// mp3Frame is what a frame header says about the frame.
+type mp3Frame struct {
+	bitrate    int // kbit/s
+	sampleRate int
+	padding    bool
+	length     int // bytes, header included
+}
+
+var (
+	mp3Bitrates1   = [15]int{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320}
+	mp3Bitrates2   = [15]int{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160}
+	mp3SampleRates = map[int][3]int{
+		3: {44100, 48000, 32000}, // MPEG-1
+		2: {22050, 24000, 16000}, // MPEG-2
+		0: {11025, 12000, 8000},  // MPEG-2.5
+	}
+)
+
+// parseMP3Header reads a layer III frame header.
+func parseMP3Header(b []byte) (mp3Frame, error) {
+	if len(b) < 4 {
+		return mp3Frame{}, fmt.Errorf("short header: % x", b)
+	}
+	if b[0] != 0xff || b[1]&0xe0 != 0xe0 {
+		return mp3Frame{}, fmt.Errorf("no frame sync: % x", b[:4])
+	}
+	version := int(b[1]>>3) & 3
+	rates, ok := mp3SampleRates[version]
+	if !ok {
+		return mp3Frame{}, fmt.Errorf("reserved MPEG version: % x", b[:4])
+	}
+	if layer := (b[1] >> 1) & 3; layer != 1 {
+		return mp3Frame{}, fmt.Errorf("not layer III: % x", b[:4])
+	}
+	bitrateIndex, rateIndex := int(b[2]>>4), int(b[2]>>2)&3
+	if bitrateIndex == 0 || bitrateIndex == 15 || rateIndex == 3 {
+		return mp3Frame{}, fmt.Errorf("bad bitrate or sample rate: % x", b[:4])
+	}
+	f := mp3Frame{sampleRate: rates[rateIndex], padding: b[2]&2 != 0}
+	coefficient := 72
+	if version == 3 {
+		f.bitrate = mp3Bitrates1[bitrateIndex]
+		coefficient = 144
+	} else {
+		f.bitrate = mp3Bitrates2[bitrateIndex]
+	}
+	f.length = coefficient * f.bitrate * 1000 / f.sampleRate
+	if f.padding {
+		f.length++
+	}
+	return f, nil
+}
+
+// checkMP3Frames walks data frame by frame, as a decoder would, and fails
+// unless every header is valid, each frame is as long as its header says
+// and the last one ends exactly at the end of data. With partial, as for
+// a capture of a live stream, the data may end mid-frame. It returns the
+// whole frames.
+func checkMP3Frames(t *testing.T, data []byte, partial bool) []mp3Frame {
+	t.Helper()
+	var frames []mp3Frame
+	for pos := 0; pos < len(data); {
+		if partial && len(data)-pos < 4 {
+			break
+		}
+		f, err := parseMP3Header(data[pos:])
+		if err != nil {
+			t.Fatalf("frame %d at byte %d: %v", len(frames), pos, err)
+		}
+		if partial && pos+f.length > len(data) {
+			break
+		}
+		if pos+f.length > len(data) {
+			t.Fatalf("frame %d at byte %d: header says %d bytes, only %d left", len(frames), pos, f.length, len(data)-pos)
+		}
+		frames = append(frames, f)
+		pos += f.length
+	}
+	return frames
+}
+
+// tone is S16_LE PCM of a 1 kHz sine.
+func tone(sampleRate int, d float64) []byte {
+	pcm := make([]byte, 2*int(float64(sampleRate)*d))
+	for i := 0; i < len(pcm)/2; i++ {
+		v := 8000 * math.Sin(2*math.Pi*1000*float64(i)/float64(sampleRate))
+		binary.LittleEndian.PutUint16(pcm[2*i:], uint16(int16(v)))
+	}
+	return pcm
+}
+
+func TestMP3FrameLengths(t *testing.T) {
+	enc := newMP3Encoder(8000, 1)
+	pcm := samples(tone(8000, 2))
+	// Fed in OP25-sized pieces, as the live stream is
+	var data []byte
+	for len(pcm) > 0 {
+		n := min(len(pcm), 160)
+		data = append(data, enc.Encode(pcm[:n])...)
+		pcm = pcm[n:]
+	}
+	frames := checkMP3Frames(t, data, false)
+	// 2 s at 16 kHz is 55 whole frames of 576 samples
+	if len(frames) != 55 {
+		t.Errorf("got %d frames, want 55", len(frames))
+	}
+	for i, f := range frames {
+		if f.bitrate != mp3Bitrate || f.sampleRate != mp3SampleRate || f.padding || f.length != 108 {
+			t.Fatalf("frame %d: %+v, want %d kbit/s at %d Hz in 108 bytes without padding", i, f, mp3Bitrate, mp3SampleRate)
+		}
+	}
+}
+
+func TestEncodeMP3(t *testing.T) {
+	for _, rate := range []int{8000, 16000} {
+		data := EncodeMP3(tone(rate, 1.01), rate, 1)
+		frames := checkMP3Frames(t, data, false)
+		// The last partial frame is padded out with silence
+		want := int(math.Ceil(1.01 * mp3SampleRate / mp3FrameSamples))
+		if len(frames) != want {
+			t.Errorf("%d Hz: got %d frames, want %d", rate, len(frames), want)
+		}
+	}
+}
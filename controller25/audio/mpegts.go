@@ -0,0 +1,252 @@
+package audio
+
+import "encoding/binary"
+
+// A minimal MPEG-TS muxer for HLS: one program with an MPEG audio stream
+// and an ID3 timed metadata stream, as described in Apple's "Timed
+// Metadata for HTTP Live Streaming".
+const (
+	tsPacketSize = 188
+	tsPMTPID     = 0x1000
+	tsAudioPID   = 0x0101
+	tsID3PID     = 0x0102
+
+	// MPEG-2 audio (ISO/IEC 13818-3), which is what the 16 kHz MP3 is
+	tsStreamTypeMPEG2Audio = 0x04
+	tsStreamTypeMetadata   = 0x15
+
+	// Timestamps are in 90 kHz units; PCR runs this far behind the PTS
+	tsClock     = 90000
+	tsPCRLead   = tsClock / 10
+	tsPTSOffset = tsClock // first PTS, so PCR never goes negative
+)
+
+// tsMuxer writes segments of one continuous stream. Continuity counters
+// carry over from one segment to the next, so segments play back to back.
+type tsMuxer struct {
+	cc map[uint16]byte
+}
+
+func newTSMuxer() *tsMuxer {
+	return &tsMuxer{cc: make(map[uint16]byte)}
+}
+
+// segment starts a segment: every one begins with the PAT and PMT so a
+// player can start from any of them.
+func (m *tsMuxer) segment() []byte {
+	var out []byte
+	out = m.psi(out, 0, patSection())
+	out = m.psi(out, tsPMTPID, pmtSection())
+	return out
+}
+
+// audio appends a PES packet of MP3 frames starting at pts. The first
+// packet of a segment carries the PCR.
+func (m *tsMuxer) audio(out []byte, frames []byte, pts int64, pcr bool) []byte {
+	pes := pesPacket(0xC0, 0x80, pts, frames)
+	return m.packets(out, tsAudioPID, pes, pts, pcr)
+}
+
+// id3 appends an ID3 tag as timed metadata at pts.
+func (m *tsMuxer) id3(out []byte, tag []byte, pts int64) []byte {
+	// private_stream_1, data aligned
+	pes := pesPacket(0xBD, 0x84, pts, tag)
+	return m.packets(out, tsID3PID, pes, 0, false)
+}
+
+func (m *tsMuxer) psi(out []byte, pid uint16, section []byte) []byte {
+	pkt := make([]byte, tsPacketSize)
+	for i := range pkt {
+		pkt[i] = 0xFF
+	}
+	m.header(pkt, pid, true, 1)
+	pkt[4] = 0 // pointer field
+	copy(pkt[5:], section)
+	return append(out, pkt...)
+}
+
+// packets splits a PES packet into TS packets, padding the last one with
+// adaptation field stuffing.
+func (m *tsMuxer) packets(out []byte, pid uint16, pes []byte, pts int64, pcr bool) []byte {
+	first := true
+	for len(pes) > 0 {
+		var af []byte // adaptation field after its length byte
+		hasAF := false
+		if first && pcr {
+			// Random access indicator and PCR
+			af = append([]byte{0x50}, pcrBytes(pts-tsPCRLead)...)
+			hasAF = true
+		}
+		room := tsPacketSize - 4
+		if hasAF {
+			room -= 1 + len(af)
+		}
+		n := min(room, len(pes))
+		if stuff := room - n; stuff > 0 {
+			switch {
+			case hasAF:
+				af = append(af, stuffing(stuff)...)
+			case stuff == 1:
+				hasAF = true // an empty adaptation field is just its length
+			default:
+				af = append([]byte{0x00}, stuffing(stuff-2)...)
+				hasAF = true
+			}
+		}
+
+		pkt := make([]byte, 4, tsPacketSize)
+		control := byte(1)
+		if hasAF {
+			control = 3
+		}
+		m.header(pkt, pid, first, control)
+		if hasAF {
+			pkt = append(pkt, byte(len(af)))
+			pkt = append(pkt, af...)
+		}
+		pkt = append(pkt, pes[:n]...)
+		out = append(out, pkt...)
+		pes = pes[n:]
+		first = false
+	}
+	return out
+}
+
+func (m *tsMuxer) header(pkt []byte, pid uint16, start bool, control byte) {
+	pkt[0] = 0x47
+	pkt[1] = byte(pid >> 8 & 0x1F)
+	if start {
+		pkt[1] |= 0x40
+	}
+	pkt[2] = byte(pid)
+	pkt[3] = control<<4 | m.cc[pid]
+	m.cc[pid] = (m.cc[pid] + 1) & 0x0F
+}
+
+func stuffing(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = 0xFF
+	}
+	return b
+}
+
+func pesPacket(streamID, flags byte, pts int64, payload []byte) []byte {
+	pes := []byte{0, 0, 1, streamID, 0, 0, flags, 0x80, 5}
+	binary.BigEndian.PutUint16(pes[4:6], uint16(3+5+len(payload)))
+	pes = append(pes, ptsBytes(pts)...)
+	return append(pes, payload...)
+}
+
+func ptsBytes(pts int64) []byte {
+	pts &= 1<<33 - 1
+	return []byte{
+		0x21 | byte(pts>>29)&0x0E,
+		byte(pts >> 22),
+		byte(pts>>14) | 1,
+		byte(pts >> 7),
+		byte(pts<<1) | 1,
+	}
+}
+
+func pcrBytes(pcr int64) []byte {
+	pcr &= 1<<33 - 1
+	return []byte{
+		byte(pcr >> 25),
+		byte(pcr >> 17),
+		byte(pcr >> 9),
+		byte(pcr >> 1),
+		byte(pcr<<7) | 0x7E,
+		0,
+	}
+}
+
+func patSection() []byte {
+	s := []byte{
+		0x00, 0xB0, 0, // table_id, section_length below
+		0x00, 0x01, // transport_stream_id
+		0xC1, 0x00, 0x00, // version 0, current
+		0x00, 0x01, 0xE0 | tsPMTPID>>8, tsPMTPID & 0xFF, // program 1
+	}
+	return psiSection(s)
+}
+
+func pmtSection() []byte {
+	s := []byte{
+		0x02, 0xB0, 0,
+		0x00, 0x01, // program_number
+		0xC1, 0x00, 0x00,
+		0xE0 | tsAudioPID>>8, tsAudioPID & 0xFF, // PCR PID
+	}
+	// metadata_pointer_descriptor: ID3 metadata in this program
+	pointer := []byte{0x25, 15, 0xFF, 0xFF, 'I', 'D', '3', ' ', 0xFF, 'I', 'D', '3', ' ', 0x00, 0x1F, 0x00, 0x01}
+	s = append(s, 0xF0, byte(len(pointer)))
+	s = append(s, pointer...)
+	s = append(s, tsStreamTypeMPEG2Audio, 0xE0|tsAudioPID>>8, tsAudioPID&0xFF, 0xF0, 0x00)
+	// metadata_descriptor for the ID3 stream
+	desc := []byte{0x26, 13, 0xFF, 0xFF, 'I', 'D', '3', ' ', 0xFF, 'I', 'D', '3', ' ', 0x00, 0x0F}
+	s = append(s, tsStreamTypeMetadata, 0xE0|tsID3PID>>8, tsID3PID&0xFF, 0xF0, byte(len(desc)))
+	s = append(s, desc...)
+	return psiSection(s)
+}
+
+// psiSection fills in the section length and appends the CRC.
+func psiSection(s []byte) []byte {
+	length := len(s) - 3 + 4
+	s[1] |= byte(length >> 8)
+	s[2] = byte(length)
+	return binary.BigEndian.AppendUint32(s, crc32MPEG(s))
+}
+
+var crcTable = func() [256]uint32 {
+	var t [256]uint32
+	for i := range t {
+		c := uint32(i) << 24
+		for range 8 {
+			if c&0x80000000 != 0 {
+				c = c<<1 ^ 0x04C11DB7
+			} else {
+				c <<= 1
+			}
+		}
+		t[i] = c
+	}
+	return t
+}()
+
+// crc32MPEG is the CRC-32/MPEG-2 that PSI sections end with.
+func crc32MPEG(data []byte) uint32 {
+	crc := uint32(0xFFFFFFFF)
+	for _, b := range data {
+		crc = crc<<8 ^ crcTable[byte(crc>>24)^b]
+	}
+	return crc
+}
+
+// id3Tag builds an ID3v2.4 tag with a title (TIT2, what players show)
+// and a TXXX frame per field.
+func id3Tag(title string, fields [][2]string) []byte {
+	var frames []byte
+	frame := func(id string, body []byte) {
+		frames = append(frames, id...)
+		frames = append(frames, syncsafe(len(body))...)
+		frames = append(frames, 0, 0)
+		frames = append(frames, body...)
+	}
+	if title != "" {
+		frame("TIT2", append([]byte{3}, title...)) // UTF-8
+	}
+	for _, f := range fields {
+		body := append([]byte{3}, f[0]...)
+		body = append(body, 0)
+		body = append(body, f[1]...)
+		frame("TXXX", body)
+	}
+	tag := append([]byte("ID3"), 4, 0, 0)
+	tag = append(tag, syncsafe(len(frames))...)
+	return append(tag, frames...)
+}
+
+func syncsafe(n int) []byte {
+	return []byte{byte(n >> 21 & 0x7F), byte(n >> 14 & 0x7F), byte(n >> 7 & 0x7F), byte(n & 0x7F)}
+}
@@ -0,0 +1,266 @@
+package audio
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"controller25/config"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	rtpVersion       = 2
+	rtpClockRate     = 8000 // OP25's audio rate
+	rtpPacketTime    = 20 * time.Millisecond
+	rtpPayloadPCMU   = 0  // static, RFC 3551
+	rtpPayloadL16    = 96 // dynamic: the static L16 types are 44.1 kHz
+	rtpSubscribeWait = time.Second
+)
+
+// RTPCodecs are the payload formats an RTP destination can use.
+var RTPCodecs = []string{"pcmu", "l16"}
+
+// RTPStatus is what an RTP sender has sent so far.
+type RTPStatus struct {
+	Packets  uint64
+	LastSent time.Time
+}
+
+// RTPSender sends the live audio to one unicast or multicast destination
+// as RTP, 20 ms to a packet. Frames of calls the destination's talkgroup
+// filter drops are not sent at all, and the next packet after a pause
+// carries the marker bit, so receivers see talk spurts as RFC 3551 has
+// them.
+type RTPSender struct {
+	cfg  config.RTPConfig
+	conn *net.UDPConn
+	dest *net.UDPAddr
+
+	ssrc    uint32
+	seq     uint16
+	ts      uint32
+	pending []int16
+	last    time.Time // when the last frame was queued
+
+	mu     sync.Mutex
+	status RTPStatus
+
+	quit chan struct{}
+	once sync.Once
+}
+
+// NewRTPSender opens the socket for a destination. Call Run to start
+// sending.
+func NewRTPSender(cfg config.RTPConfig) (*RTPSender, error) {
+	if cfg.Codec == "" {
+		cfg.Codec = "pcmu"
+	}
+	if !strings.EqualFold(cfg.Codec, "pcmu") && !strings.EqualFold(cfg.Codec, "l16") {
+		return nil, fmt.Errorf("unknown RTP codec %q (want %s)", cfg.Codec, strings.Join(RTPCodecs, " or "))
+	}
+	cfg.Codec = strings.ToLower(cfg.Codec)
+	if cfg.TTL < 1 || cfg.TTL > 255 {
+		cfg.TTL = 1
+	}
+	dest, err := net.ResolveUDPAddr("udp", cfg.Address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid RTP address %q: %v", cfg.Address, err)
+	}
+	if dest.Port == 0 {
+		return nil, fmt.Errorf("invalid RTP address %q: missing port", cfg.Address)
+	}
+	conn, err := net.DialUDP("udp", nil, dest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open RTP socket: %v", err)
+	}
+	if dest.IP.IsMulticast() {
+		if dest.IP.To4() != nil {
+			err = ipv4.NewPacketConn(conn).SetMulticastTTL(cfg.TTL)
+		} else {
+			err = ipv6.NewPacketConn(conn).SetMulticastHopLimit(cfg.TTL)
+		}
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to set multicast TTL: %v", err)
+		}
+	}
+	return &RTPSender{
+		cfg:  cfg,
+		conn: conn,
+		dest: dest,
+		ssrc: rand.Uint32(),
+		seq:  uint16(rand.Uint32()),
+		ts:   rand.Uint32(),
+		quit: make(chan struct{}),
+	}, nil
+}
+
+// Config returns the destination's settings.
+func (s *RTPSender) Config() config.RTPConfig {
+	return s.cfg
+}
+
+// Status returns how much the sender has sent.
+func (s *RTPSender) Status() RTPStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
+
+// Run sends the audio of whichever broadcaster source returns until
+// Close. The receiver replaces its broadcaster each time OP25 starts, so
+// source is asked again whenever the current one goes away; it returns
+// nil while there is none.
+func (s *RTPSender) Run(source func() *Broadcaster) {
+	defer s.conn.Close()
+	for {
+		if ab := source(); ab != nil {
+			frames, unsubscribe := ab.subscribeFiltered(Filter{Talkgroups: s.cfg.Talkgroups}, 100)
+			s.forward(frames, func() bool { return source() == ab })
+			unsubscribe()
+		}
+		select {
+		case <-s.quit:
+			return
+		case <-time.After(rtpSubscribeWait):
+		}
+	}
+}
+
+// Close stops the sender.
+func (s *RTPSender) Close() {
+	s.once.Do(func() { close(s.quit) })
+}
+
+// forward sends frames until the channel closes, current reports that
+// the broadcaster was replaced, or the sender is closed.
+func (s *RTPSender) forward(frames <-chan []byte, current func() bool) {
+	check := time.NewTicker(rtpSubscribeWait)
+	defer check.Stop()
+	for {
+		select {
+		case pcm, ok := <-frames:
+			if !ok {
+				return
+			}
+			s.add(pcm)
+		case <-check.C:
+			if !current() {
+				return
+			}
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+// add queues a frame and sends every full packet. Silent frames, which
+// is what the filter leaves of the calls it drops, are not sent.
+func (s *RTPSender) add(pcm []byte) {
+	if silent(pcm) {
+		return
+	}
+	now := time.Now()
+	marker := false
+	if s.last.IsZero() || now.Sub(s.last) > filterGap {
+		// A new talk spurt. The timestamp keeps counting through the
+		// pause; the unsent end of the last spurt is dropped.
+		if !s.last.IsZero() {
+			s.ts += uint32(now.Sub(s.last).Seconds() * rtpClockRate)
+		}
+		s.pending = s.pending[:0]
+		marker = true
+	}
+	s.last = now
+	s.pending = append(s.pending, samples(pcm)...)
+
+	n := int(rtpPacketTime.Seconds() * rtpClockRate)
+	for len(s.pending) >= n {
+		s.send(s.pending[:n], marker)
+		marker = false
+		s.pending = s.pending[n:]
+	}
+}
+
+func (s *RTPSender) send(pcm []int16, marker bool) {
+	packet := make([]byte, 12, 12+2*len(pcm))
+	packet[0] = rtpVersion << 6
+	packet[1] = s.payloadType()
+	if marker {
+		packet[1] |= 0x80
+	}
+	binary.BigEndian.PutUint16(packet[2:], s.seq)
+	binary.BigEndian.PutUint32(packet[4:], s.ts)
+	binary.BigEndian.PutUint32(packet[8:], s.ssrc)
+	if s.cfg.Codec == "l16" {
+		for _, v := range pcm {
+			packet = binary.BigEndian.AppendUint16(packet, uint16(v))
+		}
+	} else {
+		packet = append(packet, ulawEncoder{}.Encode(pcm)...)
+	}
+	s.seq++
+	s.ts += uint32(len(pcm))
+
+	// Nobody may be listening yet; ICMP port unreachable is not an error
+	_, _ = s.conn.Write(packet)
+	s.mu.Lock()
+	s.status.Packets++
+	s.status.LastSent = time.Now()
+	s.mu.Unlock()
+}
+
+func (s *RTPSender) payloadType() byte {
+	if s.cfg.Codec == "l16" {
+		return rtpPayloadL16
+	}
+	return rtpPayloadPCMU
+}
+
+// SDP describes the stream for players like VLC and ffplay, which open
+// the .sdp file and listen on the destination port.
+func (s *RTPSender) SDP() string {
+	network, ttl := "IP4", ""
+	if s.dest.IP.To4() == nil {
+		network = "IP6"
+	} else if s.dest.IP.IsMulticast() {
+		ttl = fmt.Sprintf("/%d", s.cfg.TTL)
+	}
+	origin := "0.0.0.0"
+	if local, ok := s.conn.LocalAddr().(*net.UDPAddr); ok {
+		origin = local.IP.String()
+	}
+	encoding := fmt.Sprintf("PCMU/%d", rtpClockRate)
+	if s.cfg.Codec == "l16" {
+		encoding = fmt.Sprintf("L16/%d/1", rtpClockRate)
+	}
+	lines := []string{
+		"v=0",
+		fmt.Sprintf("o=- %d 1 IN %s %s", s.ssrc, network, origin),
+		"s=" + s.cfg.Name,
+		fmt.Sprintf("c=IN %s %s%s", network, s.dest.IP, ttl),
+		"t=0 0",
+		fmt.Sprintf("m=audio %d RTP/AVP %d", s.dest.Port, s.payloadType()),
+		fmt.Sprintf("a=rtpmap:%d %s", s.payloadType(), encoding),
+		fmt.Sprintf("a=ptime:%d", rtpPacketTime.Milliseconds()),
+		"a=recvonly",
+	}
+	return strings.Join(lines, "\r\n") + "\r\n"
+}
+
+// silent reports whether a frame is digital silence.
+func silent(pcm []byte) bool {
+	for _, b := range pcm {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
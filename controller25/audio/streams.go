@@ -0,0 +1,520 @@
+package audio
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"controller25/pubsub"
+	"controller25/talkgroup"
+)
+
+const (
+	// mixFrame is how much audio the mixer puts out at a time
+	mixFrame = 20 * time.Millisecond
+	// mixPrebuffer is how much a stream buffers before it is mixed in, to
+	// ride out the jitter between streams
+	mixPrebuffer = 60 * time.Millisecond
+	// mixMaxQueue is how far a stream may run ahead before its oldest
+	// audio is dropped
+	mixMaxQueue = 500 * time.Millisecond
+	// mixDuck is how far streams below the top priority are turned down
+	mixDuck = -12 // dB
+)
+
+// CallSource reports the calls a stream carries: the calls in progress
+// and events as they start, change and end, with Playing set while the
+// stream carries the call. *talkgroup.CallDetector is one. Subscribe
+// must not drop events, and closes the channel on unsubscribe.
+type CallSource interface {
+	Active() []talkgroup.CallRecord
+	Subscribe() (<-chan talkgroup.CallEvent, func())
+}
+
+// StreamStatus describes one stream of a broadcaster.
+type StreamStatus struct {
+	Stream   int                   `json:"stream"`
+	Address  string                `json:"address"` // UDP address OP25 sends it to
+	Active   bool                  `json:"active"`  // carrying audio right now
+	Priority int                   `json:"priority,omitempty"`
+	Call     *talkgroup.CallRecord `json:"call,omitempty"`
+}
+
+// NewMultiBroadcaster returns a broadcaster for OP25 audio arriving as
+// several streams, one per UDP address: both slots of a TDMA channel, or
+// several voice channels. Each stream is a Broadcaster of its own (see
+// Stream), labeled with the call it carries; the returned one plays them
+// mixed, the most important talkgroup on top. With a single address it
+// is NewBroadcaster.
+func NewMultiBroadcaster(udpAddrs []string) *Broadcaster {
+	if len(udpAddrs) == 1 {
+		return NewBroadcaster(udpAddrs[0])
+	}
+	b := NewBroadcaster("")
+	m := &streamMixer{
+		out:   b,
+		mixed: newStreamCalls(),
+	}
+	for _, addr := range udpAddrs {
+		s := NewBroadcaster(addr)
+		label := newStreamCalls()
+		s.tgGetter, s.calls = label, label
+		m.streams = append(m.streams, &mixerStream{b: s, label: label})
+	}
+	b.mixer = m
+	b.tgGetter, b.calls = m.mixed, m.mixed
+	return b
+}
+
+// Stream returns stream n, or nil. A single-stream broadcaster is its own
+// stream 0.
+func (a *Broadcaster) Stream(n int) *Broadcaster {
+	if a.mixer == nil {
+		if n == 0 {
+			return a
+		}
+		return nil
+	}
+	if n < 0 || n >= len(a.mixer.streams) {
+		return nil
+	}
+	return a.mixer.streams[n].b
+}
+
+// Streams returns the streams that are mixed into a, none when it has a
+// single stream.
+func (a *Broadcaster) Streams() []*Broadcaster {
+	if a.mixer == nil {
+		return nil
+	}
+	streams := make([]*Broadcaster, len(a.mixer.streams))
+	for i, s := range a.mixer.streams {
+		streams[i] = s.b
+	}
+	return streams
+}
+
+// StreamStatus returns the state of each stream.
+func (a *Broadcaster) StreamStatus() []StreamStatus {
+	if a.mixer == nil {
+		a.mu.Lock()
+		calls := a.calls
+		active := time.Since(a.lastAudio) < filterGap
+		a.mu.Unlock()
+		status := StreamStatus{Stream: 0, Address: a.udpAddr, Active: active}
+		if calls != nil {
+			for _, rec := range calls.Active() {
+				if rec.Playing {
+					status.Call = &rec
+				}
+			}
+		}
+		return []StreamStatus{status}
+	}
+	return a.mixer.status()
+}
+
+// SetPriority sets how important a talkgroup is when streams are mixed:
+// lower numbers are more important, as in OP25's talkgroups file.
+func (a *Broadcaster) SetPriority(fn func(tgid int) int) {
+	if a.mixer == nil {
+		return
+	}
+	a.mixer.mu.Lock()
+	defer a.mixer.mu.Unlock()
+	a.mixer.priority = fn
+}
+
+// streamCalls is the CallSource of a stream of a multi-stream
+// broadcaster: the one call it carries. It is also the stream's
+// TalkgroupGetter.
+type streamCalls struct {
+	mu          sync.Mutex
+	current     *talkgroup.CallRecord // Playing, or nil
+	subscribers map[*pubsub.Queue[talkgroup.CallEvent]]struct{}
+}
+
+func newStreamCalls() *streamCalls {
+	return &streamCalls{subscribers: make(map[*pubsub.Queue[talkgroup.CallEvent]]struct{})}
+}
+
+func (s *streamCalls) Active() []talkgroup.CallRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.current == nil {
+		return []talkgroup.CallRecord{}
+	}
+	return []talkgroup.CallRecord{*s.current}
+}
+
+// Subscribe is the call detector's: lossless, and the channel is closed
+// on unsubscribe.
+func (s *streamCalls) Subscribe() (<-chan talkgroup.CallEvent, func()) {
+	q := pubsub.NewQueue[talkgroup.CallEvent]()
+	s.mu.Lock()
+	s.subscribers[q] = struct{}{}
+	s.mu.Unlock()
+	return q.C(), func() {
+		s.mu.Lock()
+		delete(s.subscribers, q)
+		s.mu.Unlock()
+		q.Close()
+	}
+}
+
+func (s *streamCalls) GetActiveTalkgroup() interface {
+	GetTgid() int
+	GetSrcid() int
+} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.current == nil {
+		return nil
+	}
+	return streamTalkgroup{tgid: s.current.Tgid, srcid: s.current.Srcid}
+}
+
+// call returns the call the stream carries, or nil.
+func (s *streamCalls) call() *talkgroup.CallRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.current == nil {
+		return nil
+	}
+	c := *s.current
+	return &c
+}
+
+// carry switches the stream to rec, or to no call when rec is nil.
+func (s *streamCalls) carry(rec *talkgroup.CallRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case rec == nil && s.current == nil:
+		return
+	case rec != nil && s.current != nil && rec.ID == s.current.ID:
+		return
+	}
+	now := time.Now()
+	if s.current != nil {
+		prev := *s.current
+		prev.Playing = false
+		s.publishLocked(talkgroup.CallUpdate, prev, now)
+		s.current = nil
+	}
+	if rec != nil {
+		c := *rec
+		c.Playing = true
+		s.current = &c
+		s.publishLocked(talkgroup.CallUpdate, c, now)
+	}
+}
+
+// update passes on an event for the call the stream carries.
+func (s *streamCalls) update(e talkgroup.CallEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.current == nil || s.current.ID != e.Call.ID {
+		return
+	}
+	c := e.Call
+	if e.Type == talkgroup.CallEnd {
+		s.current = nil
+	} else {
+		c.Playing = true
+		s.current = &c
+	}
+	s.publishLocked(e.Type, c, e.Time)
+}
+
+func (s *streamCalls) publishLocked(t talkgroup.CallEventType, c talkgroup.CallRecord, now time.Time) {
+	e := talkgroup.CallEvent{Type: t, Time: now, Call: c}
+	for q := range s.subscribers {
+		q.Push(e)
+	}
+}
+
+type streamTalkgroup struct {
+	tgid, srcid int
+}
+
+func (t streamTalkgroup) GetTgid() int  { return t.tgid }
+func (t streamTalkgroup) GetSrcid() int { return t.srcid }
+
+// mixerStream is one stream as the mixer sees it.
+type mixerStream struct {
+	b     *Broadcaster
+	label *streamCalls
+
+	queue     []int16
+	running   bool      // being mixed in
+	runAt     time.Time // when the current run of audio began
+	lastAudio time.Time
+	prevAudio time.Time // last audio of the run before
+}
+
+// streamMixer labels each stream with the call it carries and mixes the
+// streams into out.
+type streamMixer struct {
+	out     *Broadcaster
+	streams []*mixerStream
+	mixed   *streamCalls // the call on top of the mix
+
+	mu       sync.Mutex // guards upstream and priority
+	upstream CallSource
+	priority func(tgid int) int
+}
+
+func (m *streamMixer) setUpstream(calls CallSource) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.upstream = calls
+}
+
+type streamFrame struct {
+	stream int
+	pcm    []byte
+}
+
+// run mixes until quit is closed.
+func (m *streamMixer) run(quit chan struct{}) {
+	in := make(chan streamFrame, 256)
+	for i, s := range m.streams {
+		frames, _ := s.b.Subscribe(256)
+		go func() {
+			for pcm := range frames {
+				select {
+				case in <- streamFrame{stream: i, pcm: pcm}:
+				case <-quit:
+					return
+				}
+			}
+		}()
+	}
+
+	m.mu.Lock()
+	upstream := m.upstream
+	m.mu.Unlock()
+	var events <-chan talkgroup.CallEvent
+	if upstream != nil {
+		var unsubscribe func()
+		events, unsubscribe = upstream.Subscribe()
+		defer unsubscribe()
+	}
+
+	ticker := time.NewTicker(mixFrame)
+	defer ticker.Stop()
+	for {
+		select {
+		case f := <-in:
+			m.add(f, upstream)
+		case e := <-events:
+			m.observe(e, upstream)
+		case <-ticker.C:
+			m.mix()
+		case <-quit:
+			return
+		}
+	}
+}
+
+func (m *streamMixer) add(f streamFrame, upstream CallSource) {
+	s := m.streams[f.stream]
+	now := time.Now()
+	newRun := now.Sub(s.lastAudio) > filterGap
+	if newRun {
+		s.prevAudio = s.lastAudio
+		s.runAt = now
+	}
+	s.lastAudio = now
+	s.queue = append(s.queue, samples(f.pcm)...)
+	if limit := m.samples(mixMaxQueue); len(s.queue) > limit {
+		s.queue = append([]int16(nil), s.queue[len(s.queue)-limit:]...)
+	}
+	if newRun && upstream != nil {
+		m.label(f.stream, upstream.Active())
+	}
+}
+
+func (m *streamMixer) observe(e talkgroup.CallEvent, upstream CallSource) {
+	for _, s := range m.streams {
+		s.label.update(e)
+	}
+	m.mixed.update(e)
+	// Calls are reported a moment after their audio starts; label the
+	// streams that are playing now
+	calls := upstream.Active()
+	for i, s := range m.streams {
+		if time.Since(s.lastAudio) < filterGap {
+			m.label(i, calls)
+		}
+	}
+}
+
+// label picks the call stream i carries from the calls in progress. The
+// stream OP25 follows (0) carries the call it plays; the others carry
+// calls on the same frequency, i.e. the other TDMA slot, first, then the
+// most recent call no other stream has.
+func (m *streamMixer) label(i int, calls []talkgroup.CallRecord) {
+	s := m.streams[i]
+	claimed := map[string]bool{}
+	for j, other := range m.streams {
+		if c := other.label.call(); c != nil && j != i {
+			claimed[c.ID] = true
+		}
+	}
+	var playingFreq int64
+	for _, c := range calls {
+		if c.Playing {
+			playingFreq = c.Frequency
+		}
+	}
+	var candidates []talkgroup.CallRecord
+	for _, c := range calls {
+		if !claimed[c.ID] && (i == 0 || !c.Playing) {
+			candidates = append(candidates, c)
+		}
+	}
+	rank := func(c talkgroup.CallRecord) int {
+		switch {
+		case i == 0 && c.Playing:
+			return 2
+		case i > 0 && playingFreq != 0 && c.Frequency == playingFreq:
+			return 1
+		}
+		return 0
+	}
+	sort.SliceStable(candidates, func(a, b int) bool {
+		if ra, rb := rank(candidates[a]), rank(candidates[b]); ra != rb {
+			return ra > rb
+		}
+		return candidates[a].Start.After(candidates[b].Start)
+	})
+
+	// Keep the call the stream carries unless a better or newer one has
+	// come along since its last run of audio
+	if cur := s.label.call(); cur != nil {
+		for _, c := range candidates {
+			if c.ID != cur.ID {
+				continue
+			}
+			best := candidates[0]
+			if best.ID == c.ID || (rank(best) == rank(c) && !best.Start.After(s.prevAudio)) {
+				return
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		s.label.carry(nil)
+		return
+	}
+	s.label.carry(&candidates[0])
+}
+
+// mix puts out one frame of the streams that are running.
+func (m *streamMixer) mix() {
+	frame := m.samples(mixFrame)
+	var running []*mixerStream
+	for _, s := range m.streams {
+		// A short burst is mixed in once its last audio is old enough
+		if !s.running && (len(s.queue) >= m.samples(mixPrebuffer) || len(s.queue) > 0 && time.Since(s.lastAudio) > mixPrebuffer) {
+			s.running = true
+		}
+		if s.running {
+			running = append(running, s)
+		}
+	}
+	if len(running) == 0 {
+		// The mix keeps its call through pauses, as the calls do
+		return
+	}
+
+	top := m.top(running)
+	m.mixed.carry(top.label.call())
+	duck := dbToGain(mixDuck)
+	sum := make([]float64, frame)
+	for _, s := range running {
+		gain := 1.0
+		if s != top {
+			gain = duck
+		}
+		n := min(frame, len(s.queue))
+		for i, v := range s.queue[:n] {
+			sum[i] += float64(v) * gain
+		}
+		s.queue = s.queue[n:]
+		if len(s.queue) == 0 {
+			s.running = false
+			s.queue = nil
+		}
+	}
+	pcm := make([]byte, 2*frame)
+	for i, v := range sum {
+		binary.LittleEndian.PutUint16(pcm[2*i:], uint16(int16(min(max(v, -32768), 32767))))
+	}
+	m.out.broadcast(pcm)
+}
+
+// top returns the running stream with the most important talkgroup; on a
+// tie, the one that started talking first keeps the top.
+func (m *streamMixer) top(running []*mixerStream) *mixerStream {
+	m.mu.Lock()
+	priority := m.priority
+	m.mu.Unlock()
+	prio := func(s *mixerStream) int {
+		if c := s.label.call(); c != nil && priority != nil {
+			return priority(c.Tgid)
+		}
+		return talkgroup.DefaultPriority
+	}
+	top := running[0]
+	for _, s := range running[1:] {
+		if p, q := prio(s), prio(top); p < q || (p == q && s.runAt.Before(top.runAt)) {
+			top = s
+		}
+	}
+	return top
+}
+
+func (m *streamMixer) status() []StreamStatus {
+	m.mu.Lock()
+	priority := m.priority
+	m.mu.Unlock()
+	var list []StreamStatus
+	for i, s := range m.streams {
+		s.b.mu.Lock()
+		active := time.Since(s.b.lastAudio) < filterGap
+		s.b.mu.Unlock()
+		status := StreamStatus{Stream: i, Address: s.b.udpAddr, Active: active, Call: s.label.call()}
+		if status.Call != nil && priority != nil {
+			status.Priority = priority(status.Call.Tgid)
+		}
+		list = append(list, status)
+	}
+	return list
+}
+
+func (m *streamMixer) samples(d time.Duration) int {
+	return int(d * time.Duration(m.out.SampleRate*m.out.Channels) / time.Second)
+}
+
+// start starts the streams and the mixer.
+func (m *streamMixer) start(quit chan struct{}) error {
+	for i, s := range m.streams {
+		if err := s.b.Start(); err != nil {
+			return fmt.Errorf("audio stream %d: %v", i, err)
+		}
+	}
+	go m.run(quit)
+	log.Printf("Mixing %d audio streams", len(m.streams))
+	return nil
+}
+
+func (m *streamMixer) shutdown() {
+	for _, s := range m.streams {
+		s.b.Shutdown()
+	}
+}
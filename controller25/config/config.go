@@ -8,19 +8,193 @@ import (
     "os"
     "os/exec"
     "path/filepath"
+    "sort"
+    "strconv"
     "strings"
     "syscall"
+    "time"
     "fmt"
 )
 
 type Config struct {
-    Op25RxPath string
-    SdrDevice  string
-    SampleRate string
-    LnaGain    string
-    TrunkFile  string
+    Op25RxPath  string
+    PidDir      string // PID files of OP25 processes this controller launched
+    HistoryFile string // call log, one JSON object per line
+    UploadQueue string // calls waiting to be uploaded, one JSON file each
+    Launcher    string // "rxpy" (default) or "simulator" for development without an SDR
+
+    // Supervisor crash-loop limit: give up after MaxRestarts within RestartWindow
+    MaxRestarts   int
+    RestartWindow time.Duration
+
+    // How long a talkgroup may go quiet before its call is over
+    CallHangTime time.Duration
+
+    Recording RecordingConfig
+
+    // How much live audio is kept in memory for replay; 0 disables it
+    ReplayDuration time.Duration
+
+    // Named talkgroup sets listeners can filter the live audio by, from [favorites]
+    Favorites map[string][]int
+
+    // Processing applied to the live audio, and per-talkgroup gain in dB
+    // from [talkgroup_gain]
+    DSP            DSPConfig
+    TalkgroupGains map[int]float64
+
+    // Where the live audio is sent over RTP, one per [rtp:<name>]
+    RTP []RTPConfig
+
+    // Icecast mount points the live audio is pushed to, one per [icecast:<name>]
+    Icecast []IcecastConfig
+
+    // Services recorded calls are uploaded to, one per [upload:<name>]
+    Uploads []UploadConfig
+
+    // Receivers[0] comes from the [op25] section; one more per [receiver:<id>]
+    Receivers []*ReceiverConfig
+}
+
+// ReceiverConfig describes one OP25 instance bound to one SDR.
+type ReceiverConfig struct {
+    ID           string
+    Section      string // INI section the receiver was loaded from
+    SdrDevice    string
+    DeviceIndex  string // selects a dongle when several are attached, e.g. "1" or a serial
+    SampleRate   string
+    LnaGain      string
+    TrunkFile    string
+    AudioPort    int // UDP port rx.py sends audio to (-u)
+    AudioStreams int // TDMA slots or voice channels with their own audio, each 2 ports above the last
+    TerminalPort int // OP25 HTTP terminal port (-l http:0.0.0.0:<port>)
+}
+
+// RecordingConfig selects which calls are saved to disk, from [recording].
+type RecordingConfig struct {
+    Enabled     bool
+    Talkgroups  []int    // only these talkgroups and Categories; both empty records every call
+    Categories  []string // RadioReference categories, e.g. "Fire Dispatch"
+    Exclude     []int    // never recorded
+    MinDuration time.Duration
+}
+
+// DSPConfig is the processing chain the live audio passes through, in
+// order, from [dsp]. Every stage is off by default.
+type DSPConfig struct {
+    HighPass      bool
+    HighPassHz    float64 // cutoff; 200 Hz clears mains hum and its harmonics
+    Gate          bool
+    GateThreshold float64 // dBFS below which audio is muted
+    AGC           bool
+    AGCTarget     float64 // dBFS the AGC levels speech to
+    AGCMaxGain    float64 // dB the AGC may boost quiet audio by
+}
+
+// RTPConfig is one destination the live audio is sent to over RTP.
+type RTPConfig struct {
+    Name       string
+    Address    string // host:port, unicast or multicast
+    Codec      string // "pcmu" (default) or "l16"
+    Receiver   string
+    Stream     int   // one of the receiver's audio streams; -1 sends the mixed audio
+    Talkgroups []int // only these talkgroups; empty sends every call
+    TTL        int   // multicast hops
+}
+
+// IcecastConfig is one Icecast mount point the controller feeds as a
+// source client.
+type IcecastConfig struct {
+    Name        string
+    URL         string // http://host:port/mount
+    Username    string
+    Password    string
+    Receiver    string
+    Stream      int   // one of the receiver's audio streams; -1 sends the mixed audio
+    Talkgroups  []int // only these talkgroups; empty sends every call
+    StreamName  string
+    Description string
+    Genre       string
+    Public      bool // listed in the Icecast directory
+}
+
+// UploadConfig is a call sharing service recorded calls are uploaded to.
+type UploadConfig struct {
+    Name       string
+    Type       string // UploadRdioScanner, UploadOpenMHz or UploadBroadcastify
+    URL        string // upload endpoint; OpenMHz and Broadcastify have defaults
+    APIKey     string
+    System     string // rdio-scanner system ID, OpenMHz short name or Broadcastify system ID
+    Receiver   string // only calls from this receiver; empty uploads from all
+    Talkgroups []int  // only these talkgroups; empty uploads every call
+    Exclude    []int  // never uploaded
+}
+
+// Upload service types
+const (
+    UploadRdioScanner  = "rdio-scanner"
+    UploadOpenMHz      = "openmhz"
+    UploadBroadcastify = "broadcastify"
+)
+
+// Wants reports whether a call heard on receiver should go to u.
+func (u UploadConfig) Wants(receiver string, tgid int) bool {
+    if u.Receiver != "" && u.Receiver != receiver {
+        return false
+    }
+    for _, t := range u.Exclude {
+        if t == tgid {
+            return false
+        }
+    }
+    if len(u.Talkgroups) == 0 {
+        return true
+    }
+    for _, t := range u.Talkgroups {
+        if t == tgid {
+            return true
+        }
+    }
+    return false
 }
 
+// Wants reports whether a call on tgid in category should be recorded.
+func (c RecordingConfig) Wants(tgid int, category string) bool {
+    if !c.Enabled {
+        return false
+    }
+    for _, t := range c.Exclude {
+        if t == tgid {
+            return false
+        }
+    }
+    if len(c.Talkgroups) == 0 && len(c.Categories) == 0 {
+        return true
+    }
+    for _, t := range c.Talkgroups {
+        if t == tgid {
+            return true
+        }
+    }
+    for _, cat := range c.Categories {
+        if category != "" && strings.EqualFold(cat, category) {
+            return true
+        }
+    }
+    return false
+}
+
+const (
+    DefaultReceiverID     = "default"
+    receiverSectionPrefix = "receiver:"
+    rtpSectionPrefix      = "rtp:"
+    icecastSectionPrefix  = "icecast:"
+    uploadSectionPrefix   = "upload:"
+    defaultAudioPort      = 23456
+    defaultTerminalPort   = 8080
+    maxAudioStreams       = 8
+)
+
 func MustLoadConfig(filename string) *Config {
     cfg, err := ini.Load(filename)
     if err != nil {
@@ -30,21 +204,391 @@ func MustLoadConfig(filename string) *Config {
     if op25rxpath == "" {
         log.Fatalf("op25rxpath not found in config file")
     }
+    // Default next to config.ini, which is outside the shared OP25 directory
+    pidDir := cfg.Section("").Key("pid_dir").MustString(filepath.Join(filepath.Dir(filename), "run"))
+    historyFile := cfg.Section("").Key("history_file").MustString(filepath.Join(filepath.Dir(filename), "history", "calls.jsonl"))
+    uploadQueue := cfg.Section("").Key("upload_queue").MustString(filepath.Join(filepath.Dir(filename), "uploads"))
     
     // Load OP25 section with defaults
     op25Section := cfg.Section("op25")
-    sdrDevice := op25Section.Key("sdr_device").MustString("rtl")
-    sampleRate := op25Section.Key("sample_rate").MustString("1400000")
-    lnaGain := op25Section.Key("lna_gain").MustString("47")
-    trunkFile := op25Section.Key("trunk_file").MustString("trunk.tsv")
+    launcher := op25Section.Key("launcher").In(LauncherRxPy, []string{LauncherRxPy, LauncherSimulator})
+    maxRestarts := op25Section.Key("max_restarts").MustInt(5)
+    restartWindow := op25Section.Key("restart_window").MustDuration(10 * time.Minute)
+    callHangTime := op25Section.Key("call_hang_time").MustDuration(5 * time.Second)
+    replayDuration := cfg.Section("replay").Key("duration").MustDuration(10 * time.Minute)
+    
+    c := &Config{
+        Op25RxPath:     op25rxpath,
+        PidDir:         pidDir,
+        HistoryFile:    historyFile,
+        UploadQueue:    uploadQueue,
+        Launcher:       launcher,
+        MaxRestarts:    maxRestarts,
+        RestartWindow:  restartWindow,
+        CallHangTime:   callHangTime,
+        Recording:      loadRecording(cfg.Section("recording")),
+        ReplayDuration: replayDuration,
+        Favorites:      loadFavorites(cfg.Section("favorites")),
+        DSP:            loadDSP(cfg.Section("dsp")),
+        TalkgroupGains: loadTalkgroupGains(cfg.Section("talkgroup_gain")),
+    }
+    c.Receivers = append(c.Receivers, loadReceiver(op25Section, DefaultReceiverID, 0, nil))
     
-    return &Config{
-        Op25RxPath: op25rxpath,
-        SdrDevice:  sdrDevice,
-        SampleRate: sampleRate,
-        LnaGain:    lnaGain,
-        TrunkFile:  trunkFile,
+    // Additional receivers, one per SDR: [receiver:<id>]
+    for _, section := range cfg.Sections() {
+        if !strings.HasPrefix(section.Name(), receiverSectionPrefix) {
+            continue
+        }
+        id := strings.TrimPrefix(section.Name(), receiverSectionPrefix)
+        if id == "" || id == DefaultReceiverID {
+            log.Fatalf("Invalid receiver section [%s]", section.Name())
+        }
+        c.Receivers = append(c.Receivers, loadReceiver(section, id, len(c.Receivers), c.Receivers[len(c.Receivers)-1]))
+    }
+    
+    // RTP destinations: [rtp:<name>]
+    for _, section := range cfg.Sections() {
+        if !strings.HasPrefix(section.Name(), rtpSectionPrefix) {
+            continue
+        }
+        name := strings.TrimPrefix(section.Name(), rtpSectionPrefix)
+        if name == "" {
+            log.Fatalf("Invalid RTP section [%s]", section.Name())
+        }
+        c.RTP = append(c.RTP, loadRTP(section, name))
+    }
+    
+    // Icecast mount points: [icecast:<name>]
+    for _, section := range cfg.Sections() {
+        if !strings.HasPrefix(section.Name(), icecastSectionPrefix) {
+            continue
+        }
+        name := strings.TrimPrefix(section.Name(), icecastSectionPrefix)
+        if name == "" || section.Key("url").String() == "" {
+            log.Fatalf("Invalid Icecast section [%s]: needs a name and a url", section.Name())
+        }
+        c.Icecast = append(c.Icecast, loadIcecast(section, name))
+    }
+    
+    // Call upload services: [upload:<name>]
+    for _, section := range cfg.Sections() {
+        if !strings.HasPrefix(section.Name(), uploadSectionPrefix) {
+            continue
+        }
+        name := strings.TrimPrefix(section.Name(), uploadSectionPrefix)
+        if name == "" {
+            log.Fatalf("Invalid upload section [%s]", section.Name())
+        }
+        c.Uploads = append(c.Uploads, loadUpload(section, name))
+    }
+    
+    // Each receiver needs its own audio and terminal port
+    audioPorts := map[int]string{}
+    terminalPorts := map[int]string{}
+    for _, rc := range c.Receivers {
+        for _, port := range rc.AudioPorts() {
+            if other, ok := audioPorts[port]; ok {
+                log.Fatalf("Receivers %s and %s both use audio port %d", other, rc.ID, port)
+            }
+            audioPorts[port] = rc.ID
+        }
+        if other, ok := terminalPorts[rc.TerminalPort]; ok {
+            log.Fatalf("Receivers %s and %s both use terminal port %d", other, rc.ID, rc.TerminalPort)
+        }
+        terminalPorts[rc.TerminalPort] = rc.ID
     }
+    return c
+}
+
+// loadReceiver reads one receiver section. Ports default to a per-index
+// offset from the single-receiver defaults so extra sections work as-is;
+// the audio port to just past prev's audio streams.
+func loadReceiver(section *ini.Section, id string, index int, prev *ReceiverConfig) *ReceiverConfig {
+    audioPort := defaultAudioPort
+    if prev != nil {
+        // 4 above as before, further when prev has more than 2 streams
+        audioPort = prev.AudioPort + max(4, 2*len(prev.AudioPorts()))
+    }
+    return &ReceiverConfig{
+        ID:           id,
+        Section:      section.Name(),
+        SdrDevice:    section.Key("sdr_device").MustString("rtl"),
+        DeviceIndex:  section.Key("device_index").String(),
+        SampleRate:   section.Key("sample_rate").MustString("1400000"),
+        LnaGain:      section.Key("lna_gain").MustString("47"),
+        TrunkFile:    section.Key("trunk_file").MustString("trunk.tsv"),
+        AudioPort:    section.Key("audio_port").MustInt(audioPort),
+        AudioStreams: section.Key("audio_streams").RangeInt(1, 1, maxAudioStreams),
+        TerminalPort: section.Key("terminal_port").MustInt(defaultTerminalPort + index),
+    }
+}
+
+func loadRecording(section *ini.Section) RecordingConfig {
+    return RecordingConfig{
+        Enabled:     section.Key("enabled").MustBool(false),
+        Talkgroups:  intList(section.Key("talkgroups").String()),
+        Categories:  stringList(section.Key("categories").String()),
+        Exclude:     intList(section.Key("exclude_talkgroups").String()),
+        MinDuration: section.Key("min_duration").MustDuration(time.Second),
+    }
+}
+
+// loadFavorites reads one talkgroup list per key: fire = 1001, 1002
+func loadFavorites(section *ini.Section) map[string][]int {
+    favorites := make(map[string][]int)
+    for _, key := range section.Keys() {
+        favorites[key.Name()] = intList(key.String())
+    }
+    return favorites
+}
+
+// SaveFavorites replaces the [favorites] section of the INI file.
+func SaveFavorites(filename string, favorites map[string][]int) error {
+    iniFile, err := ini.Load(filename)
+    if err != nil {
+        return fmt.Errorf("failed to load config file: %v", err)
+    }
+    
+    iniFile.DeleteSection("favorites")
+    section, err := iniFile.NewSection("favorites")
+    if err != nil {
+        return fmt.Errorf("failed to create favorites section: %v", err)
+    }
+    names := make([]string, 0, len(favorites))
+    for name := range favorites {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    for _, name := range names {
+        tgids := make([]string, len(favorites[name]))
+        for i, tgid := range favorites[name] {
+            tgids[i] = strconv.Itoa(tgid)
+        }
+        section.Key(name).SetValue(strings.Join(tgids, ", "))
+    }
+    
+    return iniFile.SaveTo(filename)
+}
+
+func loadDSP(section *ini.Section) DSPConfig {
+    return DSPConfig{
+        HighPass:      section.Key("highpass").MustBool(false),
+        HighPassHz:    section.Key("highpass_hz").MustFloat64(200),
+        Gate:          section.Key("gate").MustBool(false),
+        GateThreshold: section.Key("gate_threshold").MustFloat64(-50),
+        AGC:           section.Key("agc").MustBool(false),
+        AGCTarget:     section.Key("agc_target").MustFloat64(-18),
+        AGCMaxGain:    section.Key("agc_max_gain").MustFloat64(20),
+    }
+}
+
+// SaveDSP replaces the [dsp] section of the INI file.
+func SaveDSP(filename string, dsp DSPConfig) error {
+    iniFile, err := ini.Load(filename)
+    if err != nil {
+        return fmt.Errorf("failed to load config file: %v", err)
+    }
+    
+    section := iniFile.Section("dsp")
+    section.Key("highpass").SetValue(strconv.FormatBool(dsp.HighPass))
+    section.Key("highpass_hz").SetValue(formatFloat(dsp.HighPassHz))
+    section.Key("gate").SetValue(strconv.FormatBool(dsp.Gate))
+    section.Key("gate_threshold").SetValue(formatFloat(dsp.GateThreshold))
+    section.Key("agc").SetValue(strconv.FormatBool(dsp.AGC))
+    section.Key("agc_target").SetValue(formatFloat(dsp.AGCTarget))
+    section.Key("agc_max_gain").SetValue(formatFloat(dsp.AGCMaxGain))
+    
+    return iniFile.SaveTo(filename)
+}
+
+// loadTalkgroupGains reads one gain offset in dB per talkgroup: 1001 = -3
+func loadTalkgroupGains(section *ini.Section) map[int]float64 {
+    gains := make(map[int]float64)
+    for _, key := range section.Keys() {
+        tgid, err := strconv.Atoi(key.Name())
+        if err != nil {
+            log.Printf("Warning: ignoring talkgroup %q in [%s]", key.Name(), section.Name())
+            continue
+        }
+        gain, err := key.Float64()
+        if err != nil {
+            log.Printf("Warning: ignoring gain %q for talkgroup %d", key.String(), tgid)
+            continue
+        }
+        gains[tgid] = gain
+    }
+    return gains
+}
+
+// SaveTalkgroupGains replaces the [talkgroup_gain] section of the INI file.
+func SaveTalkgroupGains(filename string, gains map[int]float64) error {
+    iniFile, err := ini.Load(filename)
+    if err != nil {
+        return fmt.Errorf("failed to load config file: %v", err)
+    }
+    
+    iniFile.DeleteSection("talkgroup_gain")
+    section, err := iniFile.NewSection("talkgroup_gain")
+    if err != nil {
+        return fmt.Errorf("failed to create talkgroup_gain section: %v", err)
+    }
+    tgids := make([]int, 0, len(gains))
+    for tgid := range gains {
+        tgids = append(tgids, tgid)
+    }
+    sort.Ints(tgids)
+    for _, tgid := range tgids {
+        section.Key(strconv.Itoa(tgid)).SetValue(formatFloat(gains[tgid]))
+    }
+    
+    return iniFile.SaveTo(filename)
+}
+
+func loadRTP(section *ini.Section, name string) RTPConfig {
+    return RTPConfig{
+        Name:       name,
+        Address:    section.Key("address").String(),
+        Codec:      section.Key("codec").In("pcmu", []string{"pcmu", "l16"}),
+        Receiver:   section.Key("receiver").MustString(DefaultReceiverID),
+        Stream:     section.Key("stream").MustInt(-1),
+        Talkgroups: intList(section.Key("talkgroups").String()),
+        TTL:        section.Key("ttl").RangeInt(1, 1, 255),
+    }
+}
+
+func loadIcecast(section *ini.Section, name string) IcecastConfig {
+    return IcecastConfig{
+        Name:        name,
+        URL:         section.Key("url").String(),
+        Username:    section.Key("username").MustString("source"),
+        Password:    section.Key("password").String(),
+        Receiver:    section.Key("receiver").MustString(DefaultReceiverID),
+        Stream:      section.Key("stream").MustInt(-1),
+        Talkgroups:  intList(section.Key("talkgroups").String()),
+        StreamName:  section.Key("stream_name").MustString("Scanner"),
+        Description: section.Key("description").String(),
+        Genre:       section.Key("genre").MustString("Scanner"),
+        Public:      section.Key("public").MustBool(false),
+    }
+}
+
+func loadUpload(section *ini.Section, name string) UploadConfig {
+    return UploadConfig{
+        Name:       name,
+        Type:       section.Key("type").In(UploadRdioScanner, []string{UploadRdioScanner, UploadOpenMHz, UploadBroadcastify}),
+        URL:        section.Key("url").String(),
+        APIKey:     section.Key("api_key").String(),
+        System:     section.Key("system").String(),
+        Receiver:   section.Key("receiver").String(),
+        Talkgroups: intList(section.Key("talkgroups").String()),
+        Exclude:    intList(section.Key("exclude_talkgroups").String()),
+    }
+}
+
+// SaveRTP replaces the [rtp:<name>] sections of the INI file.
+func SaveRTP(filename string, destinations []RTPConfig) error {
+    iniFile, err := ini.Load(filename)
+    if err != nil {
+        return fmt.Errorf("failed to load config file: %v", err)
+    }
+    
+    for _, name := range iniFile.SectionStrings() {
+        if strings.HasPrefix(name, rtpSectionPrefix) {
+            iniFile.DeleteSection(name)
+        }
+    }
+    for _, d := range destinations {
+        section, err := iniFile.NewSection(rtpSectionPrefix + d.Name)
+        if err != nil {
+            return fmt.Errorf("failed to create RTP section: %v", err)
+        }
+        section.Key("address").SetValue(d.Address)
+        section.Key("codec").SetValue(d.Codec)
+        section.Key("receiver").SetValue(d.Receiver)
+        if d.Stream >= 0 {
+            section.Key("stream").SetValue(strconv.Itoa(d.Stream))
+        }
+        if len(d.Talkgroups) > 0 {
+            tgids := make([]string, len(d.Talkgroups))
+            for i, tgid := range d.Talkgroups {
+                tgids[i] = strconv.Itoa(tgid)
+            }
+            section.Key("talkgroups").SetValue(strings.Join(tgids, ", "))
+        }
+        if d.TTL > 1 {
+            section.Key("ttl").SetValue(strconv.Itoa(d.TTL))
+        }
+    }
+    
+    return iniFile.SaveTo(filename)
+}
+
+func formatFloat(f float64) string {
+    return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// stringList splits a comma separated value, dropping empty entries.
+func stringList(value string) []string {
+    var list []string
+    for _, s := range strings.Split(value, ",") {
+        if s = strings.TrimSpace(s); s != "" {
+            list = append(list, s)
+        }
+    }
+    return list
+}
+
+func intList(value string) []int {
+    var list []int
+    for _, s := range stringList(value) {
+        if n, err := strconv.Atoi(s); err == nil {
+            list = append(list, n)
+        } else {
+            log.Printf("Warning: ignoring talkgroup %q in config", s)
+        }
+    }
+    return list
+}
+
+// Receiver returns the receiver with the given ID, or nil.
+func (c *Config) Receiver(id string) *ReceiverConfig {
+    for _, rc := range c.Receivers {
+        if rc.ID == id {
+            return rc
+        }
+    }
+    return nil
+}
+
+// AudioPorts returns the UDP port of each audio stream. OP25 sends the
+// second TDMA slot 2 ports above the first; multi_rx.py channels are
+// pointed at the same ports.
+func (rc *ReceiverConfig) AudioPorts() []int {
+    ports := make([]int, max(rc.AudioStreams, 1))
+    for i := range ports {
+        ports[i] = rc.AudioPort + 2*i
+    }
+    return ports
+}
+
+// SystemID returns the system folder name from a trunk file under
+// systems/<id>/, or "" when the trunk file lives elsewhere.
+func (rc *ReceiverConfig) SystemID() string {
+    parts := strings.Split(filepath.ToSlash(rc.TrunkFile), "/")
+    if len(parts) < 3 || parts[0] != "systems" {
+        return ""
+    }
+    return parts[1]
+}
+
+// SiteID returns the RadioReference site ID from a trunk file named
+// <system>_<site>_trunk.tsv, or "".
+func (rc *ReceiverConfig) SiteID() string {
+    parts := strings.Split(strings.TrimSuffix(filepath.Base(rc.TrunkFile), "_trunk.tsv"), "_")
+    if len(parts) != 2 || parts[0] != rc.SystemID() {
+        return ""
+    }
+    return parts[1]
 }
 
 func MustChdir(path string) {
@@ -53,54 +597,66 @@ func MustChdir(path string) {
     }
 }
 
-// SaveConfig writes the configuration back to the INI file
+// SaveConfig writes the receiver settings back to the INI file
 func SaveConfig(filename string, cfg *Config) error {
     iniFile, err := ini.Load(filename)
     if err != nil {
         return fmt.Errorf("failed to load config file: %v", err)
     }
     
-    // Update OP25 section
-    op25Section, err := iniFile.GetSection("op25")
-    if err != nil {
-        op25Section, err = iniFile.NewSection("op25")
+    for _, rc := range cfg.Receivers {
+        section, err := iniFile.GetSection(rc.Section)
         if err != nil {
-            return fmt.Errorf("failed to create op25 section: %v", err)
+            section, err = iniFile.NewSection(rc.Section)
+            if err != nil {
+                return fmt.Errorf("failed to create %s section: %v", rc.Section, err)
+            }
+        }
+        
+        section.Key("sdr_device").SetValue(rc.SdrDevice)
+        section.Key("sample_rate").SetValue(rc.SampleRate)
+        section.Key("lna_gain").SetValue(rc.LnaGain)
+        section.Key("trunk_file").SetValue(rc.TrunkFile)
+        if rc.DeviceIndex != "" {
+            section.Key("device_index").SetValue(rc.DeviceIndex)
+        } else {
+            section.DeleteKey("device_index")
         }
     }
     
-    op25Section.Key("sdr_device").SetValue(cfg.SdrDevice)
-    op25Section.Key("sample_rate").SetValue(cfg.SampleRate)
-    op25Section.Key("lna_gain").SetValue(cfg.LnaGain)
-    op25Section.Key("trunk_file").SetValue(cfg.TrunkFile)
-    
     return iniFile.SaveTo(filename)
 }
 
-// BuildOP25Flags constructs the OP25 command flags from the config
+// BuildOP25Flags constructs the OP25 command flags from a receiver config
 // Always includes audio streaming flags to preserve functionality
-func BuildOP25Flags(cfg *Config) []string {
+func BuildOP25Flags(rc *ReceiverConfig) []string {
     // Build device arg - for rtl_tcp, append server IP
-    deviceArg := cfg.SdrDevice
-    if cfg.SdrDevice == "rtl_tcp" {
+    deviceArg := rc.SdrDevice
+    if rc.SdrDevice == "rtl_tcp" {
         serverIP := GetServerIP()
         deviceArg = fmt.Sprintf("rtl_tcp=%s:1234", serverIP)
+    } else if rc.DeviceIndex != "" {
+        // osmosdr device string, e.g. rtl=1 or rtl=00000002
+        deviceArg = fmt.Sprintf("%s=%s", rc.SdrDevice, rc.DeviceIndex)
     }
     
     flags := []string{
         "--args", fmt.Sprintf("'%s'", deviceArg),
-        "-N", fmt.Sprintf("LNA:%s", cfg.LnaGain),
-        "-S", cfg.SampleRate,
-        "-T", cfg.TrunkFile,
+        "-N", fmt.Sprintf("LNA:%s", rc.LnaGain),
+        "-S", rc.SampleRate,
+        "-T", rc.TrunkFile,
         // Critical flags for audio streaming and functionality - DO NOT REMOVE
         "-X",
         "-x", "9.0",
         "-V",
         "-v", "9",
-        "-l", "http:0.0.0.0:8080",
+        "-l", fmt.Sprintf("http:0.0.0.0:%d", rc.TerminalPort),
         "-w",
         "-W", "127.0.0.1",
     }
+    if rc.AudioPort != defaultAudioPort {
+        flags = append(flags, "-u", strconv.Itoa(rc.AudioPort))
+    }
     return flags
 }
 
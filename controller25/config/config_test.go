@@ -0,0 +1,45 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Receivers left on the default audio ports never share one, however many
+// streams each listens to.
+func TestDefaultAudioPorts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.ini")
+	ini := `op25rxpath = /opt/op25/op25/gr-op25_repeater/apps
+
+[op25]
+audio_streams = 3
+
+[receiver:north]
+
+[receiver:south]
+audio_streams = 2
+
+[receiver:east]
+`
+	if err := os.WriteFile(path, []byte(ini), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c := MustLoadConfig(path)
+
+	want := map[string]string{
+		DefaultReceiverID: "[23456 23458 23460]",
+		"north":           "[23462]",
+		"south":           "[23466 23468]",
+		"east":            "[23470]",
+	}
+	for _, rc := range c.Receivers {
+		if got := fmt.Sprint(rc.AudioPorts()); got != want[rc.ID] {
+			t.Errorf("%s: audio ports %s, want %s", rc.ID, got, want[rc.ID])
+		}
+	}
+	if n := len(c.Receivers); n != len(want) {
+		t.Errorf("got %d receivers, want %d", n, len(want))
+	}
+}
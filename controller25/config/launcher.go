@@ -0,0 +1,70 @@
+package config
+
+import (
+    "io"
+    "os/exec"
+    "syscall"
+)
+
+// Process is a running OP25 instance, real or simulated.
+type Process interface {
+    // Pid returns the OS process ID (also the process group ID), or 0 for
+    // an in-process simulation.
+    Pid() int
+    Args() []string
+    // Signal delivers sig to the whole process group.
+    Signal(sig syscall.Signal) error
+    // Wait blocks until the process exits and returns its exit code
+    // (-1 if it was killed by a signal).
+    Wait() (int, error)
+}
+
+// Launcher starts OP25 with a set of rx.py flags. The returned pipes carry
+// the process's stdout and stderr.
+type Launcher interface {
+    Name() string
+    Launch(flags []string) (Process, io.ReadCloser, io.ReadCloser, error)
+}
+
+// Launcher names accepted by the "launcher" key in config.ini
+const (
+    LauncherRxPy      = "rxpy"
+    LauncherSimulator = "simulator"
+)
+
+// RxLauncher runs the real rx.py from the current working directory.
+type RxLauncher struct{}
+
+func (RxLauncher) Name() string {
+    return LauncherRxPy
+}
+
+func (RxLauncher) Launch(flags []string) (Process, io.ReadCloser, io.ReadCloser, error) {
+    cmd, stdout, stderr, err := StartOp25ProcessUDPWithFlags(flags)
+    if err != nil {
+        return nil, nil, nil, err
+    }
+    return &cmdProcess{cmd: cmd}, stdout, stderr, nil
+}
+
+// cmdProcess adapts an exec.Cmd started with Setpgid to Process.
+type cmdProcess struct {
+    cmd *exec.Cmd
+}
+
+func (p *cmdProcess) Pid() int {
+    return p.cmd.Process.Pid
+}
+
+func (p *cmdProcess) Args() []string {
+    return p.cmd.Args
+}
+
+func (p *cmdProcess) Signal(sig syscall.Signal) error {
+    return syscall.Kill(-p.cmd.Process.Pid, sig)
+}
+
+func (p *cmdProcess) Wait() (int, error) {
+    err := p.cmd.Wait()
+    return p.cmd.ProcessState.ExitCode(), err
+}
@@ -3,7 +3,9 @@ module controller25
 go 1.24.0
 
 require (
+	github.com/braheezy/shine-mp3 v0.1.0
 	github.com/grandcat/zeroconf v1.0.0
+	golang.org/x/net v0.50.0
 	gopkg.in/ini.v1 v1.67.0
 )
 
@@ -11,7 +13,6 @@ require (
 	github.com/cenkalti/backoff v2.2.1+incompatible // indirect
 	github.com/miekg/dns v1.1.27 // indirect
 	github.com/stretchr/testify v1.10.0 // indirect
-	golang.org/x/crypto v0.0.0-20191011191535-87dc89f01550 // indirect
-	golang.org/x/net v0.0.0-20200114155413-6afb5195e5aa // indirect
-	golang.org/x/sys v0.0.0-20190924154521-2837fb4f24fe // indirect
+	golang.org/x/crypto v0.48.0 // indirect
+	golang.org/x/sys v0.41.0 // indirect
 )
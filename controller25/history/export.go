@@ -0,0 +1,139 @@
+package history
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"io"
+	"math"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"controller25/recorder"
+)
+
+// ExportEntry is one call in an export manifest. Calls from the log carry
+// Call; Recording and File are set when there is audio for it. WAV files
+// that no logged call matches appear with only Recording and File.
+type ExportEntry struct {
+	Call      *Call               `json:"call,omitempty"`
+	Recording *recorder.Recording `json:"recording,omitempty"`
+	File      string              `json:"file,omitempty"` // path inside the zip
+}
+
+// Manifest describes an export; it is stored as manifest.json.
+type Manifest struct {
+	Generated time.Time     `json:"generated"`
+	Since     time.Time     `json:"since"`
+	Until     time.Time     `json:"until"`
+	Calls     []ExportEntry `json:"calls"`
+}
+
+var unsafeName = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// Export writes a zip of the calls matching q, with their audio under
+// audio/ and a manifest.json listing everything, oldest first. store may
+// be nil to export recordings only.
+func Export(w io.Writer, store *Store, lib *recorder.Library, q Query) error {
+	if q.Until.IsZero() {
+		q.Until = time.Now()
+	}
+	manifest := Manifest{Generated: time.Now(), Since: q.Since, Until: q.Until, Calls: []ExportEntry{}}
+
+	recordings := map[string]recorder.Recording{}
+	for _, rec := range lib.Between(q.Since, q.Until) {
+		recordings[rec.ID] = rec
+	}
+	if store != nil {
+		q.Before, q.Limit = -1, math.MaxInt
+		calls := store.Query(q).Calls
+		slices.Reverse(calls)
+		for _, c := range calls {
+			entry := ExportEntry{Call: &c}
+			if rec, ok := recordings[c.ID]; ok {
+				entry.Recording = &rec
+				delete(recordings, c.ID)
+			} else if rec, ok := lib.Get(c.ID); ok {
+				// Started just before the window
+				entry.Recording = &rec
+			}
+			manifest.Calls = append(manifest.Calls, entry)
+		}
+	}
+	for _, rec := range recordings {
+		if q.matchesRecording(rec) {
+			manifest.Calls = append(manifest.Calls, ExportEntry{Recording: &rec})
+		}
+	}
+	slices.SortStableFunc(manifest.Calls, func(a, b ExportEntry) int {
+		return a.start().Compare(b.start())
+	})
+
+	zw := zip.NewWriter(w)
+	for i := range manifest.Calls {
+		entry := &manifest.Calls[i]
+		if entry.Recording == nil {
+			continue
+		}
+		entry.File = "audio/" + unsafeName.ReplaceAllString(entry.Recording.ID, "_") + ".wav"
+		if err := addFile(zw, lib, *entry.Recording, entry.File); err != nil {
+			// Deleted since the scan; keep the entry without audio
+			entry.File = ""
+			continue
+		}
+	}
+
+	mw, err := zw.Create("manifest.json")
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(mw)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(manifest); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// addFile stores a recording in the zip uncompressed; PCM doesn't shrink
+// enough to be worth the CPU on a Pi.
+func addFile(zw *zip.Writer, lib *recorder.Library, rec recorder.Recording, name string) error {
+	f, err := lib.Open(rec)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fw, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: rec.Modified})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fw, f)
+	return err
+}
+
+func (e ExportEntry) start() time.Time {
+	if e.Call != nil {
+		return e.Call.Start
+	}
+	return e.Recording.Start
+}
+
+// matchesRecording applies q to a recording with no logged call, using
+// its sidecar where there is one.
+func (q Query) matchesRecording(rec recorder.Recording) bool {
+	if q.Tgid != 0 && rec.Tgid != q.Tgid {
+		return false
+	}
+	m := rec.Metadata
+	if q.Receiver != "" && (m == nil || m.Receiver != q.Receiver) {
+		return false
+	}
+	if q.Unit != 0 && (m == nil || !slices.Contains(m.Sources, q.Unit)) {
+		return false
+	}
+	if q.Category != "" && (m == nil || !strings.EqualFold(m.Category, q.Category)) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,286 @@
+// Package history keeps a log of finished calls: an append-only JSONL file
+// and an in-memory index rebuilt from it at startup.
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"controller25/recorder"
+)
+
+const (
+	DefaultLimit = 50
+	MaxLimit     = 500
+)
+
+// Call is one finished call.
+type Call struct {
+	Seq       int            `json:"seq"` // order in the log, kept across restarts; pass as before= to page
+	ID        string         `json:"id"`
+	Receiver  string         `json:"receiver"`
+	Tgid      int            `json:"tgid"`
+	Talkgroup string         `json:"talkgroup,omitempty"` // alpha tag
+	Category  string         `json:"category,omitempty"`
+	Sources   []int          `json:"sources"`
+	Frequency int64          `json:"frequency"`
+	Slot      int            `json:"slot"`
+	Emergency bool           `json:"emergency"`
+	Encrypted bool           `json:"encrypted"`
+	Start     time.Time      `json:"start"`
+	End       time.Time      `json:"end"`
+	Duration  float64        `json:"duration"` // seconds
+	Site      *recorder.Site `json:"site,omitempty"`
+}
+
+// Store is the call log.
+type Store struct {
+	mu     sync.RWMutex
+	f      *os.File
+	calls  []Call
+	byID   map[string]int // index in calls
+	byTgid map[int][]int  // indexes in calls
+	next   int            // Seq of the next call
+}
+
+// Open loads the log at path, creating it if needed, and keeps it open
+// for appending. Lines that don't parse, such as one cut short by a
+// crash, are skipped.
+func Open(path string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{
+		f:      f,
+		byID:   make(map[string]int),
+		byTgid: make(map[int][]int),
+	}
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	skipped := 0
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var c Call
+		if err := json.Unmarshal(line, &c); err != nil || c.ID == "" {
+			skipped++
+			continue
+		}
+		s.indexLocked(c)
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if skipped > 0 {
+		log.Printf("Call history: skipped %d unreadable lines in %s", skipped, path)
+	}
+	log.Printf("Call history: %d calls loaded from %s", len(s.calls), path)
+	return s, nil
+}
+
+// Close closes the log file.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.f.Close()
+}
+
+// Append adds a call to the log.
+func (s *Store) Append(c Call) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c.Seq = s.next
+	if c.Sources == nil {
+		c.Sources = []int{}
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	if _, err := s.f.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	s.indexLocked(c)
+	return nil
+}
+
+// indexLocked adds a call, keeping the Seq it was logged with so paging
+// cursors survive a restart. A Seq out of order, as in lines written
+// before it was kept, is replaced with the next one.
+func (s *Store) indexLocked(c Call) {
+	if c.Seq < s.next {
+		c.Seq = s.next
+	}
+	s.next = c.Seq + 1
+	i := len(s.calls)
+	s.calls = append(s.calls, c)
+	s.byID[c.ID] = i
+	s.byTgid[c.Tgid] = append(s.byTgid[c.Tgid], i)
+}
+
+// Get returns the call with the given ID.
+func (s *Store) Get(id string) (Call, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	i, ok := s.byID[id]
+	if !ok {
+		return Call{}, false
+	}
+	return s.calls[i], true
+}
+
+// Query selects calls from the log. Zero fields match everything.
+type Query struct {
+	Receiver string
+	Tgid     int
+	Unit     int       // any source
+	Since    time.Time // calls starting at or after
+	Until    time.Time // calls starting before
+	Category string    // case-insensitive
+	Before   int       // only calls with a lower Seq; -1 for none
+	Limit    int
+}
+
+// Page is one page of query results, newest first. Next is the Before
+// for the following page, or nil on the last one.
+type Page struct {
+	Calls []Call `json:"calls"`
+	Next  *int   `json:"next,omitempty"`
+}
+
+// ParseQuery reads tgid, unit, since, until, category, before and limit
+// from URL parameters. Times are RFC 3339 or Unix seconds.
+func ParseQuery(v url.Values) (Query, error) {
+	q := Query{Category: v.Get("category"), Before: -1, Limit: DefaultLimit}
+	var err error
+	if q.Tgid, err = intParam(v, "tgid"); err != nil {
+		return q, err
+	}
+	if q.Unit, err = intParam(v, "unit"); err != nil {
+		return q, err
+	}
+	if q.Since, err = timeParam(v, "since"); err != nil {
+		return q, err
+	}
+	if q.Until, err = timeParam(v, "until"); err != nil {
+		return q, err
+	}
+	if v.Get("before") != "" {
+		if q.Before, err = intParam(v, "before"); err != nil {
+			return q, err
+		}
+	}
+	if v.Get("limit") != "" {
+		if q.Limit, err = intParam(v, "limit"); err != nil {
+			return q, err
+		}
+		if q.Limit <= 0 {
+			return q, fmt.Errorf("limit must be positive")
+		}
+	}
+	q.Limit = min(q.Limit, MaxLimit)
+	return q, nil
+}
+
+// Query returns the calls matching q, newest first.
+func (s *Store) Query(q Query) Page {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if q.Limit <= 0 {
+		q.Limit = DefaultLimit
+	}
+
+	// Walk the tgid's own index when there is one to narrow things down
+	indexes := s.byTgid[q.Tgid]
+	n := len(s.calls)
+	if q.Tgid != 0 {
+		n = len(indexes)
+	}
+	callAt := func(i int) Call {
+		if q.Tgid != 0 {
+			return s.calls[indexes[i]]
+		}
+		return s.calls[i]
+	}
+
+	page := Page{Calls: []Call{}}
+	for i := n - 1; i >= 0; i-- {
+		c := callAt(i)
+		if q.Before >= 0 && c.Seq >= q.Before {
+			continue
+		}
+		if !q.matches(c) {
+			continue
+		}
+		if len(page.Calls) == q.Limit {
+			next := page.Calls[len(page.Calls)-1].Seq
+			page.Next = &next
+			break
+		}
+		page.Calls = append(page.Calls, c)
+	}
+	return page
+}
+
+func (q Query) matches(c Call) bool {
+	switch {
+	case q.Receiver != "" && c.Receiver != q.Receiver:
+		return false
+	case q.Tgid != 0 && c.Tgid != q.Tgid:
+		return false
+	case q.Unit != 0 && !slices.Contains(c.Sources, q.Unit):
+		return false
+	case !q.Since.IsZero() && c.Start.Before(q.Since):
+		return false
+	case !q.Until.IsZero() && !c.Start.Before(q.Until):
+		return false
+	case q.Category != "" && !strings.EqualFold(c.Category, q.Category):
+		return false
+	}
+	return true
+}
+
+func intParam(v url.Values, key string) (int, error) {
+	s := v.Get(key)
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %q", key, s)
+	}
+	return n, nil
+}
+
+func timeParam(v url.Values, key string) (time.Time, error) {
+	s := v.Get(key)
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s: %q (want RFC 3339 or Unix seconds)", key, s)
+	}
+	return t, nil
+}
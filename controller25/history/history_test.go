@@ -0,0 +1,107 @@
+package history
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func appendCalls(t *testing.T, s *Store, from, to int) {
+	t.Helper()
+	start := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	for i := from; i < to; i++ {
+		c := Call{
+			ID:    fmt.Sprintf("call-%d", i),
+			Tgid:  1001 + i%2,
+			Start: start.Add(time.Duration(i) * time.Minute),
+		}
+		if err := s.Append(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func ids(p Page) []string {
+	var list []string
+	for _, c := range p.Calls {
+		list = append(list, c.ID)
+	}
+	return list
+}
+
+// A paging cursor taken before a restart still points at the same calls
+// after it, even when a damaged line is dropped on the way.
+func TestSeqSurvivesReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "calls.jsonl")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendCalls(t, s, 0, 10)
+	first := s.Query(Query{Before: -1, Limit: 3})
+	if first.Next == nil {
+		t.Fatal("no next page")
+	}
+	want := ids(s.Query(Query{Before: *first.Next, Limit: 3}))
+	wantTgid := ids(s.Query(Query{Tgid: 1001, Before: *first.Next, Limit: 3}))
+	s.Close()
+
+	// Damage the oldest call's line, as a crash mid-write would
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[10] = '#'
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if got := ids(s.Query(Query{Before: *first.Next, Limit: 3})); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("after reload got %v, want %v", got, want)
+	}
+	if got := ids(s.Query(Query{Tgid: 1001, Before: *first.Next, Limit: 3})); fmt.Sprint(got) != fmt.Sprint(wantTgid) {
+		t.Errorf("tgid 1001 after reload got %v, want %v", got, wantTgid)
+	}
+	if c, ok := s.Get("call-5"); !ok || c.Seq != 5 {
+		t.Errorf("call-5: %+v %v, want seq 5", c, ok)
+	}
+
+	// New calls carry on from the last Seq
+	appendCalls(t, s, 10, 11)
+	if c, _ := s.Get("call-10"); c.Seq != 10 {
+		t.Errorf("new call has seq %d, want 10", c.Seq)
+	}
+}
+
+// Lines whose seq is missing or out of order get the next one.
+func TestSeqOutOfOrder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "calls.jsonl")
+	lines := `{"id":"a","tgid":1}
+{"id":"b","tgid":1}
+{"seq":7,"id":"c","tgid":1}
+{"seq":3,"id":"d","tgid":1}
+`
+	if err := os.WriteFile(path, []byte(lines), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for id, want := range map[string]int{"a": 0, "b": 1, "c": 7, "d": 8} {
+		if c, _ := s.Get(id); c.Seq != want {
+			t.Errorf("%s: seq %d, want %d", id, c.Seq, want)
+		}
+	}
+	if got := ids(s.Query(Query{Before: 8, Limit: 10})); fmt.Sprint(got) != "[c b a]" {
+		t.Errorf("before 8: %v", got)
+	}
+}
@@ -6,6 +6,7 @@ import (
     "io"
     "log"
     "net/http"
+    "strings"
     "sync"
     "time"
 )
@@ -22,8 +23,11 @@ type Broadcaster struct {
     stderr    io.Reader
     history   []string
     maxLines  int
+    total     int // lines broadcast so far
+    attachAt  int // value of total when the current pipes were attached
     startTime time.Time
     parser    LineParser
+    label     string // receiver ID, prefixed to controller log output
 }
 
 func NewBroadcaster(stdout, stderr io.Reader) *Broadcaster {
@@ -47,22 +51,81 @@ func (b *Broadcaster) SetParser(parser LineParser) {
 }
 
 func (b *Broadcaster) Start() {
+    b.Attach(b.stdout, b.stderr)
+}
+
+// SetLabel tags lines echoed to the controller log, so output from several
+// receivers can be told apart. SSE clients see the lines unchanged.
+func (b *Broadcaster) SetLabel(label string) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    b.label = label
+}
+
+// Attach starts streaming a pair of process pipes. It is called once per
+// launch, so a restarted OP25 keeps the same history and SSE clients.
+// The returned channel is closed once both pipes have been read to EOF.
+func (b *Broadcaster) Attach(stdout, stderr io.Reader) <-chan struct{} {
+    b.mu.Lock()
+    b.attachAt = b.total
+    b.mu.Unlock()
     b.broadcast("[system] Starting log broadcaster")
     b.broadcast("[system] Setting up stdout and stderr pipes")
-    if b.stdout != nil {
-        go b.readPipe(b.stdout, "[stdout]")
+    var wg sync.WaitGroup
+    if stdout != nil {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            b.readPipe(stdout, "[stdout]")
+        }()
     } else {
         msg := "[system] Warning: nil stdout pipe, skipping stdout log streaming"
         log.Print(msg)
         b.broadcast(msg)
     }
-    if b.stderr != nil {
-        go b.readPipe(b.stderr, "[stderr]")
+    if stderr != nil {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            b.readPipe(stderr, "[stderr]")
+        }()
     } else {
         msg := "[system] Warning: nil stderr pipe, skipping stderr log streaming"
         log.Print(msg)
         b.broadcast(msg)
     }
+    done := make(chan struct{})
+    go func() {
+        wg.Wait()
+        close(done)
+    }()
+    return done
+}
+
+// Tail returns up to n of the most recent lines from the current pipes that
+// carry the given prefix (e.g. "[stderr]"), oldest first, with the prefix stripped.
+func (b *Broadcaster) Tail(prefix string, n int) []string {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    lines := []string{}
+    first := len(b.history) - (b.total - b.attachAt)
+    if first < 0 {
+        first = 0
+    }
+    for i := len(b.history) - 1; i >= first && len(lines) < n; i-- {
+        if strings.HasPrefix(b.history[i], prefix+" ") {
+            lines = append(lines, strings.TrimPrefix(b.history[i], prefix+" "))
+        }
+    }
+    for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+        lines[i], lines[j] = lines[j], lines[i]
+    }
+    return lines
+}
+
+// Notice writes a controller-generated line into the stream.
+func (b *Broadcaster) Notice(line string) {
+    b.broadcast("[system] " + line)
 }
 
 func (b *Broadcaster) readPipe(pipe io.Reader, prefix string) {
@@ -95,10 +158,15 @@ func (b *Broadcaster) readPipe(pipe io.Reader, prefix string) {
 }
 
 func (b *Broadcaster) broadcast(line string) {
-    log.Println(line)
     b.mu.Lock()
     defer b.mu.Unlock()
+    if b.label != "" {
+        log.Printf("[%s] %s", b.label, line)
+    } else {
+        log.Println(line)
+    }
     b.history = append(b.history, line)
+    b.total++
     if len(b.history) > b.maxLines {
         b.history = b.history[len(b.history)-b.maxLines:]
     }
@@ -2,16 +2,18 @@ package main
 
 import (
     "encoding/json"
+    "errors"
     "fmt"
     "io"
     "log"
     "math"
     "mime/multipart"
     "net/http"
+    "net/url"
     "os"
-    "os/exec"
     "os/signal"
     "path/filepath"
+    "slices"
     "sort"
     "strconv"
     "strings"
@@ -22,95 +24,24 @@ import (
     "controller25/audio"
     "controller25/config"
     "controller25/health"
-    logstream "controller25/log"
+    "controller25/history"
     "controller25/mdns"
     "controller25/radioreference"
-    "controller25/talkgroup"
+    "controller25/receiver"
+    "controller25/simulator"
+    "controller25/supervisor"
+    "controller25/uploader"
+    "controller25/wsaudio"
 )
 
-type Op25State struct {
-    cmdObj     *exec.Cmd
-    stdoutPipe io.ReadCloser
-    stderrPipe io.ReadCloser
-    running    bool
-    flags      []string
-    mu         sync.Mutex
-}
-
-var op25 Op25State
-var tgParser *talkgroup.Parser
-
-// killExistingOP25Processes finds and kills any running rx.py processes
-func killExistingOP25Processes() {
-    log.Println("Checking for existing OP25 processes...")
-    
-    // Use pgrep to find processes matching rx.py
-    cmd := exec.Command("pgrep", "-f", "rx.py")
-    output, err := cmd.Output()
-    if err != nil {
-        // Exit code 1 means no processes found, which is fine
-        if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
-            log.Println("No existing OP25 processes found")
-            return
-        }
-        log.Printf("Warning: Failed to check for existing OP25 processes: %v", err)
-        return
-    }
-    
-    // Parse PIDs from output
-    pids := strings.Split(strings.TrimSpace(string(output)), "\n")
-    if len(pids) == 0 || (len(pids) == 1 && pids[0] == "") {
-        log.Println("No existing OP25 processes found")
-        return
-    }
-    
-    log.Printf("Found %d existing OP25 process(es), cleaning up...", len(pids))
-    
-    for _, pidStr := range pids {
-        pidStr = strings.TrimSpace(pidStr)
-        if pidStr == "" {
-            continue
-        }
-        
-        pid, err := strconv.Atoi(pidStr)
-        if err != nil {
-            log.Printf("Warning: Invalid PID '%s': %v", pidStr, err)
-            continue
-        }
-        
-        // Kill the process
-        process, err := os.FindProcess(pid)
-        if err != nil {
-            log.Printf("Warning: Failed to find process %d: %v", pid, err)
-            continue
-        }
-        
-        err = process.Signal(syscall.SIGTERM)
-        if err != nil {
-            log.Printf("Warning: Failed to kill process %d: %v", pid, err)
-            // Try SIGKILL as fallback
-            _ = process.Signal(syscall.SIGKILL)
-        } else {
-            log.Printf("Killed existing OP25 process (PID %d)", pid)
-        }
-    }
-    
-    // Give processes time to shut down
-    time.Sleep(1 * time.Second)
-    log.Println("OP25 process cleanup complete")
-}
-
 // API request/response types
 type Op25StartRequest struct {
     Flags []string `json:"flags"`
 }
 type Op25StartResponse struct {
-    Started bool   `json:"started"`
-    Error   string `json:"error,omitempty"`
-}
-type Op25StatusResponse struct {
-    Running bool     `json:"running"`
-    Flags   []string `json:"flags"`
+    Started bool             `json:"started"`
+    State   supervisor.State `json:"state,omitempty"`
+    Error   string           `json:"error,omitempty"`
 }
 
 // Trunk API types
@@ -130,17 +61,76 @@ type TrunkWriteResponse struct {
 
 // OP25 Config API types
 type Op25ConfigResponse struct {
-    SdrDevice  string `json:"sdr_device"`
-    SampleRate string `json:"sample_rate"`
-    LnaGain    string `json:"lna_gain"`
-    TrunkFile  string `json:"trunk_file"`
-    Error      string `json:"error,omitempty"`
+    SdrDevice   string `json:"sdr_device"`
+    DeviceIndex string `json:"device_index"`
+    SampleRate  string `json:"sample_rate"`
+    LnaGain     string `json:"lna_gain"`
+    TrunkFile   string `json:"trunk_file"`
+    Error       string `json:"error,omitempty"`
 }
 type Op25ConfigRequest struct {
-    SdrDevice  string `json:"sdr_device"`
-    SampleRate string `json:"sample_rate"`
-    LnaGain    string `json:"lna_gain"`
-    TrunkFile  string `json:"trunk_file"`
+    SdrDevice   string  `json:"sdr_device"`
+    DeviceIndex *string `json:"device_index"` // "" clears it; omitted leaves it unchanged
+    SampleRate  string  `json:"sample_rate"`
+    LnaGain     string  `json:"lna_gain"`
+    TrunkFile   string  `json:"trunk_file"`
+}
+
+// Audio DSP API types
+type DSPConfigResponse struct {
+    HighPass      bool    `json:"highpass"`
+    HighPassHz    float64 `json:"highpass_hz"`
+    Gate          bool    `json:"gate"`
+    GateThreshold float64 `json:"gate_threshold"` // dBFS
+    AGC           bool    `json:"agc"`
+    AGCTarget     float64 `json:"agc_target"`   // dBFS
+    AGCMaxGain    float64 `json:"agc_max_gain"` // dB
+    Error         string  `json:"error,omitempty"`
+}
+type DSPConfigRequest struct {
+    // Omitted fields are left unchanged
+    HighPass      *bool    `json:"highpass"`
+    HighPassHz    *float64 `json:"highpass_hz"`
+    Gate          *bool    `json:"gate"`
+    GateThreshold *float64 `json:"gate_threshold"`
+    AGC           *bool    `json:"agc"`
+    AGCTarget     *float64 `json:"agc_target"`
+    AGCMaxGain    *float64 `json:"agc_max_gain"`
+}
+
+// RTP API types
+type RTPDestination struct {
+    Name       string     `json:"name"`
+    Address    string     `json:"address"` // host:port, unicast or multicast
+    Codec      string     `json:"codec"`   // "pcmu" or "l16"
+    Receiver   string     `json:"receiver"`
+    Stream     *int       `json:"stream,omitempty"` // omitted for the mixed audio
+    Talkgroups []int      `json:"talkgroups"`
+    TTL        int        `json:"ttl"`
+    SDP        string     `json:"sdp,omitempty"` // path of the SDP file describing the stream
+    Packets    uint64     `json:"packets"`
+    LastSent   *time.Time `json:"last_sent,omitempty"`
+}
+
+// Control API types
+type ControlHoldRequest struct {
+    Tgid json.RawMessage `json:"tgid"` // talkgroup ID, or "current" for the one playing
+}
+type ControlResponse struct {
+    Success bool               `json:"success"`
+    Hold    receiver.HoldState `json:"hold"`
+    Error   string             `json:"error,omitempty"`
+}
+
+type ControlLockoutRequest struct {
+    Tgid     int             `json:"tgid"`
+    Duration json.RawMessage `json:"duration"` // "30m", or seconds; omit for a permanent lockout
+}
+type ControlLockoutResponse struct {
+    Success  bool               `json:"success"`
+    Lockout  *receiver.Lockout  `json:"lockout,omitempty"`
+    Lockouts []receiver.Lockout `json:"lockouts,omitempty"`
+    Error    string             `json:"error,omitempty"`
 }
 
 // RadioReference API types
@@ -177,27 +167,233 @@ type RadioReferenceSite struct {
 }
 
 
-func stopOp25(audioBroadcaster **audio.Broadcaster, logBroadcaster **logstream.Broadcaster) {
-    if op25.cmdObj != nil && op25.cmdObj.Process != nil {
-        log.Println("Terminating OP25 process...")
-        syscall.Kill(-op25.cmdObj.Process.Pid, syscall.SIGKILL)
-        op25.cmdObj.Wait()
-        log.Println("OP25 process terminated")
+// controlStatus maps a live control error to an HTTP status.
+func controlStatus(err error) int {
+    if errors.Is(err, receiver.ErrNotRunning) || errors.Is(err, receiver.ErrNoActiveTalkgroup) || errors.Is(err, receiver.ErrNoSystem) {
+        return http.StatusConflict
+    }
+    if errors.Is(err, receiver.ErrNotLockedOut) {
+        return http.StatusNotFound
+    }
+    // OP25 is up but its terminal didn't answer
+    return http.StatusBadGateway
+}
+
+// dspMu serializes changes to the audio processing settings and their
+// saving to config.ini.
+var dspMu sync.Mutex
+
+func dspConfigResponse(c config.DSPConfig) DSPConfigResponse {
+    return DSPConfigResponse{
+        HighPass:      c.HighPass,
+        HighPassHz:    c.HighPassHz,
+        Gate:          c.Gate,
+        GateThreshold: c.GateThreshold,
+        AGC:           c.AGC,
+        AGCTarget:     c.AGCTarget,
+        AGCMaxGain:    c.AGCMaxGain,
+    }
+}
+
+// rtpMu guards rtpSenders and cfg.RTP, which /api/rtp edits.
+var rtpMu sync.Mutex
+
+// rtpSenders are the running RTP destinations by name.
+var rtpSenders = map[string]*audio.RTPSender{}
+
+// receiverAudio returns a function giving a receiver's current audio
+// stream, or its mixed audio for a negative stream. The function returns
+// nil while OP25 is stopped.
+func receiverAudio(receivers *receiver.Manager, id string, stream int) (func() *audio.Broadcaster, error) {
+    rx := receivers.Get(id)
+    if rx == nil {
+        return nil, fmt.Errorf("unknown receiver %q", id)
+    }
+    if stream >= len(rx.Config.AudioPorts()) {
+        return nil, fmt.Errorf("receiver %s has no audio stream %d", rx.ID, stream)
+    }
+    return func() *audio.Broadcaster {
+        ab := rx.Audio()
+        if ab == nil || stream < 0 {
+            return ab
+        }
+        return ab.Stream(stream)
+    }, nil
+}
+
+// startRTP starts sending a receiver's audio to an RTP destination. The
+// sender follows the receiver across OP25 restarts.
+func startRTP(receivers *receiver.Manager, c config.RTPConfig) (*audio.RTPSender, error) {
+    source, err := receiverAudio(receivers, c.Receiver, c.Stream)
+    if err != nil {
+        return nil, err
+    }
+    sender, err := audio.NewRTPSender(c)
+    if err != nil {
+        return nil, err
+    }
+    go sender.Run(source)
+    return sender, nil
+}
+
+// startIcecast starts feeding a receiver's audio to an Icecast mount
+// point.
+func startIcecast(receivers *receiver.Manager, c config.IcecastConfig) (*audio.IcecastSource, error) {
+    source, err := receiverAudio(receivers, c.Receiver, c.Stream)
+    if err != nil {
+        return nil, err
+    }
+    ice, err := audio.NewIcecastSource(c)
+    if err != nil {
+        return nil, err
+    }
+    go ice.Run(source)
+    return ice, nil
+}
+
+// icecastSources are the configured Icecast mount points, in config file
+// order.
+var icecastSources []*audio.IcecastSource
+
+func rtpDestination(sender *audio.RTPSender) RTPDestination {
+    c := sender.Config()
+    status := sender.Status()
+    d := RTPDestination{
+        Name:       c.Name,
+        Address:    c.Address,
+        Codec:      c.Codec,
+        Receiver:   c.Receiver,
+        Talkgroups: c.Talkgroups,
+        TTL:        c.TTL,
+        SDP:        "/rtp/" + url.PathEscape(c.Name) + ".sdp",
+        Packets:    status.Packets,
+    }
+    if c.Stream >= 0 {
+        d.Stream = &c.Stream
+    }
+    if d.Talkgroups == nil {
+        d.Talkgroups = []int{}
+    }
+    if !status.LastSent.IsZero() {
+        d.LastSent = &status.LastSent
+    }
+    return d
+}
+
+// favoritesMu guards cfg.Favorites, which listeners edit through
+// /api/favorites.
+var favoritesMu sync.Mutex
+
+// audioFilter reads a live audio filter from the query: tgids=1001,1002,
+// category=Fire Dispatch (may repeat) and favorites=<name>[,<name>].
+func audioFilter(q url.Values, rx *receiver.Receiver, favorites map[string][]int) (audio.Filter, error) {
+    f := audio.Filter{
+        Categories: q["category"],
+        Category: func(tgid int) string {
+            return rx.Directory.Category(rx.Config.SystemID(), tgid)
+        },
+    }
+    for _, s := range strings.Split(q.Get("tgids"), ",") {
+        if s = strings.TrimSpace(s); s == "" {
+            continue
+        }
+        tgid, err := strconv.Atoi(s)
+        if err != nil {
+            return f, fmt.Errorf("invalid talkgroup %q", s)
+        }
+        f.Talkgroups = append(f.Talkgroups, tgid)
+    }
+    favoritesMu.Lock()
+    defer favoritesMu.Unlock()
+    for _, name := range strings.Split(q.Get("favorites"), ",") {
+        if name = strings.TrimSpace(name); name == "" {
+            continue
+        }
+        tgids, ok := favorites[name]
+        if !ok {
+            return f, fmt.Errorf("unknown favorites %q", name)
+        }
+        f.Talkgroups = append(f.Talkgroups, tgids...)
+    }
+    return f, nil
+}
+
+// audioStream returns the audio stream named by the {n} path value, or
+// writes an error and returns nil.
+func audioStream(w http.ResponseWriter, r *http.Request, rx *receiver.Receiver) *audio.Broadcaster {
+    audioBroadcaster := rx.Audio()
+    if audioBroadcaster == nil {
+        http.Error(w, "Audio not broadcasting (OP25 not started)", http.StatusServiceUnavailable)
+        return nil
+    }
+    n, err := strconv.Atoi(r.PathValue("n"))
+    stream := audioBroadcaster.Stream(n)
+    if err != nil || stream == nil {
+        http.Error(w, "Unknown audio stream", http.StatusNotFound)
+        return nil
+    }
+    return stream
+}
+
+// liveName names a receiver's live stream in players: the system being
+// monitored once OP25 reports it.
+func liveName(rx *receiver.Receiver) string {
+    if state := rx.Tracker.State(); state != nil && state.Name != "" {
+        return state.Name
+    }
+    return "controller25 " + rx.ID
+}
+
+// livePlaylist writes an .m3u or .pls playlist for the live.mp3 next to
+// it. The query is passed on, so a playlist can carry a filter.
+func livePlaylist(w http.ResponseWriter, r *http.Request, rx *receiver.Receiver) {
+    scheme := "http"
+    if r.TLS != nil {
+        scheme = "https"
     }
-    op25.running = false
-    op25.flags = nil
-    op25.cmdObj = nil
-    op25.stdoutPipe = nil
-    op25.stderrPipe = nil
-    if *audioBroadcaster != nil {
-        (*audioBroadcaster).Shutdown()
-        *audioBroadcaster = nil
+    ext := filepath.Ext(r.URL.Path)
+    stream := url.URL{
+        Scheme:   scheme,
+        Host:     r.Host,
+        Path:     strings.TrimSuffix(r.URL.Path, ext) + ".mp3",
+        RawQuery: r.URL.RawQuery,
     }
-    if *logBroadcaster != nil {
-        *logBroadcaster = nil
+    w.Header().Set("Access-Control-Allow-Origin", "*")
+    if ext == ".pls" {
+        w.Header().Set("Content-Type", "audio/x-scpls")
+        fmt.Fprintf(w, "[playlist]\nNumberOfEntries=1\nFile1=%s\nTitle1=%s\nLength1=-1\nVersion=2\n", stream.String(), liveName(rx))
+        return
+    }
+    w.Header().Set("Content-Type", "audio/x-mpegurl")
+    fmt.Fprintf(w, "#EXTM3U\n#EXTINF:-1,%s\n%s\n", liveName(rx), stream.String())
+}
+
+// receiverHandler handles a request scoped to one receiver.
+type receiverHandler func(w http.ResponseWriter, r *http.Request, rx *receiver.Receiver)
+
+// handleReceiver registers h twice: on the legacy path, acting on the default
+// receiver, and namespaced per receiver. "/api/talkgroup" is also served at
+// "/api/receivers/{id}/talkgroup" and "/audio.wav" at "/receivers/{id}/audio.wav".
+func handleReceiver(receivers *receiver.Manager, path string, h receiverHandler) {
+    http.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+        h(w, r, receivers.Default())
+    })
+    namespaced := "/receivers/{id}" + path
+    if strings.HasPrefix(path, "/api/") {
+        namespaced = "/api/receivers/{id}/" + strings.TrimPrefix(path, "/api/")
     }
+    http.HandleFunc(namespaced, func(w http.ResponseWriter, r *http.Request) {
+        rx := receivers.Get(r.PathValue("id"))
+        if rx == nil {
+            w.Header().Set("Access-Control-Allow-Origin", "*")
+            http.Error(w, "Unknown receiver", http.StatusNotFound)
+            return
+        }
+        h(w, r, rx)
+    })
 }
 
+
 func main() {
     log.Println("Starting controller25 server...")
     log.Println("Loading configuration...")
@@ -210,76 +406,847 @@ func main() {
 
     cfg := config.MustLoadConfig(configPath)
     log.Printf("Configuration loaded. OP25 path: %s", cfg.Op25RxPath)
-    log.Printf("OP25 Config - Device: %s, Sample Rate: %s, LNA Gain: %s", cfg.SdrDevice, cfg.SampleRate, cfg.LnaGain)
+    for _, rc := range cfg.Receivers {
+        log.Printf("Receiver %s - Device: %s, Sample Rate: %s, LNA Gain: %s, Audio Port: %d, Terminal Port: %d", rc.ID, rc.SdrDevice, rc.SampleRate, rc.LnaGain, rc.AudioPort, rc.TerminalPort)
+    }
+
+    var launcher config.Launcher = config.RxLauncher{}
+    if cfg.Launcher == config.LauncherSimulator {
+        launcher = simulator.NewLauncher()
+        log.Println("Using simulated OP25 (launcher = simulator)")
+    }
 
     log.Println("Changing working directory...")
-    config.MustChdir(cfg.Op25RxPath)
+    if cfg.Launcher == config.LauncherSimulator {
+        // The simulator doesn't need rx.py, so a laptop without OP25 is fine
+        if err := os.Chdir(cfg.Op25RxPath); err != nil {
+            log.Printf("Warning: staying in current directory: %v", err)
+        }
+    } else {
+        config.MustChdir(cfg.Op25RxPath)
+    }
     log.Println("Working directory changed")
 
-    // Clean up any existing OP25 processes to avoid port conflicts
-    killExistingOP25Processes()
+    // Clean up OP25 processes orphaned by a previous run of this controller.
+    // Other OP25 instances on the box are left alone.
+    registry := supervisor.NewRegistry(cfg.PidDir)
+    registry.ReapOrphans(supervisor.DefaultOptions().StopTimeout)
 
     // Do NOT auto-start OP25 on first run!
-    // Instead, wait for API request to /api/op25/start
+    // Instead, wait for API request to /api/op25/start.
+    // Audio and log broadcasters are initialized when each receiver starts.
+    receivers := receiver.NewManager(cfg, launcher, registry)
+    
+    // RTP destinations send whatever their receiver hears once it starts
+    for _, c := range cfg.RTP {
+        sender, err := startRTP(receivers, c)
+        if err != nil {
+            log.Printf("Warning: RTP destination %s: %v", c.Name, err)
+            continue
+        }
+        rtpSenders[c.Name] = sender
+        log.Printf("Sending %s audio from receiver %s to %s over RTP", c.Codec, c.Receiver, c.Address)
+    }
+    
+    // Icecast mount points are fed as a source client would, reconnecting
+    // whenever the server drops them
+    for _, c := range cfg.Icecast {
+        ice, err := startIcecast(receivers, c)
+        if err != nil {
+            log.Printf("Warning: Icecast %s: %v", c.Name, err)
+            continue
+        }
+        icecastSources = append(icecastSources, ice)
+        log.Printf("Feeding Icecast mount %s from receiver %s", ice.Mount(), c.Receiver)
+    }
+
+    // Start mDNS Service
+    mdnsShutdown := make(chan struct{})
+    go mdns.StartmDNSService(mdnsShutdown)
+
+    // Setup HTTP handlers
+    handleReceiver(receivers, "/audio.wav", func(w http.ResponseWriter, r *http.Request, rx *receiver.Receiver) {
+        audioBroadcaster := rx.Audio()
+        if audioBroadcaster == nil {
+            http.Error(w, "Audio not broadcasting (OP25 not started)", http.StatusServiceUnavailable)
+            return
+        }
+        // Optional per-client filter; everything else is heard as silence
+        filter, err := audioFilter(r.URL.Query(), rx, cfg.Favorites)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        audioBroadcaster.ServeFilteredWAV(w, r, filter)
+    })
+    
+    // HLS endpoints
+    handleReceiver(receivers, "/audio.m3u8", func(w http.ResponseWriter, r *http.Request, rx *receiver.Receiver) {
+        audioBroadcaster := rx.Audio()
+        if audioBroadcaster == nil {
+            http.Error(w, "Audio not broadcasting (OP25 not started)", http.StatusServiceUnavailable)
+            return
+        }
+        audioBroadcaster.HLS.ServePlaylist(w, r)
+    })
+    handleReceiver(receivers, "/audio/", func(w http.ResponseWriter, r *http.Request, rx *receiver.Receiver) {
+        audioBroadcaster := rx.Audio()
+        if audioBroadcaster == nil {
+            http.Error(w, "Audio not broadcasting (OP25 not started)", http.StatusServiceUnavailable)
+            return
+        }
+        audioBroadcaster.HLS.ServeSegment(w, r)
+    })
+    
+    // Icecast-style MP3 with ICY "now playing" metadata, and playlists
+    // for players that open those rather than a bare URL
+    handleReceiver(receivers, "/live.mp3", func(w http.ResponseWriter, r *http.Request, rx *receiver.Receiver) {
+        audioBroadcaster := rx.Audio()
+        if audioBroadcaster == nil {
+            http.Error(w, "Audio not broadcasting (OP25 not started)", http.StatusServiceUnavailable)
+            return
+        }
+        filter, err := audioFilter(r.URL.Query(), rx, cfg.Favorites)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        audioBroadcaster.ServeICY(w, r, filter, liveName(rx))
+    })
+    handleReceiver(receivers, "/live.m3u", livePlaylist)
+    handleReceiver(receivers, "/live.pls", livePlaylist)
+    
+    // Per-stream audio when OP25 sends several (TDMA slots, voice channels);
+    // the endpoints above play them mixed
+    handleReceiver(receivers, "/api/audio/streams", func(w http.ResponseWriter, r *http.Request, rx *receiver.Receiver) {
+        w.Header().Set("Access-Control-Allow-Origin", "*")
+        w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+        w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+        
+        if r.Method == http.MethodOptions {
+            w.WriteHeader(http.StatusOK)
+            return
+        }
+        
+        if r.Method != http.MethodGet {
+            http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+            return
+        }
+        
+        audioBroadcaster := rx.Audio()
+        if audioBroadcaster == nil {
+            http.Error(w, "Audio not broadcasting (OP25 not started)", http.StatusServiceUnavailable)
+            return
+        }
+        w.Header().Set("Content-Type", "application/json")
+        _ = json.NewEncoder(w).Encode(map[string]interface{}{
+            "streams": audioBroadcaster.StreamStatus(),
+        })
+    })
+    handleReceiver(receivers, "/streams/{n}/audio.wav", func(w http.ResponseWriter, r *http.Request, rx *receiver.Receiver) {
+        stream := audioStream(w, r, rx)
+        if stream == nil {
+            return
+        }
+        filter, err := audioFilter(r.URL.Query(), rx, cfg.Favorites)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        stream.ServeFilteredWAV(w, r, filter)
+    })
+    handleReceiver(receivers, "/streams/{n}/audio.m3u8", func(w http.ResponseWriter, r *http.Request, rx *receiver.Receiver) {
+        if stream := audioStream(w, r, rx); stream != nil {
+            stream.HLS.ServePlaylist(w, r)
+        }
+    })
+    handleReceiver(receivers, "/streams/{n}/audio/", func(w http.ResponseWriter, r *http.Request, rx *receiver.Receiver) {
+        if stream := audioStream(w, r, rx); stream != nil {
+            stream.HLS.ServeSegment(w, r)
+        }
+    })
+    
+    // WebSocket audio: PCM frames with call metadata in-band. ?stream=N
+    // plays one stream on its own, as /streams/N/ does
+    handleReceiver(receivers, "/ws/audio", func(w http.ResponseWriter, r *http.Request, rx *receiver.Receiver) {
+        audioBroadcaster := rx.Audio()
+        if audioBroadcaster == nil {
+            http.Error(w, "Audio not broadcasting (OP25 not started)", http.StatusServiceUnavailable)
+            return
+        }
+        if n := r.URL.Query().Get("stream"); n != "" {
+            i, err := strconv.Atoi(n)
+            stream := audioBroadcaster.Stream(i)
+            if err != nil || stream == nil {
+                http.Error(w, "Unknown audio stream", http.StatusNotFound)
+                return
+            }
+            audioBroadcaster = stream
+        }
+        wsaudio.Handler(wsaudio.Options{
+            Audio: audioBroadcaster,
+            Calls: audioBroadcaster.Calls(),
+            AlphaTag: func(tgid int) string {
+                return rx.Directory.Lookup(rx.Config.SystemID(), tgid).Name
+            },
+        }).ServeHTTP(w, r)
+    })
+    
+    handleReceiver(receivers, "/stream", func(w http.ResponseWriter, r *http.Request, rx *receiver.Receiver) {
+        logBroadcaster := rx.Logs()
+        if logBroadcaster == nil {
+            http.Error(w, "Logs not broadcasting (OP25 not started)", http.StatusServiceUnavailable)
+            return
+        }
+        logBroadcaster.ServeSSE(w, r)
+    })
+    http.HandleFunc("/health", health.ServeHealth)
+    
+    // Receiver list endpoint
+    http.HandleFunc("/api/receivers", func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Access-Control-Allow-Origin", "*")
+        w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+        w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+        
+        if r.Method == http.MethodOptions {
+            w.WriteHeader(http.StatusOK)
+            return
+        }
+        
+        if r.Method != http.MethodGet {
+            http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+            return
+        }
+        
+        list := []receiver.Info{}
+        for _, rx := range receivers.All() {
+            list = append(list, rx.Info())
+        }
+        
+        w.Header().Set("Content-Type", "application/json")
+        _ = json.NewEncoder(w).Encode(map[string]interface{}{
+            "receivers": list,
+        })
+    })
+    
+    // Structured OP25 state from the terminal: system, site and voice channels
+    handleReceiver(receivers, "/api/system/state", func(w http.ResponseWriter, r *http.Request, rx *receiver.Receiver) {
+        w.Header().Set("Access-Control-Allow-Origin", "*")
+        w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+        w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+        
+        if r.Method == http.MethodOptions {
+            w.WriteHeader(http.StatusOK)
+            return
+        }
+        
+        if r.Method != http.MethodGet {
+            http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+            return
+        }
+        
+        response := map[string]interface{}{
+            "source": rx.Talkgroups.Name(),
+            "system": rx.Tracker.State(),
+        }
+        if err := rx.Tracker.LastError(); err != nil {
+            response["error"] = err.Error()
+        }
+        
+        w.Header().Set("Content-Type", "application/json")
+        _ = json.NewEncoder(w).Encode(response)
+    })
+    
+    // Talkgroup info endpoint
+    handleReceiver(receivers, "/api/talkgroup", func(w http.ResponseWriter, r *http.Request, rx *receiver.Receiver) {
+        w.Header().Set("Access-Control-Allow-Origin", "*")
+        w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+        w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+        
+        if r.Method == http.MethodOptions {
+            w.WriteHeader(http.StatusOK)
+            return
+        }
+        
+        if r.Method != http.MethodGet {
+            http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+            return
+        }
+        
+        tg := rx.Talkgroups.GetActiveTalkgroupData()
+        cc := rx.Talkgroups.GetControlChannel()
+        
+        response := map[string]interface{}{
+            "talkgroup":       tg,
+            "control_channel": cc,
+            "hold":            rx.HoldState(),
+            "system":          rx.Tracker.State(), // null unless the terminal is reachable
+            "source":          rx.Talkgroups.Name(),
+            "calls":           rx.Talkgroups.Calls(),   // every call on the system's voice channels
+            "playing":         rx.Talkgroups.Playing(), // the call the audio stream carries
+        }
+        
+        w.Header().Set("Content-Type", "application/json")
+        _ = json.NewEncoder(w).Encode(response)
+    })
+
+    // Hold on a talkgroup without restarting OP25
+    handleReceiver(receivers, "/api/control/hold", func(w http.ResponseWriter, r *http.Request, rx *receiver.Receiver) {
+        w.Header().Set("Access-Control-Allow-Origin", "*")
+        w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+        w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+        
+        if r.Method == http.MethodOptions {
+            w.WriteHeader(http.StatusOK)
+            return
+        }
+        
+        if r.Method != http.MethodPost {
+            http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+            return
+        }
+        
+        var req ControlHoldRequest
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+            w.WriteHeader(http.StatusBadRequest)
+            _ = json.NewEncoder(w).Encode(ControlResponse{Hold: rx.HoldState(), Error: "Invalid request body"})
+            return
+        }
+        
+        // Accept 2001, "2001" or "current"; no tgid at all means current
+        tgidStr := strings.Trim(string(req.Tgid), `"`)
+        var hold receiver.HoldState
+        var err error
+        if tgidStr == "" || tgidStr == "null" || tgidStr == "current" {
+            hold, err = rx.HoldCurrent(r.Context())
+        } else {
+            tgid, convErr := strconv.Atoi(tgidStr)
+            if convErr != nil || tgid <= 0 {
+                w.WriteHeader(http.StatusBadRequest)
+                _ = json.NewEncoder(w).Encode(ControlResponse{Hold: rx.HoldState(), Error: "tgid must be a talkgroup ID or \"current\""})
+                return
+            }
+            hold, err = rx.Hold(r.Context(), tgid)
+        }
+        if err != nil {
+            w.WriteHeader(controlStatus(err))
+            _ = json.NewEncoder(w).Encode(ControlResponse{Hold: hold, Error: err.Error()})
+            return
+        }
+        _ = json.NewEncoder(w).Encode(ControlResponse{Success: true, Hold: hold})
+    })
+    
+    handleReceiver(receivers, "/api/control/release", func(w http.ResponseWriter, r *http.Request, rx *receiver.Receiver) {
+        w.Header().Set("Access-Control-Allow-Origin", "*")
+        w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+        w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+        
+        if r.Method == http.MethodOptions {
+            w.WriteHeader(http.StatusOK)
+            return
+        }
+        
+        if r.Method != http.MethodPost {
+            http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+            return
+        }
+        
+        if err := rx.Release(r.Context()); err != nil {
+            w.WriteHeader(controlStatus(err))
+            _ = json.NewEncoder(w).Encode(ControlResponse{Hold: rx.HoldState(), Error: err.Error()})
+            return
+        }
+        _ = json.NewEncoder(w).Encode(ControlResponse{Success: true, Hold: rx.HoldState()})
+    })
+    
+    handleReceiver(receivers, "/api/control/skip", func(w http.ResponseWriter, r *http.Request, rx *receiver.Receiver) {
+        w.Header().Set("Access-Control-Allow-Origin", "*")
+        w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+        w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+        
+        if r.Method == http.MethodOptions {
+            w.WriteHeader(http.StatusOK)
+            return
+        }
+        
+        if r.Method != http.MethodPost {
+            http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+            return
+        }
+        
+        if err := rx.Skip(r.Context()); err != nil {
+            w.WriteHeader(controlStatus(err))
+            _ = json.NewEncoder(w).Encode(ControlResponse{Hold: rx.HoldState(), Error: err.Error()})
+            return
+        }
+        _ = json.NewEncoder(w).Encode(ControlResponse{Success: true, Hold: rx.HoldState()})
+    })
+
+    // Lock out a talkgroup on the live decoder, for a while or for good
+    handleReceiver(receivers, "/api/control/lockout", func(w http.ResponseWriter, r *http.Request, rx *receiver.Receiver) {
+        w.Header().Set("Access-Control-Allow-Origin", "*")
+        w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+        w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+        
+        if r.Method == http.MethodOptions {
+            w.WriteHeader(http.StatusOK)
+            return
+        }
+        
+        if r.Method != http.MethodPost {
+            http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+            return
+        }
+        
+        var req ControlLockoutRequest
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Tgid <= 0 {
+            w.WriteHeader(http.StatusBadRequest)
+            _ = json.NewEncoder(w).Encode(ControlLockoutResponse{Error: "tgid is required"})
+            return
+        }
+        
+        var duration time.Duration
+        if len(req.Duration) > 0 && string(req.Duration) != "null" {
+            var text string
+            var seconds float64
+            if err := json.Unmarshal(req.Duration, &seconds); err == nil {
+                duration = time.Duration(seconds * float64(time.Second))
+            } else if err := json.Unmarshal(req.Duration, &text); err == nil {
+                duration, err = time.ParseDuration(text)
+                if err != nil {
+                    w.WriteHeader(http.StatusBadRequest)
+                    _ = json.NewEncoder(w).Encode(ControlLockoutResponse{Error: fmt.Sprintf("Invalid duration: %v", err)})
+                    return
+                }
+            }
+            if duration <= 0 {
+                w.WriteHeader(http.StatusBadRequest)
+                _ = json.NewEncoder(w).Encode(ControlLockoutResponse{Error: "duration must be positive, or omitted for a permanent lockout"})
+                return
+            }
+        }
+        
+        lockout, err := rx.Lockout(r.Context(), req.Tgid, duration)
+        if err != nil {
+            w.WriteHeader(controlStatus(err))
+            _ = json.NewEncoder(w).Encode(ControlLockoutResponse{Error: err.Error()})
+            return
+        }
+        _ = json.NewEncoder(w).Encode(ControlLockoutResponse{Success: true, Lockout: &lockout})
+    })
+    
+    handleReceiver(receivers, "/api/control/unlock", func(w http.ResponseWriter, r *http.Request, rx *receiver.Receiver) {
+        w.Header().Set("Access-Control-Allow-Origin", "*")
+        w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+        w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+        
+        if r.Method == http.MethodOptions {
+            w.WriteHeader(http.StatusOK)
+            return
+        }
+        
+        if r.Method != http.MethodPost {
+            http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+            return
+        }
+        
+        var req ControlLockoutRequest
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Tgid <= 0 {
+            w.WriteHeader(http.StatusBadRequest)
+            _ = json.NewEncoder(w).Encode(ControlLockoutResponse{Error: "tgid is required"})
+            return
+        }
+        
+        if err := rx.Unlock(r.Context(), req.Tgid); err != nil {
+            w.WriteHeader(controlStatus(err))
+            _ = json.NewEncoder(w).Encode(ControlLockoutResponse{Error: err.Error()})
+            return
+        }
+        _ = json.NewEncoder(w).Encode(ControlLockoutResponse{Success: true, Lockouts: rx.Lockouts()})
+    })
+    
+    handleReceiver(receivers, "/api/control/lockouts", func(w http.ResponseWriter, r *http.Request, rx *receiver.Receiver) {
+        w.Header().Set("Access-Control-Allow-Origin", "*")
+        w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+        w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+        
+        if r.Method == http.MethodOptions {
+            w.WriteHeader(http.StatusOK)
+            return
+        }
+        
+        if r.Method != http.MethodGet {
+            http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+            return
+        }
+        
+        _ = json.NewEncoder(w).Encode(map[string]interface{}{
+            "success":  true,
+            "lockouts": rx.Lockouts(),
+        })
+    })
+
+    handleReceiver(receivers, "/api/op25/start", func(w http.ResponseWriter, r *http.Request, rx *receiver.Receiver) {
+        w.Header().Set("Access-Control-Allow-Origin", "*")
+        w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+        w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+        
+        if r.Method == http.MethodOptions {
+            w.WriteHeader(http.StatusOK)
+            return
+        }
+        
+        if r.Method != http.MethodPost {
+            http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+            return
+        }
+
+        // The kill/sleep/start sequence takes a couple of seconds, so it runs in
+        // the background; clients follow progress on /api/op25/events
+        rx.Supervisor().MarkStarting("start requested via API")
+        go func() {
+            if err := rx.Start("start requested via API"); err != nil {
+                log.Printf("[%s] Failed to start OP25: %v", rx.ID, err)
+            }
+        }()
+        
+        resp := Op25StartResponse{Started: true, State: supervisor.StateStarting}
+        _ = json.NewEncoder(w).Encode(resp)
+    })
+
+    handleReceiver(receivers, "/api/op25/stop", func(w http.ResponseWriter, r *http.Request, rx *receiver.Receiver) {
+        w.Header().Set("Access-Control-Allow-Origin", "*")
+        w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+        w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+        
+        if r.Method == http.MethodOptions {
+            w.WriteHeader(http.StatusOK)
+            return
+        }
+        
+        if r.Method != http.MethodPost {
+            http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+            return
+        }
+        if !rx.Stop("stop requested via API") {
+            w.WriteHeader(http.StatusConflict)
+            _ = json.NewEncoder(w).Encode(Op25StartResponse{Started: false, Error: "OP25 not running"})
+            return
+        }
+        _ = json.NewEncoder(w).Encode(Op25StartResponse{Started: false, State: supervisor.StateStopped})
+    })
+
+    handleReceiver(receivers, "/api/op25/status", func(w http.ResponseWriter, r *http.Request, rx *receiver.Receiver) {
+        w.Header().Set("Access-Control-Allow-Origin", "*")
+        w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+        w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+        
+        if r.Method == http.MethodOptions {
+            w.WriteHeader(http.StatusOK)
+            return
+        }
+        
+        // Served from the supervisor so it never waits on a start/stop in progress
+        _ = json.NewEncoder(w).Encode(rx.Supervisor().Status())
+    })
+
+    // OP25 lifecycle transitions (Server-Sent Events)
+    handleReceiver(receivers, "/api/op25/events", func(w http.ResponseWriter, r *http.Request, rx *receiver.Receiver) {
+        if r.Method == http.MethodOptions {
+            w.Header().Set("Access-Control-Allow-Origin", "*")
+            w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+            w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+            w.WriteHeader(http.StatusOK)
+            return
+        }
+        rx.Supervisor().ServeEvents(w, r)
+    })
+
+    // Call start/update/end events (Server-Sent Events)
+    handleReceiver(receivers, "/api/calls/stream", func(w http.ResponseWriter, r *http.Request, rx *receiver.Receiver) {
+        if r.Method == http.MethodOptions {
+            w.Header().Set("Access-Control-Allow-Origin", "*")
+            w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+            w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+            w.WriteHeader(http.StatusOK)
+            return
+        }
+        rx.Calls.ServeEvents(w, r)
+    })
+
+    // Instant replay from memory: calls still in the buffer
+    handleReceiver(receivers, "/api/replay/calls", func(w http.ResponseWriter, r *http.Request, rx *receiver.Receiver) {
+        w.Header().Set("Access-Control-Allow-Origin", "*")
+        w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+        w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+        
+        if r.Method == http.MethodOptions {
+            w.WriteHeader(http.StatusOK)
+            return
+        }
+        
+        if r.Method != http.MethodGet {
+            http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+            return
+        }
+        
+        if rx.Replay == nil {
+            http.Error(w, "Replay buffer disabled", http.StatusServiceUnavailable)
+            return
+        }
+        
+        w.Header().Set("Content-Type", "application/json")
+        _ = json.NewEncoder(w).Encode(map[string]interface{}{
+            "buffer_seconds": rx.Replay.Size().Seconds(),
+            "calls":          rx.Replay.Calls(),
+        })
+    })
+
+    // Instant replay audio: ?seconds=N, ?tgid=X, ?call=<id>, or the last call
+    handleReceiver(receivers, "/api/replay/audio", func(w http.ResponseWriter, r *http.Request, rx *receiver.Receiver) {
+        w.Header().Set("Access-Control-Allow-Origin", "*")
+        w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, OPTIONS")
+        w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+        w.Header().Set("Access-Control-Expose-Headers", "X-Call-ID, X-Talkgroup-ID, X-Source-ID")
+        
+        if r.Method == http.MethodOptions {
+            w.WriteHeader(http.StatusOK)
+            return
+        }
+        
+        if r.Method != http.MethodGet && r.Method != http.MethodHead {
+            http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+            return
+        }
+        
+        if rx.Replay == nil {
+            http.Error(w, "Replay buffer disabled", http.StatusServiceUnavailable)
+            return
+        }
+        rx.Replay.ServeAudio(w, r)
+    })
+
+    // Favorites: named talkgroup sets for /audio.wav?favorites=<name>
+    http.HandleFunc("/api/favorites", func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Access-Control-Allow-Origin", "*")
+        w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+        w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+        
+        if r.Method == http.MethodOptions {
+            w.WriteHeader(http.StatusOK)
+            return
+        }
+        
+        if r.Method != http.MethodGet {
+            http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+            return
+        }
+        
+        favoritesMu.Lock()
+        defer favoritesMu.Unlock()
+        w.Header().Set("Content-Type", "application/json")
+        _ = json.NewEncoder(w).Encode(map[string]interface{}{
+            "favorites": cfg.Favorites,
+        })
+    })
+
+    http.HandleFunc("/api/favorites/{name}", func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Access-Control-Allow-Origin", "*")
+        w.Header().Set("Access-Control-Allow-Methods", "PUT, DELETE, OPTIONS")
+        w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+        
+        if r.Method == http.MethodOptions {
+            w.WriteHeader(http.StatusOK)
+            return
+        }
+        
+        name := r.PathValue("name")
+        favoritesMu.Lock()
+        defer favoritesMu.Unlock()
+        switch r.Method {
+        case http.MethodPut:
+            var req struct {
+                Talkgroups []int `json:"talkgroups"`
+            }
+            if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+                http.Error(w, "Invalid request body", http.StatusBadRequest)
+                return
+            }
+            if strings.ContainsAny(name, "=:[]#;") {
+                http.Error(w, "Invalid favorites name", http.StatusBadRequest)
+                return
+            }
+            cfg.Favorites[name] = req.Talkgroups
+        case http.MethodDelete:
+            if _, ok := cfg.Favorites[name]; !ok {
+                http.Error(w, "Unknown favorites", http.StatusNotFound)
+                return
+            }
+            delete(cfg.Favorites, name)
+        default:
+            http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+            return
+        }
+        if err := config.SaveFavorites(configPath, cfg.Favorites); err != nil {
+            log.Printf("Warning: Failed to save favorites to config.ini: %v", err)
+            http.Error(w, "Failed to save favorites", http.StatusInternalServerError)
+            return
+        }
+        
+        w.Header().Set("Content-Type", "application/json")
+        _ = json.NewEncoder(w).Encode(map[string]interface{}{
+            "success":    true,
+            "name":       name,
+            "talkgroups": cfg.Favorites[name],
+        })
+    })
 
-    // Create talkgroup parser
-    tgParser = talkgroup.NewParser()
-    
-    // Start a ticker to clean up expired talkgroups
-    go func() {
-        ticker := time.NewTicker(2 * time.Second)
-        defer ticker.Stop()
-        for range ticker.C {
-            tgParser.ClearExpired()
+    // Audio processing applied to every receiver's live audio
+    http.HandleFunc("/api/audio/dsp", func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Access-Control-Allow-Origin", "*")
+        w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+        w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+        
+        if r.Method == http.MethodOptions {
+            w.WriteHeader(http.StatusOK)
+            return
         }
-    }()
-
-    // Audio and log broadcasters are initialized when OP25 starts
-    var (
-        audioBroadcaster *audio.Broadcaster
-        logBroadcaster   *logstream.Broadcaster
-    )
-
-    // Start mDNS Service
-    mdnsShutdown := make(chan struct{})
-    go mdns.StartmDNSService(mdnsShutdown)
+        
+        dsp := receivers.DSP()
+        w.Header().Set("Content-Type", "application/json")
+        switch r.Method {
+        case http.MethodGet:
+            _ = json.NewEncoder(w).Encode(dspConfigResponse(dsp.Config()))
+        case http.MethodPost:
+            var req DSPConfigRequest
+            if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+                w.WriteHeader(http.StatusBadRequest)
+                _ = json.NewEncoder(w).Encode(DSPConfigResponse{Error: "Invalid request body"})
+                return
+            }
+            
+            dspMu.Lock()
+            defer dspMu.Unlock()
+            c := dsp.Config()
+            if req.HighPass != nil {
+                c.HighPass = *req.HighPass
+            }
+            if req.HighPassHz != nil {
+                c.HighPassHz = *req.HighPassHz
+            }
+            if req.Gate != nil {
+                c.Gate = *req.Gate
+            }
+            if req.GateThreshold != nil {
+                c.GateThreshold = *req.GateThreshold
+            }
+            if req.AGC != nil {
+                c.AGC = *req.AGC
+            }
+            if req.AGCTarget != nil {
+                c.AGCTarget = *req.AGCTarget
+            }
+            if req.AGCMaxGain != nil {
+                c.AGCMaxGain = *req.AGCMaxGain
+            }
+            if err := dsp.SetConfig(c); err != nil {
+                w.WriteHeader(http.StatusBadRequest)
+                _ = json.NewEncoder(w).Encode(DSPConfigResponse{Error: err.Error()})
+                return
+            }
+            cfg.DSP = c
+            if err := config.SaveDSP(configPath, c); err != nil {
+                log.Printf("Warning: Failed to save DSP settings to config.ini: %v", err)
+                w.WriteHeader(http.StatusInternalServerError)
+                _ = json.NewEncoder(w).Encode(DSPConfigResponse{Error: err.Error()})
+                return
+            }
+            _ = json.NewEncoder(w).Encode(dspConfigResponse(c))
+        default:
+            http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+        }
+    })
 
-    // Setup HTTP handlers
-    http.HandleFunc("/audio.wav", func(w http.ResponseWriter, r *http.Request) {
-        if audioBroadcaster == nil {
-            http.Error(w, "Audio not broadcasting (OP25 not started)", http.StatusServiceUnavailable)
+    // Per-talkgroup gain offsets, applied after the AGC
+    http.HandleFunc("/api/audio/gains", func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Access-Control-Allow-Origin", "*")
+        w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+        w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+        
+        if r.Method == http.MethodOptions {
+            w.WriteHeader(http.StatusOK)
             return
         }
-        audioBroadcaster.ServeWAV(w, r)
-    })
-    
-    // HLS endpoints
-    http.HandleFunc("/audio.m3u8", func(w http.ResponseWriter, r *http.Request) {
-        if audioBroadcaster == nil {
-            http.Error(w, "Audio not broadcasting (OP25 not started)", http.StatusServiceUnavailable)
+        
+        if r.Method != http.MethodGet {
+            http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
             return
         }
-        audioBroadcaster.HLS.ServePlaylist(w, r)
+        
+        w.Header().Set("Content-Type", "application/json")
+        _ = json.NewEncoder(w).Encode(map[string]interface{}{
+            "gains": receivers.DSP().Gains(),
+        })
     })
-    http.HandleFunc("/audio/", func(w http.ResponseWriter, r *http.Request) {
-        if audioBroadcaster == nil {
-            http.Error(w, "Audio not broadcasting (OP25 not started)", http.StatusServiceUnavailable)
+
+    http.HandleFunc("/api/audio/gains/{tgid}", func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Access-Control-Allow-Origin", "*")
+        w.Header().Set("Access-Control-Allow-Methods", "PUT, DELETE, OPTIONS")
+        w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+        
+        if r.Method == http.MethodOptions {
+            w.WriteHeader(http.StatusOK)
             return
         }
-        audioBroadcaster.HLS.ServeSegment(w, r)
-    })
-    
-    http.HandleFunc("/stream", func(w http.ResponseWriter, r *http.Request) {
-        if logBroadcaster == nil {
-            http.Error(w, "Logs not broadcasting (OP25 not started)", http.StatusServiceUnavailable)
+        
+        tgid, err := strconv.Atoi(r.PathValue("tgid"))
+        if err != nil || tgid <= 0 {
+            http.Error(w, "Invalid talkgroup", http.StatusBadRequest)
             return
         }
-        logBroadcaster.ServeSSE(w, r)
+        dsp := receivers.DSP()
+        dspMu.Lock()
+        defer dspMu.Unlock()
+        switch r.Method {
+        case http.MethodPut:
+            var req struct {
+                GainDB *float64 `json:"gain_db"`
+            }
+            if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.GainDB == nil {
+                http.Error(w, "Invalid request body", http.StatusBadRequest)
+                return
+            }
+            if err := dsp.SetGain(tgid, *req.GainDB); err != nil {
+                http.Error(w, err.Error(), http.StatusBadRequest)
+                return
+            }
+        case http.MethodDelete:
+            if !dsp.DeleteGain(tgid) {
+                http.Error(w, "No gain set for talkgroup", http.StatusNotFound)
+                return
+            }
+        default:
+            http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+            return
+        }
+        gains := dsp.Gains()
+        cfg.TalkgroupGains = gains
+        if err := config.SaveTalkgroupGains(configPath, gains); err != nil {
+            log.Printf("Warning: Failed to save talkgroup gains to config.ini: %v", err)
+            http.Error(w, "Failed to save talkgroup gains", http.StatusInternalServerError)
+            return
+        }
+        
+        w.Header().Set("Content-Type", "application/json")
+        _ = json.NewEncoder(w).Encode(map[string]interface{}{
+            "success": true,
+            "tgid":    tgid,
+            "gain_db": gains[tgid],
+        })
     })
-    http.HandleFunc("/health", health.ServeHealth)
-    
-    // Talkgroup info endpoint
-    http.HandleFunc("/api/talkgroup", func(w http.ResponseWriter, r *http.Request) {
+
+    // RTP destinations
+    http.HandleFunc("/api/rtp", func(w http.ResponseWriter, r *http.Request) {
         w.Header().Set("Access-Control-Allow-Origin", "*")
         w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
         w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
@@ -294,21 +1261,124 @@ func main() {
             return
         }
         
-        tg := tgParser.GetActiveTalkgroupData()
-        cc := tgParser.GetControlChannel()
+        rtpMu.Lock()
+        destinations := []RTPDestination{}
+        for _, c := range cfg.RTP {
+            if sender, ok := rtpSenders[c.Name]; ok {
+                destinations = append(destinations, rtpDestination(sender))
+            }
+        }
+        rtpMu.Unlock()
+        w.Header().Set("Content-Type", "application/json")
+        _ = json.NewEncoder(w).Encode(map[string]interface{}{
+            "destinations": destinations,
+            "codecs":       audio.RTPCodecs,
+        })
+    })
+
+    http.HandleFunc("/api/rtp/{name}", func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Access-Control-Allow-Origin", "*")
+        w.Header().Set("Access-Control-Allow-Methods", "GET, PUT, DELETE, OPTIONS")
+        w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+        
+        if r.Method == http.MethodOptions {
+            w.WriteHeader(http.StatusOK)
+            return
+        }
         
-        response := map[string]interface{}{
-            "talkgroup":       tg,
-            "control_channel": cc,
+        name := r.PathValue("name")
+        rtpMu.Lock()
+        defer rtpMu.Unlock()
+        var destination RTPDestination
+        switch r.Method {
+        case http.MethodGet:
+            sender, ok := rtpSenders[name]
+            if !ok {
+                http.Error(w, "Unknown RTP destination", http.StatusNotFound)
+                return
+            }
+            destination = rtpDestination(sender)
+        case http.MethodPut:
+            var req RTPDestination
+            if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+                http.Error(w, "Invalid request body", http.StatusBadRequest)
+                return
+            }
+            if strings.ContainsAny(name, "[]") {
+                http.Error(w, "Invalid RTP destination name", http.StatusBadRequest)
+                return
+            }
+            c := config.RTPConfig{
+                Name:       name,
+                Address:    req.Address,
+                Codec:      strings.ToLower(req.Codec),
+                Receiver:   req.Receiver,
+                Stream:     -1,
+                Talkgroups: req.Talkgroups,
+                TTL:        max(req.TTL, 1),
+            }
+            if c.Codec == "" {
+                c.Codec = "pcmu"
+            }
+            if c.Receiver == "" {
+                c.Receiver = config.DefaultReceiverID
+            }
+            if req.Stream != nil {
+                c.Stream = *req.Stream
+            }
+            sender, err := startRTP(receivers, c)
+            if err != nil {
+                http.Error(w, err.Error(), http.StatusBadRequest)
+                return
+            }
+            // Replace the destination in place, keeping config file order
+            if old, ok := rtpSenders[name]; ok {
+                old.Close()
+            }
+            rtpSenders[name] = sender
+            replaced := false
+            for i := range cfg.RTP {
+                if cfg.RTP[i].Name == name {
+                    cfg.RTP[i], replaced = sender.Config(), true
+                }
+            }
+            if !replaced {
+                cfg.RTP = append(cfg.RTP, sender.Config())
+            }
+            destination = rtpDestination(sender)
+        case http.MethodDelete:
+            sender, ok := rtpSenders[name]
+            if !ok {
+                http.Error(w, "Unknown RTP destination", http.StatusNotFound)
+                return
+            }
+            sender.Close()
+            delete(rtpSenders, name)
+            cfg.RTP = slices.DeleteFunc(cfg.RTP, func(c config.RTPConfig) bool { return c.Name == name })
+        default:
+            http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+            return
+        }
+        if r.Method != http.MethodGet {
+            if err := config.SaveRTP(configPath, cfg.RTP); err != nil {
+                log.Printf("Warning: Failed to save RTP destinations to config.ini: %v", err)
+                http.Error(w, "Failed to save RTP destinations", http.StatusInternalServerError)
+                return
+            }
         }
         
         w.Header().Set("Content-Type", "application/json")
-        _ = json.NewEncoder(w).Encode(response)
+        if r.Method == http.MethodDelete {
+            _ = json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+            return
+        }
+        _ = json.NewEncoder(w).Encode(destination)
     })
 
-    http.HandleFunc("/api/op25/start", func(w http.ResponseWriter, r *http.Request) {
+    // Icecast source connections
+    http.HandleFunc("/api/icecast", func(w http.ResponseWriter, r *http.Request) {
         w.Header().Set("Access-Control-Allow-Origin", "*")
-        w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+        w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
         w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
         
         if r.Method == http.MethodOptions {
@@ -316,65 +1386,116 @@ func main() {
             return
         }
         
-        if r.Method != http.MethodPost {
+        if r.Method != http.MethodGet {
             http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
             return
         }
+        
+        mounts := []map[string]interface{}{}
+        for _, ice := range icecastSources {
+            c, status := ice.Config(), ice.Status()
+            mount := map[string]interface{}{
+                "name":      c.Name,
+                "mount":     ice.Mount(),
+                "receiver":  c.Receiver,
+                "connected": status.Connected,
+                "since":     status.Since,
+                "title":     status.Title,
+                "bytes":     status.Bytes,
+                "failures":  status.Failures,
+            }
+            if status.Error != "" {
+                mount["error"] = status.Error
+            }
+            mounts = append(mounts, mount)
+        }
+        w.Header().Set("Content-Type", "application/json")
+        _ = json.NewEncoder(w).Encode(map[string]interface{}{
+            "mounts": mounts,
+        })
+    })
 
-        op25.mu.Lock()
-        defer op25.mu.Unlock()
+    // Call upload queue
+    http.HandleFunc("/api/uploads", func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Access-Control-Allow-Origin", "*")
+        w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+        w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+        
+        if r.Method == http.MethodOptions {
+            w.WriteHeader(http.StatusOK)
+            return
+        }
         
-        // Clean up any existing OP25 processes first
-        killExistingOP25Processes()
+        if r.Method != http.MethodGet {
+            http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+            return
+        }
         
-        // If already running, shut down and restart
-        if op25.running {
-            stopOp25(&audioBroadcaster, &logBroadcaster)
-            // Give time for UDP port to be fully released
-            time.Sleep(500 * time.Millisecond)
+        targets := []uploader.Status{}
+        if uploads := receivers.Uploads(); uploads != nil {
+            targets = uploads.Status()
+        }
+        w.Header().Set("Content-Type", "application/json")
+        _ = json.NewEncoder(w).Encode(map[string]interface{}{
+            "targets": targets,
+        })
+    })
+
+    // SDP file for each RTP destination, e.g. /rtp/dispatch.sdp
+    http.HandleFunc("/rtp/{file}", func(w http.ResponseWriter, r *http.Request) {
+        name, ok := strings.CutSuffix(r.PathValue("file"), ".sdp")
+        if !ok {
+            http.NotFound(w, r)
+            return
         }
+        rtpMu.Lock()
+        sender, ok := rtpSenders[name]
+        rtpMu.Unlock()
+        if !ok {
+            http.Error(w, "Unknown RTP destination", http.StatusNotFound)
+            return
+        }
+        w.Header().Set("Access-Control-Allow-Origin", "*")
+        w.Header().Set("Content-Type", "application/sdp")
+        _, _ = io.WriteString(w, sender.SDP())
+    })
 
-        // Build flags from config (includes audio streaming flags)
-        flags := config.BuildOP25Flags(cfg)
-        log.Printf("Starting OP25 with flags: %v", flags)
+    // Call history: every receiver's finished calls, newest first
+    http.HandleFunc("/api/calls", func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Access-Control-Allow-Origin", "*")
+        w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+        w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
         
-        // Start audio broadcaster BEFORE OP25 to ensure UDP listener is ready
-        audioBroadcaster = audio.NewBroadcaster("127.0.0.1:23456")
-        audioBroadcaster.SetTalkgroupGetter(tgParser)
-        go audioBroadcaster.Start()
+        if r.Method == http.MethodOptions {
+            w.WriteHeader(http.StatusOK)
+            return
+        }
         
-        // Give audio broadcaster time to bind to UDP port
-        time.Sleep(100 * time.Millisecond)
+        if r.Method != http.MethodGet {
+            http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+            return
+        }
         
-        // Start OP25 with config-based flags
-        op25Cmd, stdoutPipe, stderrPipe, err := config.StartOp25ProcessUDPWithFlags(flags)
+        calls := receivers.History()
+        if calls == nil {
+            http.Error(w, "Call history unavailable", http.StatusServiceUnavailable)
+            return
+        }
+        q, err := history.ParseQuery(r.URL.Query())
         if err != nil {
-            // Clean up audio broadcaster if OP25 fails to start
-            audioBroadcaster.Shutdown()
-            audioBroadcaster = nil
-            resp := Op25StartResponse{Started: false, Error: err.Error()}
-            _ = json.NewEncoder(w).Encode(resp)
+            http.Error(w, err.Error(), http.StatusBadRequest)
             return
         }
-
-        op25.cmdObj = op25Cmd
-        op25.stdoutPipe = stdoutPipe
-        op25.stderrPipe = stderrPipe
-        op25.running = true
-        op25.flags = flags
-
-        // Start log broadcaster
-        logBroadcaster = logstream.NewBroadcaster(stdoutPipe, stderrPipe)
-        logBroadcaster.SetParser(tgParser)
-        go logBroadcaster.Start()
-
-        resp := Op25StartResponse{Started: true}
-        _ = json.NewEncoder(w).Encode(resp)
+        q.Receiver = r.URL.Query().Get("receiver")
+        
+        w.Header().Set("Content-Type", "application/json")
+        _ = json.NewEncoder(w).Encode(calls.Query(q))
     })
 
-    http.HandleFunc("/api/op25/stop", func(w http.ResponseWriter, r *http.Request) {
+    // Bulk export of a time window: zip of the audio plus manifest.json
+    http.HandleFunc("/api/calls/export", func(w http.ResponseWriter, r *http.Request) {
         w.Header().Set("Access-Control-Allow-Origin", "*")
-        w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+        w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
         w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
         
         if r.Method == http.MethodOptions {
@@ -382,22 +1503,31 @@ func main() {
             return
         }
         
-        if r.Method != http.MethodPost {
+        if r.Method != http.MethodGet {
             http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
             return
         }
-        op25.mu.Lock()
-        defer op25.mu.Unlock()
-        if !op25.running || op25.cmdObj == nil {
-            w.WriteHeader(http.StatusConflict)
-            _ = json.NewEncoder(w).Encode(Op25StartResponse{Started: false, Error: "OP25 not running"})
+        
+        q, err := history.ParseQuery(r.URL.Query())
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        if q.Since.IsZero() {
+            http.Error(w, "since is required", http.StatusBadRequest)
             return
         }
-        stopOp25(&audioBroadcaster, &logBroadcaster)
-        _ = json.NewEncoder(w).Encode(Op25StartResponse{Started: false})
+        q.Receiver = r.URL.Query().Get("receiver")
+        
+        w.Header().Set("Content-Type", "application/zip")
+        w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="calls-%s.zip"`, q.Since.Format("20060102-150405")))
+        if err := history.Export(w, receivers.History(), receivers.Recordings(), q); err != nil {
+            log.Printf("Call export failed: %v", err)
+        }
     })
 
-    http.HandleFunc("/api/op25/status", func(w http.ResponseWriter, r *http.Request) {
+    // Stored call: log entry and recording
+    http.HandleFunc("/api/calls/{call}", func(w http.ResponseWriter, r *http.Request) {
         w.Header().Set("Access-Control-Allow-Origin", "*")
         w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
         w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
@@ -407,12 +1537,66 @@ func main() {
             return
         }
         
-        op25.mu.Lock()
-        defer op25.mu.Unlock()
-        _ = json.NewEncoder(w).Encode(Op25StatusResponse{
-            Running: op25.running,
-            Flags:   op25.flags,
-        })
+        if r.Method != http.MethodGet {
+            http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+            return
+        }
+        
+        id := r.PathValue("call")
+        response := map[string]interface{}{"id": id}
+        found := false
+        if calls := receivers.History(); calls != nil {
+            if c, ok := calls.Get(id); ok {
+                response["call"] = c
+                found = true
+            }
+        }
+        if rec, ok := receivers.Recordings().Get(id); ok {
+            response["recording"] = rec
+            response["audio_url"] = "/api/calls/" + url.PathEscape(id) + "/audio"
+            found = true
+        }
+        if !found {
+            http.Error(w, "Call not found", http.StatusNotFound)
+            return
+        }
+        
+        w.Header().Set("Content-Type", "application/json")
+        _ = json.NewEncoder(w).Encode(response)
+    })
+
+    // Stored call audio; Range requests let the app seek and resume
+    http.HandleFunc("/api/calls/{call}/audio", func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Access-Control-Allow-Origin", "*")
+        w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, OPTIONS")
+        w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Range")
+        w.Header().Set("Access-Control-Expose-Headers", "Content-Length, Content-Range, Accept-Ranges")
+        
+        if r.Method == http.MethodOptions {
+            w.WriteHeader(http.StatusOK)
+            return
+        }
+        
+        if r.Method != http.MethodGet && r.Method != http.MethodHead {
+            http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+            return
+        }
+        
+        library := receivers.Recordings()
+        rec, ok := library.Get(r.PathValue("call"))
+        if !ok {
+            http.Error(w, "No recording for this call", http.StatusNotFound)
+            return
+        }
+        f, err := library.Open(rec)
+        if err != nil {
+            http.Error(w, "Recording unavailable", http.StatusNotFound)
+            return
+        }
+        defer f.Close()
+        
+        w.Header().Set("Content-Type", "audio/wav")
+        http.ServeContent(w, r, filepath.Base(rec.File), rec.Modified, f)
     })
 
     // Trunk file read endpoint
@@ -474,7 +1658,7 @@ func main() {
     })
 
     // System file upload endpoint
-    http.HandleFunc("/api/system/upload", func(w http.ResponseWriter, r *http.Request) {
+    handleReceiver(receivers, "/api/system/upload", func(w http.ResponseWriter, r *http.Request, rx *receiver.Receiver) {
         log.Printf("=== /api/system/upload called ===")
         log.Printf("Method: %s", r.Method)
         log.Printf("Remote Address: %s", r.RemoteAddr)
@@ -597,12 +1781,12 @@ func main() {
         
         log.Println("Updating config.ini...")
         // Update config.ini to use this trunk file
-        cfg.TrunkFile = filepath.Join("systems", systemID, trunkHeader.Filename)
+        rx.Config.TrunkFile = filepath.Join("systems", systemID, trunkHeader.Filename)
         err = config.SaveConfig(configPath, cfg)
         if err != nil {
             log.Printf("Warning: Failed to update config.ini: %v", err)
         } else {
-            log.Printf("Updated config.ini trunk_file to: %s", cfg.TrunkFile)
+            log.Printf("Updated config.ini [%s] trunk_file to: %s", rx.Config.Section, rx.Config.TrunkFile)
         }
         
         log.Println("Upload successful! Sending response...")
@@ -805,7 +1989,7 @@ func main() {
     })
 
     // OP25 Config read endpoint
-    http.HandleFunc("/api/op25/config", func(w http.ResponseWriter, r *http.Request) {
+    handleReceiver(receivers, "/api/op25/config", func(w http.ResponseWriter, r *http.Request, rx *receiver.Receiver) {
         w.Header().Set("Access-Control-Allow-Origin", "*")
         w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
         w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
@@ -818,10 +2002,11 @@ func main() {
         if r.Method == http.MethodGet {
             // Return current config
             _ = json.NewEncoder(w).Encode(Op25ConfigResponse{
-                SdrDevice:  cfg.SdrDevice,
-                SampleRate: cfg.SampleRate,
-                LnaGain:    cfg.LnaGain,
-                TrunkFile:  cfg.TrunkFile,
+                SdrDevice:   rx.Config.SdrDevice,
+                DeviceIndex: rx.Config.DeviceIndex,
+                SampleRate:  rx.Config.SampleRate,
+                LnaGain:     rx.Config.LnaGain,
+                TrunkFile:   rx.Config.TrunkFile,
             })
         } else if r.Method == http.MethodPost {
             // Update config
@@ -839,19 +2024,23 @@ func main() {
                     _ = json.NewEncoder(w).Encode(Op25ConfigResponse{Error: "Invalid SDR device. Must be rtl, rtl_tcp, or hackrf"})
                     return
                 }
-                cfg.SdrDevice = req.SdrDevice
+                rx.Config.SdrDevice = req.SdrDevice
+            }
+            
+            if req.DeviceIndex != nil {
+                rx.Config.DeviceIndex = *req.DeviceIndex
             }
             
             if req.SampleRate != "" {
-                cfg.SampleRate = req.SampleRate
+                rx.Config.SampleRate = req.SampleRate
             }
             
             if req.LnaGain != "" {
-                cfg.LnaGain = req.LnaGain
+                rx.Config.LnaGain = req.LnaGain
             }
             
             if req.TrunkFile != "" {
-                cfg.TrunkFile = req.TrunkFile
+                rx.Config.TrunkFile = req.TrunkFile
             }
             
             // Save to file (use absolute path since we changed working directory)
@@ -860,13 +2049,14 @@ func main() {
                 return
             }
             
-            log.Printf("OP25 config updated - Device: %s, Sample Rate: %s, LNA Gain: %s, Trunk File: %s", cfg.SdrDevice, cfg.SampleRate, cfg.LnaGain, cfg.TrunkFile)
+            log.Printf("[%s] OP25 config updated - Device: %s, Index: %s, Sample Rate: %s, LNA Gain: %s, Trunk File: %s", rx.ID, rx.Config.SdrDevice, rx.Config.DeviceIndex, rx.Config.SampleRate, rx.Config.LnaGain, rx.Config.TrunkFile)
             
             _ = json.NewEncoder(w).Encode(Op25ConfigResponse{
-                SdrDevice:  cfg.SdrDevice,
-                SampleRate: cfg.SampleRate,
-                LnaGain:    cfg.LnaGain,
-                TrunkFile:  cfg.TrunkFile,
+                SdrDevice:   rx.Config.SdrDevice,
+                DeviceIndex: rx.Config.DeviceIndex,
+                SampleRate:  rx.Config.SampleRate,
+                LnaGain:     rx.Config.LnaGain,
+                TrunkFile:   rx.Config.TrunkFile,
             })
         } else {
             http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -935,7 +2125,7 @@ func main() {
     })
 
     // Get talkgroups for current system endpoint
-    http.HandleFunc("/api/talkgroups/list", func(w http.ResponseWriter, r *http.Request) {
+    handleReceiver(receivers, "/api/talkgroups/list", func(w http.ResponseWriter, r *http.Request, rx *receiver.Receiver) {
         w.Header().Set("Access-Control-Allow-Origin", "*")
         w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
         w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
@@ -956,7 +2146,7 @@ func main() {
         }
         
         // Get trunk file from config
-        trunkFile := cfg.TrunkFile
+        trunkFile := rx.Config.TrunkFile
         if trunkFile == "" {
             _ = json.NewEncoder(w).Encode(map[string]interface{}{
                 "success":    false,
@@ -1019,7 +2209,7 @@ func main() {
     })
 
     // Get talkgroup metadata (categories, tags, etc.)
-    http.HandleFunc("/api/talkgroups/metadata", func(w http.ResponseWriter, r *http.Request) {
+    handleReceiver(receivers, "/api/talkgroups/metadata", func(w http.ResponseWriter, r *http.Request, rx *receiver.Receiver) {
         w.Header().Set("Access-Control-Allow-Origin", "*")
         w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
         w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
@@ -1035,7 +2225,7 @@ func main() {
         }
         
         // Get system ID from current trunk file
-        trunkFile := cfg.TrunkFile
+        trunkFile := rx.Config.TrunkFile
         if trunkFile == "" {
             _ = json.NewEncoder(w).Encode(map[string]interface{}{
                 "success":  false,
@@ -1088,7 +2278,7 @@ func main() {
     })
 
     // Get whitelist/blacklist for current system
-    http.HandleFunc("/api/talkgroups/lists", func(w http.ResponseWriter, r *http.Request) {
+    handleReceiver(receivers, "/api/talkgroups/lists", func(w http.ResponseWriter, r *http.Request, rx *receiver.Receiver) {
         w.Header().Set("Access-Control-Allow-Origin", "*")
         w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
         w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
@@ -1104,7 +2294,7 @@ func main() {
         }
         
         // Get system ID from current trunk file
-        trunkFile := cfg.TrunkFile
+        trunkFile := rx.Config.TrunkFile
         if trunkFile == "" {
             _ = json.NewEncoder(w).Encode(map[string]interface{}{
                 "success":   false,
@@ -1161,7 +2351,7 @@ func main() {
     })
 
     // Update whitelist/blacklist for current system
-    http.HandleFunc("/api/talkgroups/update-lists", func(w http.ResponseWriter, r *http.Request) {
+    handleReceiver(receivers, "/api/talkgroups/update-lists", func(w http.ResponseWriter, r *http.Request, rx *receiver.Receiver) {
         w.Header().Set("Access-Control-Allow-Origin", "*")
         w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
         w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
@@ -1177,7 +2367,7 @@ func main() {
         }
         
         // Get system ID from current trunk file
-        trunkFile := cfg.TrunkFile
+        trunkFile := rx.Config.TrunkFile
         if trunkFile == "" {
             _ = json.NewEncoder(w).Encode(map[string]interface{}{
                 "success": false,
@@ -1238,44 +2428,12 @@ func main() {
                    systemID, len(req.Whitelist), len(req.Blacklist))
         
         // Restart OP25 to apply changes if it's currently running
-        op25.mu.Lock()
-        wasRunning := op25.running
-        op25.mu.Unlock()
+        wasRunning := rx.Running()
         
         if wasRunning {
-            log.Println("Restarting OP25 to apply talkgroup list changes...")
-            
-            // Clean up any existing OP25 processes first
-            killExistingOP25Processes()
-            
-            op25.mu.Lock()
-            
-            // Stop current instance
-            if op25.running {
-                stopOp25(&audioBroadcaster, &logBroadcaster)
-                // Give time for UDP port to be fully released
-                time.Sleep(500 * time.Millisecond)
-            }
-            
-            // Build flags from config
-            flags := config.BuildOP25Flags(cfg)
-            log.Printf("Restarting OP25 with flags: %v", flags)
-            
-            // Start audio broadcaster BEFORE OP25 to ensure UDP listener is ready
-            audioBroadcaster = audio.NewBroadcaster("127.0.0.1:23456")
-            audioBroadcaster.SetTalkgroupGetter(tgParser)
-            go audioBroadcaster.Start()
+            log.Printf("[%s] Restarting OP25 to apply talkgroup list changes...", rx.ID)
             
-            // Give audio broadcaster time to bind to UDP port
-            time.Sleep(100 * time.Millisecond)
-            
-            // Start OP25 with config-based flags
-            op25Cmd, stdoutPipe, stderrPipe, err := config.StartOp25ProcessUDPWithFlags(flags)
-            if err != nil {
-                // Clean up audio broadcaster if OP25 fails to start
-                audioBroadcaster.Shutdown()
-                audioBroadcaster = nil
-                op25.mu.Unlock()
+            if err := rx.Start("restarting to apply talkgroup list changes"); err != nil {
                 _ = json.NewEncoder(w).Encode(map[string]interface{}{
                     "success": false,
                     "error":   fmt.Sprintf("Failed to restart OP25: %v", err),
@@ -1283,20 +2441,7 @@ func main() {
                 return
             }
             
-            op25.cmdObj = op25Cmd
-            op25.stdoutPipe = stdoutPipe
-            op25.stderrPipe = stderrPipe
-            op25.running = true
-            op25.flags = flags
-            
-            // Start log broadcaster
-            logBroadcaster = logstream.NewBroadcaster(stdoutPipe, stderrPipe)
-            logBroadcaster.SetParser(tgParser)
-            go logBroadcaster.Start()
-            
-            op25.mu.Unlock()
-            
-            log.Println("OP25 restarted successfully with new talkgroup lists")
+            log.Printf("[%s] OP25 restarted successfully with new talkgroup lists", rx.ID)
         }
         
         _ = json.NewEncoder(w).Encode(map[string]interface{}{
@@ -1320,10 +2465,19 @@ func main() {
         // Shutdown mDNS
         close(mdnsShutdown)
 
-        // Shutdown audio broadcaster and OP25 process
-        op25.mu.Lock()
-        stopOp25(&audioBroadcaster, &logBroadcaster)
-        op25.mu.Unlock()
+        // Stop feeding audio out, then the broadcasters and OP25 processes
+        rtpMu.Lock()
+        for _, sender := range rtpSenders {
+            sender.Close()
+        }
+        rtpMu.Unlock()
+        for _, ice := range icecastSources {
+            ice.Close()
+        }
+        receivers.StopAll("controller shutting down")
+
+        // Calls cut short are logged and queued for upload by now
+        receivers.Close()
 
         close(done)
     }()
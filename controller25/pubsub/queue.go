@@ -0,0 +1,109 @@
+// Package pubsub delivers events to subscribers that must see every one,
+// such as the recorder and the call log, and to SSE clients, which see
+// every one or are cut off.
+package pubsub
+
+import "sync"
+
+// Queue hands values to a reader in order without ever blocking the
+// writer: values the reader hasn't taken yet wait in memory. Events come
+// a few a second at most, so a reader that falls behind catches up rather
+// than losing any.
+type Queue[T any] struct {
+	out chan T
+
+	mu      sync.Mutex
+	pending []T
+	closed  bool
+	max     int // values allowed to wait, 0 for no limit
+
+	wake chan struct{}
+	done chan struct{}
+}
+
+// NewQueue starts a queue. Call Close when the reader is done with it.
+func NewQueue[T any]() *Queue[T] {
+	q := &Queue[T]{
+		out:  make(chan T),
+		wake: make(chan struct{}, 1),
+		done: make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+// NewBoundedQueue is NewQueue for a reader that may stop reading for good,
+// such as an SSE client on a dead connection. Once more than max values
+// are waiting the queue gives up on the reader: it closes as if by Close.
+func NewBoundedQueue[T any](max int) *Queue[T] {
+	q := NewQueue[T]()
+	q.max = max
+	return q
+}
+
+// C is the channel the reader takes values from. It is closed by Close.
+func (q *Queue[T]) C() <-chan T {
+	return q.out
+}
+
+// Done is closed once the queue is, by Close or by falling too far behind.
+func (q *Queue[T]) Done() <-chan struct{} {
+	return q.done
+}
+
+// Push queues a value. It never blocks; after Close it does nothing.
+func (q *Queue[T]) Push(v T) {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return
+	}
+	if q.max > 0 && len(q.pending) >= q.max {
+		q.closeLocked()
+		q.mu.Unlock()
+		return
+	}
+	q.pending = append(q.pending, v)
+	q.mu.Unlock()
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Close drops whatever the reader hasn't taken and closes C.
+func (q *Queue[T]) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closeLocked()
+}
+
+func (q *Queue[T]) closeLocked() {
+	if !q.closed {
+		q.closed = true
+		q.pending = nil
+		close(q.done)
+	}
+}
+
+func (q *Queue[T]) run() {
+	defer close(q.out)
+	for {
+		q.mu.Lock()
+		batch := q.pending
+		q.pending = nil
+		q.mu.Unlock()
+		for _, v := range batch {
+			select {
+			case q.out <- v:
+			case <-q.done:
+				return
+			}
+		}
+		select {
+		case <-q.wake:
+		case <-q.done:
+			return
+		}
+	}
+}
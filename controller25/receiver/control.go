@@ -0,0 +1,113 @@
+package receiver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+var (
+	ErrNotRunning        = errors.New("OP25 not running")
+	ErrNoActiveTalkgroup = errors.New("no active talkgroup to hold")
+)
+
+// HoldState is the scanner hold as last set through the controller. OP25
+// doesn't report its hold mode, so this is what we told it.
+type HoldState struct {
+	Active bool       `json:"active"`
+	Tgid   int        `json:"tgid,omitempty"`
+	Since  *time.Time `json:"since,omitempty"`
+}
+
+// HoldState returns the current hold.
+func (r *Receiver) HoldState() HoldState {
+	r.ctl.Lock()
+	defer r.ctl.Unlock()
+	return r.hold
+}
+
+// Hold locks OP25 onto tgid without restarting it.
+func (r *Receiver) Hold(ctx context.Context, tgid int) (HoldState, error) {
+	r.cmd.Lock()
+	defer r.cmd.Unlock()
+	if !r.sup.Status().Running {
+		return r.HoldState(), ErrNotRunning
+	}
+	now := time.Now()
+	hold := HoldState{Active: true, Tgid: tgid, Since: &now}
+	prev := r.setHold(hold)
+	if err := r.term.Hold(ctx, tgid); err != nil {
+		return r.undoHold(hold, prev), err
+	}
+	log.Printf("[%s] Holding on talkgroup %d", r.ID, tgid)
+	return hold, nil
+}
+
+// HoldCurrent holds the talkgroup that is playing right now.
+func (r *Receiver) HoldCurrent(ctx context.Context) (HoldState, error) {
+	tg := r.Talkgroups.GetActiveTalkgroupData()
+	if tg == nil || tg.Tgid == 0 {
+		return r.HoldState(), ErrNoActiveTalkgroup
+	}
+	return r.Hold(ctx, tg.Tgid)
+}
+
+// Release drops the hold and lets OP25 scan again.
+func (r *Receiver) Release(ctx context.Context) error {
+	r.cmd.Lock()
+	defer r.cmd.Unlock()
+	prev := r.setHold(HoldState{})
+	if !prev.Active {
+		return nil
+	}
+	if r.sup.Status().Running {
+		// hold with tgid 0 toggles the hold off
+		if err := r.term.Hold(ctx, 0); err != nil {
+			r.undoHold(HoldState{}, prev)
+			return fmt.Errorf("failed to release hold: %v", err)
+		}
+	}
+	log.Printf("[%s] Released hold on talkgroup %d", r.ID, prev.Tgid)
+	return nil
+}
+
+// setHold records a hold about to be sent to OP25 and returns the one it
+// replaces. The terminal call is made after, outside r.ctl, so HoldState
+// never waits on it. Caller must hold r.cmd.
+func (r *Receiver) setHold(hold HoldState) HoldState {
+	r.ctl.Lock()
+	defer r.ctl.Unlock()
+	prev := r.hold
+	r.hold = hold
+	return prev
+}
+
+// undoHold puts prev back after OP25 refused hold, unless the hold has
+// been reset meanwhile by OP25 restarting. It returns the hold now in
+// place.
+func (r *Receiver) undoHold(hold, prev HoldState) HoldState {
+	r.ctl.Lock()
+	defer r.ctl.Unlock()
+	if r.hold == hold {
+		r.hold = prev
+	}
+	return r.hold
+}
+
+// Skip ends the current call and resumes scanning. While held, OP25 goes
+// straight back to the held talkgroup on its next grant.
+func (r *Receiver) Skip(ctx context.Context) error {
+	if !r.sup.Status().Running {
+		return ErrNotRunning
+	}
+	return r.term.Skip(ctx)
+}
+
+// resetControl forgets control state that a fresh OP25 process won't have.
+func (r *Receiver) resetControl() {
+	r.ctl.Lock()
+	r.hold = HoldState{}
+	r.ctl.Unlock()
+}
@@ -0,0 +1,287 @@
+package receiver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"controller25/terminal"
+)
+
+var (
+	ErrNoSystem     = errors.New("no system configured")
+	ErrNotLockedOut = errors.New("talkgroup is not locked out")
+)
+
+// Lockout is a talkgroup OP25 is told to ignore. Permanent lockouts live
+// in the system's blacklist file; timed ones only in memory.
+type Lockout struct {
+	Tgid      int        `json:"tgid"`
+	Permanent bool       `json:"permanent"`
+	Since     *time.Time `json:"since,omitempty"`
+	Until     *time.Time `json:"until,omitempty"`
+}
+
+// BlacklistFile returns systems/<id>/<id>_blacklist.tsv for the receiver's
+// current system.
+func (r *Receiver) BlacklistFile() (string, error) {
+	systemID := r.Config.SystemID()
+	if systemID == "" {
+		return "", ErrNoSystem
+	}
+	return filepath.Join("systems", systemID, systemID+"_blacklist.tsv"), nil
+}
+
+// Lockouts returns permanent and active timed lockouts, by tgid.
+func (r *Receiver) Lockouts() []Lockout {
+	r.ctl.Lock()
+	defer r.ctl.Unlock()
+	list := []Lockout{}
+	seen := map[int]bool{}
+	if path, err := r.BlacklistFile(); err == nil {
+		for _, tgid := range readBlacklist(path) {
+			if !seen[tgid] {
+				seen[tgid] = true
+				list = append(list, Lockout{Tgid: tgid, Permanent: true})
+			}
+		}
+	}
+	for _, l := range r.lockouts {
+		if !seen[l.Tgid] {
+			list = append(list, *l)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Tgid < list[j].Tgid })
+	return list
+}
+
+// Lockout mutes tgid on the live decoder. A zero duration locks it out for
+// good and adds it to the blacklist file; otherwise it expires after d.
+func (r *Receiver) Lockout(ctx context.Context, tgid int, d time.Duration) (Lockout, error) {
+	r.cmd.Lock()
+	defer r.cmd.Unlock()
+
+	now := time.Now()
+	l := Lockout{Tgid: tgid, Since: &now}
+	r.ctl.Lock()
+	if d > 0 {
+		until := now.Add(d)
+		l.Until = &until
+		r.lockouts[tgid] = &l
+	} else {
+		path, err := r.BlacklistFile()
+		if err == nil {
+			err = addToBlacklist(path, tgid)
+		}
+		if err != nil {
+			r.ctl.Unlock()
+			return l, err
+		}
+		l.Permanent = true
+		l.Since = nil
+		// The file covers it from now on
+		delete(r.lockouts, tgid)
+	}
+	r.ctl.Unlock()
+	log.Printf("[%s] Locked out talkgroup %d %s", r.ID, tgid, describeLockout(l))
+
+	// Not running is fine: the blacklist file or applyLockouts covers the
+	// next start, as it does if the terminal can't be reached now
+	if r.sup.Status().Running {
+		if err := r.term.Lockout(ctx, tgid); err != nil {
+			log.Printf("[%s] Warning: lockout of talkgroup %d not applied until OP25 restarts: %v", r.ID, tgid, err)
+		}
+	}
+	return l, nil
+}
+
+// Unlock removes a timed or permanent lockout.
+func (r *Receiver) Unlock(ctx context.Context, tgid int) error {
+	r.cmd.Lock()
+	defer r.cmd.Unlock()
+
+	if err := r.unlock(tgid); err != nil {
+		return err
+	}
+	log.Printf("[%s] Unlocked talkgroup %d", r.ID, tgid)
+	if err := r.reload(ctx); err != nil {
+		log.Printf("[%s] Warning: talkgroup %d stays locked out until OP25 restarts: %v", r.ID, tgid, err)
+	}
+	return nil
+}
+
+func (r *Receiver) unlock(tgid int) error {
+	r.ctl.Lock()
+	defer r.ctl.Unlock()
+	if _, ok := r.lockouts[tgid]; ok {
+		delete(r.lockouts, tgid)
+		return nil
+	}
+	path, err := r.BlacklistFile()
+	if err != nil {
+		return err
+	}
+	removed, err := removeFromBlacklist(path, tgid)
+	if err != nil {
+		return err
+	}
+	if !removed {
+		return ErrNotLockedOut
+	}
+	return nil
+}
+
+// expireLockouts drops timed lockouts whose time is up.
+func (r *Receiver) expireLockouts() {
+	r.cmd.Lock()
+	defer r.cmd.Unlock()
+	now := time.Now()
+	expired := false
+	r.ctl.Lock()
+	for tgid, l := range r.lockouts {
+		if l.Until != nil && now.After(*l.Until) {
+			log.Printf("[%s] Lockout of talkgroup %d expired", r.ID, tgid)
+			delete(r.lockouts, tgid)
+			expired = true
+		}
+	}
+	r.ctl.Unlock()
+	if expired {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := r.reload(ctx); err != nil {
+			log.Printf("[%s] Warning: failed to lift expired lockout: %v", r.ID, err)
+		}
+	}
+}
+
+// reload makes OP25 re-read its blacklist, which also forgets every
+// lockout sent over the terminal, then re-sends the timed ones still
+// active. OP25 has no way to lift a single lockout. Caller must hold r.cmd
+// but not r.ctl.
+func (r *Receiver) reload(ctx context.Context) error {
+	if !r.sup.Status().Running {
+		return nil
+	}
+	cmds := []terminal.Command{{Command: terminal.CmdReload}}
+	r.ctl.Lock()
+	for tgid := range r.lockouts {
+		cmds = append(cmds, terminal.Command{Command: terminal.CmdLockout, Arg1: int64(tgid)})
+	}
+	r.ctl.Unlock()
+	_, err := r.term.Send(ctx, cmds...)
+	return err
+}
+
+// applyLockouts sends the timed lockouts to a freshly started OP25. Its
+// terminal comes up a little after the process, so this retries briefly.
+func (r *Receiver) applyLockouts() {
+	for attempt := 0; attempt < 10; attempt++ {
+		time.Sleep(time.Second)
+		r.cmd.Lock()
+		r.ctl.Lock()
+		var cmds []terminal.Command
+		for tgid := range r.lockouts {
+			cmds = append(cmds, terminal.Command{Command: terminal.CmdLockout, Arg1: int64(tgid)})
+		}
+		r.ctl.Unlock()
+		if len(cmds) == 0 {
+			r.cmd.Unlock()
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		_, err := r.term.Send(ctx, cmds...)
+		cancel()
+		r.cmd.Unlock()
+		if err == nil {
+			log.Printf("[%s] Re-applied %d timed lockout(s)", r.ID, len(cmds))
+			return
+		}
+	}
+	log.Printf("[%s] Warning: OP25 terminal not reachable, timed lockouts not re-applied", r.ID)
+}
+
+func describeLockout(l Lockout) string {
+	if l.Permanent {
+		return "permanently"
+	}
+	return "until " + l.Until.Format("15:04:05")
+}
+
+// readBlacklist returns the single talkgroups listed in a blacklist file.
+// Range entries ("100\t200") are left to OP25.
+func readBlacklist(path string) []int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var tgids []int
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 1 {
+			continue
+		}
+		if tgid, err := strconv.Atoi(fields[0]); err == nil {
+			tgids = append(tgids, tgid)
+		}
+	}
+	return tgids
+}
+
+func addToBlacklist(path string, tgid int) error {
+	for _, existing := range readBlacklist(path) {
+		if existing == tgid {
+			return nil
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	content := strings.TrimRight(string(data), "\n")
+	if content != "" {
+		content += "\n"
+	}
+	content += strconv.Itoa(tgid)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		return fmt.Errorf("failed to write blacklist: %v", err)
+	}
+	return nil
+}
+
+func removeFromBlacklist(path string, tgid int) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	removed := false
+	var kept []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if fields := strings.Fields(line); len(fields) == 1 && fields[0] == strconv.Itoa(tgid) {
+			removed = true
+			continue
+		}
+		kept = append(kept, line)
+	}
+	if !removed {
+		return false, nil
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(kept, "\n")), 0644); err != nil {
+		return false, fmt.Errorf("failed to write blacklist: %v", err)
+	}
+	return true, nil
+}
@@ -0,0 +1,144 @@
+package receiver
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"controller25/audio"
+	"controller25/config"
+	"controller25/history"
+	"controller25/recorder"
+	"controller25/supervisor"
+	"controller25/talkgroup"
+	"controller25/uploader"
+)
+
+// Manager owns every configured receiver.
+type Manager struct {
+	list    []*Receiver
+	byID    map[string]*Receiver
+	history *history.Store
+	library *recorder.Library
+	dsp     *audio.DSPSettings
+	uploads *uploader.Uploader
+}
+
+func NewManager(cfg *config.Config, launcher config.Launcher, registry *supervisor.Registry) *Manager {
+	opts := supervisor.DefaultOptions()
+	opts.MaxRestarts = cfg.MaxRestarts
+	opts.RestartWindow = cfg.RestartWindow
+
+	calls, err := history.Open(cfg.HistoryFile)
+	if err != nil {
+		log.Printf("Warning: call history disabled: %v", err)
+	}
+
+	var uploads *uploader.Uploader
+	if len(cfg.Uploads) > 0 {
+		if uploads, err = uploader.New(cfg.UploadQueue, cfg.Uploads); err != nil {
+			log.Printf("Warning: call uploads disabled: %v", err)
+		} else {
+			go uploads.Run()
+		}
+		if !cfg.Recording.Enabled {
+			log.Printf("Warning: calls are only uploaded once recorded; enable [recording]")
+		}
+	}
+
+	shared := Options{
+		Supervisor: opts,
+		HangTime:   cfg.CallHangTime,
+		Recording:  cfg.Recording,
+		Directory:  talkgroup.NewDirectory(),
+		History:    calls,
+		Replay:     cfg.ReplayDuration,
+		DSP:        audio.NewDSPSettings(cfg.DSP, cfg.TalkgroupGains),
+		Uploads:    uploads,
+	}
+
+	m := &Manager{byID: make(map[string]*Receiver), history: calls, library: recorder.NewLibrary(), dsp: shared.DSP, uploads: uploads}
+	for _, rc := range cfg.Receivers {
+		r := New(rc, launcher, registry, shared)
+		m.list = append(m.list, r)
+		m.byID[r.ID] = r
+	}
+
+	// Clean up expired talkgroups and lockouts
+	go func() {
+		ticker := time.NewTicker(2 * time.Second)
+		defer ticker.Stop()
+		for range ticker.C {
+			for _, r := range m.list {
+				r.Parser.ClearExpired()
+				r.expireLockouts()
+			}
+		}
+	}()
+	return m
+}
+
+// History returns the call log shared by all receivers, or nil if it
+// couldn't be opened.
+func (m *Manager) History() *history.Store {
+	return m.history
+}
+
+// Recordings returns the index of WAV files under the recordings
+// directories.
+func (m *Manager) Recordings() *recorder.Library {
+	return m.library
+}
+
+// DSP returns the audio processing settings shared by all receivers.
+func (m *Manager) DSP() *audio.DSPSettings {
+	return m.dsp
+}
+
+// Uploads returns the call uploader shared by all receivers, or nil when
+// no upload targets are configured.
+func (m *Manager) Uploads() *uploader.Uploader {
+	return m.uploads
+}
+
+// Get returns the receiver with the given ID, or nil.
+func (m *Manager) Get(id string) *Receiver {
+	return m.byID[id]
+}
+
+// Default returns the receiver configured in the [op25] section, which
+// the un-namespaced legacy routes act on.
+func (m *Manager) Default() *Receiver {
+	return m.list[0]
+}
+
+// All returns the receivers in config file order.
+func (m *Manager) All() []*Receiver {
+	return m.list
+}
+
+// Close stops the uploader and closes the call log. Call it after StopAll,
+// once nothing is recorded or logged any more.
+func (m *Manager) Close() {
+	if m.uploads != nil {
+		m.uploads.Close()
+	}
+	if m.history != nil {
+		if err := m.history.Close(); err != nil {
+			log.Printf("Warning: failed to close call history: %v", err)
+		}
+	}
+}
+
+// StopAll stops every running receiver in parallel.
+func (m *Manager) StopAll(reason string) {
+	var wg sync.WaitGroup
+	for _, r := range m.list {
+		wg.Add(1)
+		go func(r *Receiver) {
+			defer wg.Done()
+			r.Stop(reason)
+		}(r)
+	}
+	wg.Wait()
+}
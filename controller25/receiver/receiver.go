@@ -0,0 +1,473 @@
+package receiver
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"controller25/audio"
+	"controller25/config"
+	"controller25/history"
+	logstream "controller25/log"
+	"controller25/recorder"
+	"controller25/replay"
+	"controller25/supervisor"
+	"controller25/talkgroup"
+	"controller25/terminal"
+	"controller25/uploader"
+)
+
+// pollInterval is how often the OP25 terminal is asked for an update.
+const pollInterval = time.Second
+
+// callCheckInterval is how often the calls in progress are checked for
+// starts, changes and ends.
+const callCheckInterval = 250 * time.Millisecond
+
+// Receiver is one OP25 instance with its own SDR, trunk file, UDP audio
+// port, terminal port, talkgroup parser and broadcasters.
+type Receiver struct {
+	ID     string
+	Config *config.ReceiverConfig
+	Parser *talkgroup.Parser // regex fallback fed from the OP25 log
+
+	// Tracker holds state polled from the OP25 terminal; Talkgroups
+	// prefers it and falls back to Parser
+	Tracker    *talkgroup.Tracker
+	Talkgroups *talkgroup.Source
+
+	// Calls emits call start/update/end events from Talkgroups
+	Calls *talkgroup.CallDetector
+
+	// Directory names and categorizes talkgroups from the system's files
+	Directory *talkgroup.Directory
+	recording config.RecordingConfig
+	dsp       *audio.DSPSettings
+	uploads   *uploader.Uploader
+
+	// History is the call log shared by all receivers, or nil
+	History *history.Store
+
+	// Replay holds the last few minutes of audio, or is nil when disabled.
+	// It outlives OP25 restarts
+	Replay *replay.Buffer
+
+	sup  *supervisor.Supervisor
+	term *terminal.Client
+
+	// cmd keeps control commands to OP25 in order; ctl, taken after it,
+	// only for the state itself, so readers never wait on the terminal
+	cmd      sync.Mutex
+	ctl      sync.Mutex // guards live control state
+	hold     HoldState
+	lockouts map[int]*Lockout // timed lockouts, by tgid
+
+	// logged is signalled each time logCalls writes a call, lastLogged
+	logMu      sync.Mutex
+	logged     *sync.Cond
+	lastLogged string
+
+	lifecycle  sync.Mutex // serializes start/stop sequences
+	running    bool       // started via the API and not stopped since
+	stopPoller context.CancelFunc
+	callsDone  chan struct{} // closed when watchCalls returns
+
+	mu       sync.RWMutex // guards the broadcasters, read by HTTP handlers
+	audio    *audio.Broadcaster
+	logs     *logstream.Broadcaster
+	recorder *recorder.Recorder
+}
+
+// Options are the settings shared by every receiver.
+type Options struct {
+	Supervisor supervisor.Options
+	HangTime   time.Duration // how long a talkgroup may go quiet mid-call
+	Recording  config.RecordingConfig
+	Directory  *talkgroup.Directory
+	History    *history.Store
+	Replay     time.Duration      // how much audio to keep for replay
+	DSP        *audio.DSPSettings // processing applied to the live audio, or nil
+	Uploads    *uploader.Uploader // where recorded calls are shared, or nil
+}
+
+func New(rc *config.ReceiverConfig, launcher config.Launcher, registry *supervisor.Registry, opts Options) *Receiver {
+	sup := supervisor.New(launcher, opts.Supervisor)
+	sup.SetRegistry(registry)
+	parser := talkgroup.NewParser()
+	parser.SetHangTime(opts.HangTime)
+	directory := opts.Directory
+	if directory == nil {
+		directory = talkgroup.NewDirectory()
+	}
+	tracker := talkgroup.NewTracker()
+	r := &Receiver{
+		ID:         rc.ID,
+		Config:     rc,
+		Parser:     parser,
+		Tracker:    tracker,
+		Talkgroups: talkgroup.NewSource(tracker, parser),
+		Calls:      talkgroup.NewCallDetector(opts.HangTime),
+		Directory:  directory,
+		recording:  opts.Recording,
+		dsp:        opts.DSP,
+		uploads:    opts.Uploads,
+		History:    opts.History,
+		sup:        sup,
+		term:       terminal.NewClient(fmt.Sprintf("127.0.0.1:%d", rc.TerminalPort)),
+
+		lockouts: make(map[int]*Lockout),
+	}
+	r.logged = sync.NewCond(&r.logMu)
+	go r.watchLifecycle()
+	if r.History != nil {
+		go r.logCalls()
+	}
+	if opts.Replay > 0 {
+		r.Replay = replay.NewBuffer(opts.Replay, 8000, 1)
+		go r.indexReplay()
+	}
+	return r
+}
+
+// watchLifecycle keeps live control state in line with the OP25 process:
+// a hold dies with the process, timed lockouts are re-sent to a new one.
+func (r *Receiver) watchLifecycle() {
+	events, _ := r.sup.Subscribe()
+	for t := range events {
+		switch t.To {
+		case supervisor.StateCrashed:
+			r.resetControl()
+		case supervisor.StateRunning:
+			go r.applyLockouts()
+		}
+	}
+}
+
+// Supervisor exposes lifecycle state and the event stream.
+func (r *Receiver) Supervisor() *supervisor.Supervisor {
+	return r.sup
+}
+
+// Audio returns the audio broadcaster, or nil while OP25 is not started.
+func (r *Receiver) Audio() *audio.Broadcaster {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.audio
+}
+
+// Logs returns the log broadcaster, or nil while OP25 is not started.
+func (r *Receiver) Logs() *logstream.Broadcaster {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.logs
+}
+
+// Running reports whether OP25 was started and not stopped since. The
+// process itself may be between restarts; see Supervisor().Status().
+func (r *Receiver) Running() bool {
+	r.lifecycle.Lock()
+	defer r.lifecycle.Unlock()
+	return r.running
+}
+
+// Start (re)starts OP25 with the receiver's current config. It blocks for
+// the whole kill/sleep/start sequence, so API handlers call it from a
+// goroutine after Supervisor().MarkStarting.
+func (r *Receiver) Start(reason string) error {
+	r.lifecycle.Lock()
+	defer r.lifecycle.Unlock()
+
+	// If already running, shut down and restart
+	if r.running {
+		r.stopLocked(reason)
+		// Give time for UDP port to be fully released
+		time.Sleep(500 * time.Millisecond)
+		r.sup.MarkStarting(reason)
+	}
+
+	// A new process starts out scanning
+	r.resetControl()
+
+	// Build flags from config (includes audio streaming flags)
+	flags := config.BuildOP25Flags(r.Config)
+	log.Printf("[%s] Starting OP25 with flags: %v", r.ID, flags)
+
+	// Start audio broadcaster BEFORE OP25 to ensure UDP listener is ready
+	var addrs []string
+	for _, port := range r.Config.AudioPorts() {
+		addrs = append(addrs, fmt.Sprintf("127.0.0.1:%d", port))
+	}
+	ab := audio.NewMultiBroadcaster(addrs)
+	ab.SetTalkgroupGetter(r.Talkgroups)
+	ab.SetCalls(r.Calls)
+	ab.SetPriority(func(tgid int) int {
+		return r.Directory.Priority(r.Config.SystemID(), tgid)
+	})
+	if r.dsp != nil {
+		ab.SetDSP(r.dsp)
+	}
+	ab.SetAlphaTag(func(tgid int) string {
+		return r.Directory.Lookup(r.Config.SystemID(), tgid).Name
+	})
+	if err := ab.Start(); err != nil {
+		ab.Shutdown()
+		r.sup.MarkFailed(err)
+		return err
+	}
+
+	// The log broadcaster outlives individual OP25 processes so that clients
+	// on /stream see the crash and the restart
+	lb := logstream.NewBroadcaster(nil, nil)
+	lb.SetLabel(r.ID)
+	lb.SetParser(r.Parser)
+
+	if err := r.sup.Start(flags, lb); err != nil {
+		// Clean up audio broadcaster if OP25 fails to start
+		ab.Shutdown()
+		return err
+	}
+
+	if r.Replay != nil {
+		frames, _ := ab.Subscribe(256)
+		go func() {
+			for pcm := range frames {
+				r.Replay.Write(pcm)
+			}
+		}()
+	}
+
+	var rec *recorder.Recorder
+	if r.recording.Enabled {
+		rec = r.newRecorder(ab)
+		go rec.Run()
+	}
+
+	r.mu.Lock()
+	r.audio = ab
+	r.logs = lb
+	r.recorder = rec
+	r.mu.Unlock()
+	r.running = true
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r.stopPoller = cancel
+	r.callsDone = make(chan struct{})
+	go r.poll(ctx)
+	go r.watchCalls(ctx, r.callsDone)
+	return nil
+}
+
+// poll feeds the tracker from the OP25 terminal until ctx is done. While
+// the terminal is unreachable the tracker goes stale and Talkgroups falls
+// back to the log parser.
+func (r *Receiver) poll(ctx context.Context) {
+	reachable := false
+	r.term.Poll(ctx, pollInterval, func(msgs []terminal.Message, err error) {
+		if err != nil {
+			r.Tracker.Fail(err)
+			if reachable {
+				log.Printf("[%s] OP25 terminal unreachable, falling back to log parsing: %v", r.ID, err)
+				reachable = false
+			}
+			return
+		}
+		if !reachable {
+			log.Printf("[%s] Tracking OP25 state from the terminal on port %d", r.ID, r.Config.TerminalPort)
+			reachable = true
+		}
+		r.Tracker.Update(msgs)
+	})
+}
+
+// watchCalls feeds the call detector until ctx is done.
+func (r *Receiver) watchCalls(ctx context.Context, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(callCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.Calls.Observe(r.Talkgroups.Calls(), r.Talkgroups.Playing())
+		}
+	}
+}
+
+// newRecorder sets up a recorder fed from ab and the call detector. It
+// reads from buffered subscriptions, so a slow disk loses frames from
+// recordings, never from the live stream.
+func (r *Receiver) newRecorder(ab *audio.Broadcaster) *recorder.Recorder {
+	frames, unsubFrames := ab.Subscribe(256)
+	events, unsubEvents := r.Calls.Subscribe()
+	opts := recorder.Options{
+		Receiver:   r.ID,
+		Config:     r.recording,
+		SampleRate: ab.SampleRate,
+		Channels:   ab.Channels,
+		Dir:        func() string { return recorder.Dir(r.Config.SystemID()) },
+		Lookup: func(tgid int) talkgroup.Entry {
+			return r.Directory.Lookup(r.Config.SystemID(), tgid)
+		},
+		Site: func() *recorder.Site { return recorder.SiteFrom(r.Config, r.Tracker.State()) },
+	}
+	if r.uploads != nil {
+		opts.Recorded = r.uploads.Add
+	}
+	rec := recorder.New(opts, frames, events)
+	go func() {
+		rec.Wait()
+		unsubFrames()
+		unsubEvents()
+	}()
+	return rec
+}
+
+// indexReplay marks where each call sits in the replay buffer.
+func (r *Receiver) indexReplay() {
+	events, _ := r.Calls.Subscribe()
+	for e := range events {
+		r.Replay.Observe(e)
+	}
+}
+
+// logCalls writes every call that ends to the call log.
+func (r *Receiver) logCalls() {
+	events, _ := r.Calls.Subscribe()
+	// The site is taken when the call starts; by the time the last call
+	// ends on a stop, the tracker has already been reset
+	sites := map[string]*recorder.Site{}
+	for e := range events {
+		c := e.Call
+		switch e.Type {
+		case talkgroup.CallStart:
+			sites[c.ID] = recorder.SiteFrom(r.Config, r.Tracker.State())
+			continue
+		case talkgroup.CallUpdate:
+			continue
+		}
+		site, ok := sites[c.ID]
+		if !ok {
+			site = recorder.SiteFrom(r.Config, r.Tracker.State())
+		}
+		delete(sites, c.ID)
+
+		entry := r.Directory.Lookup(r.Config.SystemID(), c.Tgid)
+		name := entry.Name
+		if name == "" {
+			name = c.Tag
+		}
+		end := c.LastSeen
+		if c.End != nil {
+			end = *c.End
+		}
+		err := r.History.Append(history.Call{
+			ID:        c.ID,
+			Receiver:  r.ID,
+			Tgid:      c.Tgid,
+			Talkgroup: name,
+			Category:  entry.Category,
+			Sources:   c.Sources,
+			Frequency: c.Frequency,
+			Slot:      c.Slot,
+			Emergency: c.Emergency,
+			Encrypted: c.Encrypted,
+			Start:     c.Start,
+			End:       end,
+			Duration:  c.Duration,
+			Site:      site,
+		})
+		if err != nil {
+			log.Printf("[%s] Failed to log call %s: %v", r.ID, c.ID, err)
+		}
+		r.logMu.Lock()
+		r.lastLogged = c.ID
+		r.logMu.Unlock()
+		r.logged.Broadcast()
+	}
+}
+
+// waitLogged waits for logCalls to have written the call with the given
+// ID, and with it every call that ended before.
+func (r *Receiver) waitLogged(id string) {
+	r.logMu.Lock()
+	defer r.logMu.Unlock()
+	for r.lastLogged != id {
+		r.logged.Wait()
+	}
+}
+
+// Stop stops OP25 and the broadcasters. It returns false if OP25 was not
+// running.
+func (r *Receiver) Stop(reason string) bool {
+	r.lifecycle.Lock()
+	defer r.lifecycle.Unlock()
+	if !r.running {
+		return false
+	}
+	r.stopLocked(reason)
+	return true
+}
+
+func (r *Receiver) stopLocked(reason string) {
+	if r.stopPoller != nil {
+		r.stopPoller()
+		r.stopPoller = nil
+		// No more snapshots once the tracker is reset below
+		<-r.callsDone
+	}
+	r.sup.Stop(reason)
+	r.running = false
+	r.resetControl()
+	r.Tracker.Reset()
+	// The calls cut short go to the call log before Stop returns, so
+	// they are there even when the controller is exiting
+	if ended := r.Calls.EndAll(); r.History != nil && len(ended) > 0 {
+		r.waitLogged(ended[len(ended)-1].ID)
+	}
+
+	r.mu.Lock()
+	ab := r.audio
+	rec := r.recorder
+	r.audio = nil
+	r.logs = nil
+	r.recorder = nil
+	r.mu.Unlock()
+	if rec != nil {
+		rec.Stop()
+	}
+	if ab != nil {
+		ab.Shutdown()
+	}
+}
+
+// Info summarizes a receiver for the receiver list.
+type Info struct {
+	ID           string                   `json:"id"`
+	SdrDevice    string                   `json:"sdr_device"`
+	DeviceIndex  string                   `json:"device_index,omitempty"`
+	TrunkFile    string                   `json:"trunk_file"`
+	AudioPort    int                      `json:"audio_port"`
+	AudioStreams int                      `json:"audio_streams"`
+	TerminalPort int                      `json:"terminal_port"`
+	Status       supervisor.Status        `json:"status"`
+	Talkgroup    *talkgroup.TalkgroupInfo `json:"talkgroup"`
+	System       *talkgroup.SystemState   `json:"system"`
+}
+
+func (r *Receiver) Info() Info {
+	return Info{
+		ID:           r.ID,
+		SdrDevice:    r.Config.SdrDevice,
+		DeviceIndex:  r.Config.DeviceIndex,
+		TrunkFile:    r.Config.TrunkFile,
+		AudioPort:    r.Config.AudioPort,
+		AudioStreams: len(r.Config.AudioPorts()),
+		TerminalPort: r.Config.TerminalPort,
+		Status:       r.sup.Status(),
+		Talkgroup:    r.Talkgroups.GetActiveTalkgroupData(),
+		System:       r.Tracker.State(),
+	}
+}
@@ -0,0 +1,274 @@
+package recorder
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rescanInterval limits how often a lookup that misses walks the disk.
+const rescanInterval = 2 * time.Second
+
+// Recording is a WAV file under a recordings directory, written by this
+// controller or dropped there by another tool.
+type Recording struct {
+	ID         string    `json:"id"`
+	File       string    `json:"file"` // relative to the OP25 directory
+	Size       int64     `json:"size"`
+	Modified   time.Time `json:"modified"`
+	Tgid       int       `json:"tgid,omitempty"`
+	Start      time.Time `json:"start"`
+	Duration   float64   `json:"duration"` // seconds, from the WAV header
+	SampleRate int       `json:"sample_rate"`
+	Channels   int       `json:"channels"`
+	Metadata   *Metadata `json:"metadata,omitempty"` // the sidecar, when there is one
+}
+
+// fileName matches the names the recorder uses: <receiver>_<tgid>-<unix
+// ms>, with _<part> and ~<n> suffixes, and the older <tgid>-<unix time>.
+var fileName = regexp.MustCompile(`^(?:.+_)?(\d+)-(\d{9,})(?:_\d+)?(?:~\d+)?$`)
+
+// Library indexes the WAV files under systems/*/recordings/ and
+// recordings/. Recordings are identified by their sidecar's call ID, or
+// by file name when there is no sidecar.
+type Library struct {
+	mu      sync.Mutex
+	files   map[string]*Recording // by path
+	byID    map[string]*Recording
+	scanned time.Time
+}
+
+func NewLibrary() *Library {
+	return &Library{
+		files: make(map[string]*Recording),
+		byID:  make(map[string]*Recording),
+	}
+}
+
+// Get returns the recording with the given ID, rescanning the disk when
+// it isn't known yet.
+func (l *Library) Get(id string) (Recording, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	rec, ok := l.byID[id]
+	if !ok && time.Since(l.scanned) > rescanInterval {
+		l.scanLocked()
+		rec, ok = l.byID[id]
+	}
+	if !ok {
+		return Recording{}, false
+	}
+	return *rec, true
+}
+
+// Between returns the recordings starting in [since, until), oldest
+// first. A zero bound is open.
+func (l *Library) Between(since, until time.Time) []Recording {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.scanLocked()
+	list := []Recording{}
+	for _, rec := range l.byID {
+		if !since.IsZero() && rec.Start.Before(since) {
+			continue
+		}
+		if !until.IsZero() && !rec.Start.Before(until) {
+			continue
+		}
+		list = append(list, *rec)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Start.Before(list[j].Start) })
+	return list
+}
+
+// Open opens a recording's audio.
+func (l *Library) Open(rec Recording) (*os.File, error) {
+	return os.Open(rec.File)
+}
+
+// scanLocked walks the recordings directories, re-reading only files that
+// changed since the last scan.
+func (l *Library) scanLocked() {
+	roots, _ := filepath.Glob(filepath.Join("systems", "*", "recordings"))
+	roots = append(roots, "recordings")
+
+	seen := make(map[string]bool)
+	for _, root := range roots {
+		filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || !strings.EqualFold(filepath.Ext(path), ".wav") {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			seen[path] = true
+			sidecar := modTime(SidecarPath(path))
+			if rec, ok := l.files[path]; ok && rec.Size == info.Size() && rec.Modified.Equal(info.ModTime()) &&
+				(rec.Metadata != nil) == !sidecar.IsZero() {
+				return nil
+			}
+			if rec, err := readRecording(path, info); err == nil {
+				l.files[path] = rec
+			}
+			return nil
+		})
+	}
+	for path := range l.files {
+		if !seen[path] {
+			delete(l.files, path)
+		}
+	}
+
+	l.byID = make(map[string]*Recording, len(l.files))
+	for _, rec := range l.files {
+		// Two foreign files with the same name: keep the older one reachable
+		// under its name, the other under its path
+		if prev, ok := l.byID[rec.ID]; ok && prev.File < rec.File {
+			l.byID[rec.File] = rec
+			continue
+		}
+		l.byID[rec.ID] = rec
+	}
+	l.scanned = time.Now()
+}
+
+func readRecording(path string, info fs.FileInfo) (*Recording, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	format, err := readWAVFormat(f, info.Size())
+	if err != nil {
+		return nil, err
+	}
+
+	base := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	rec := &Recording{
+		ID:         base,
+		File:       path,
+		Size:       info.Size(),
+		Modified:   info.ModTime(),
+		Start:      info.ModTime().Add(-time.Duration(format.duration * float64(time.Second))),
+		Duration:   format.duration,
+		SampleRate: format.sampleRate,
+		Channels:   format.channels,
+	}
+	if m := fileName.FindStringSubmatch(base); m != nil {
+		rec.Tgid, _ = strconv.Atoi(m[1])
+		t, _ := strconv.ParseInt(m[2], 10, 64)
+		rec.Start = time.Unix(t, 0)
+		if len(m[2]) >= 13 {
+			rec.Start = time.UnixMilli(t)
+		}
+	}
+	if data, err := os.ReadFile(SidecarPath(path)); err == nil {
+		var meta Metadata
+		if json.Unmarshal(data, &meta) == nil && meta.ID != "" {
+			rec.ID, rec.Tgid, rec.Start = meta.ID, meta.Tgid, meta.Start
+			rec.Metadata = &meta
+		}
+	}
+	return rec, nil
+}
+
+type wavFormat struct {
+	sampleRate int
+	channels   int
+	duration   float64
+	length     int64 // bytes of audio data
+}
+
+// readWAVFormat walks the RIFF chunks up to the audio data. Files written
+// by streaming tools often leave the data size at 0 or 0xFFFFFFFF; the
+// rest of the file is taken as audio then.
+func readWAVFormat(r io.ReadSeeker, size int64) (wavFormat, error) {
+	var format wavFormat
+	var riff [12]byte
+	if _, err := io.ReadFull(r, riff[:]); err != nil {
+		return format, err
+	}
+	if string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		return format, errors.New("not a WAV file")
+	}
+	byteRate := 0
+	offset := int64(12)
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(r, chunk[:]); err != nil {
+			return format, errors.New("no data chunk")
+		}
+		offset += 8
+		id := string(chunk[0:4])
+		length := int64(binary.LittleEndian.Uint32(chunk[4:8]))
+		switch id {
+		case "fmt ":
+			var fmtChunk [16]byte
+			if length < 16 {
+				return format, errors.New("short fmt chunk")
+			}
+			if _, err := io.ReadFull(r, fmtChunk[:]); err != nil {
+				return format, err
+			}
+			format.channels = int(binary.LittleEndian.Uint16(fmtChunk[2:4]))
+			format.sampleRate = int(binary.LittleEndian.Uint32(fmtChunk[4:8]))
+			byteRate = int(binary.LittleEndian.Uint32(fmtChunk[8:12]))
+			if _, err := r.Seek(length-16+length%2, io.SeekCurrent); err != nil {
+				return format, err
+			}
+		case "data":
+			if byteRate == 0 {
+				return format, errors.New("data before fmt chunk")
+			}
+			if length == 0 || length == 0xFFFFFFFF || offset+length > size {
+				length = size - offset
+			}
+			format.duration = float64(length) / float64(byteRate)
+			format.length = length
+			return format, nil
+		default:
+			if _, err := r.Seek(length+length%2, io.SeekCurrent); err != nil {
+				return format, err
+			}
+		}
+		offset += length + length%2
+	}
+}
+
+// ReadPCM returns the audio data of a WAV file with its sample rate and
+// channel count.
+func ReadPCM(path string) (pcm []byte, sampleRate, channels int, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	format, err := readWAVFormat(f, info.Size())
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	pcm, err = io.ReadAll(io.LimitReader(f, format.length))
+	return pcm, format.sampleRate, format.channels, err
+}
+
+func modTime(path string) time.Time {
+	if info, err := os.Stat(path); err == nil {
+		return info.ModTime()
+	}
+	return time.Time{}
+}
@@ -0,0 +1,383 @@
+package recorder
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"controller25/config"
+	"controller25/talkgroup"
+)
+
+// preroll is how much audio heard before a call is known to be playing
+// goes at the start of its recording. The terminal is polled once a
+// second, so the first words usually arrive before the call event.
+const preroll = 2 * time.Second
+
+// maxNameTries bounds the suffixes tried when a recording's name is taken.
+const maxNameTries = 100
+
+// Metadata is the JSON sidecar written next to each recording.
+type Metadata struct {
+	ID         string    `json:"id"`
+	Part       int       `json:"part,omitempty"` // set from 2 on when a call resumed after another one played
+	Receiver   string    `json:"receiver"`
+	Tgid       int       `json:"tgid"`
+	Talkgroup  string    `json:"talkgroup,omitempty"` // name from the talkgroups file, else OP25's tag
+	Category   string    `json:"category,omitempty"`
+	Sources    []int     `json:"sources"`
+	Frequency  int64     `json:"frequency"`
+	Slot       int       `json:"slot"`
+	Emergency  bool      `json:"emergency"`
+	Encrypted  bool      `json:"encrypted"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	Duration   float64   `json:"duration"` // seconds of audio
+	Site       *Site     `json:"site,omitempty"`
+	Audio      string    `json:"audio"` // WAV file name, next to the sidecar
+	SampleRate int       `json:"sample_rate"`
+}
+
+// Site identifies where a call was heard.
+type Site struct {
+	System   string `json:"system,omitempty"`
+	SystemID string `json:"system_id,omitempty"` // RadioReference system
+	SiteID   string `json:"site_id,omitempty"`   // RadioReference site
+	Nac      int    `json:"nac,omitempty"`
+	Wacn     int    `json:"wacn,omitempty"`
+	Sysid    int    `json:"sysid,omitempty"`
+	Rfss     int    `json:"rfss,omitempty"`
+	Site     int    `json:"site,omitempty"`
+}
+
+// Options wire a Recorder to its receiver.
+type Options struct {
+	Receiver   string
+	Config     config.RecordingConfig
+	SampleRate int
+	Channels   int
+	// Dir returns where recordings for the current system go
+	Dir func() string
+	// Lookup names and categorizes a talkgroup
+	Lookup func(tgid int) talkgroup.Entry
+	// Site describes the site being monitored, or returns nil
+	Site func() *Site
+	// Recorded, when set, is called with each recording once it is
+	// complete on disk
+	Recorded func(path string, m Metadata)
+}
+
+// Recorder cuts the audio stream into one WAV file per call. It works off
+// its own goroutine and buffered channels, so disk I/O never holds up the
+// live broadcast; if the disk can't keep up, frames are dropped from the
+// recording rather than from the stream.
+type Recorder struct {
+	opts   Options
+	frames <-chan []byte
+	events <-chan talkgroup.CallEvent
+	stop   chan struct{}
+	done   chan struct{}
+
+	cur     *recording
+	paused  bool           // cur lost the stream but hasn't ended
+	parts   map[string]int // files written so far for calls still going
+	skip    string         // ID of a playing call that isn't being recorded
+	pending []frame
+}
+
+type recording struct {
+	call string // the call's ID; meta.ID differs for later parts
+	meta Metadata
+	path string
+	wav  *wavWriter
+}
+
+type frame struct {
+	at  time.Time
+	pcm []byte
+}
+
+// New creates a recorder fed by frames and call events. Call Run to start
+// it.
+func New(opts Options, frames <-chan []byte, events <-chan talkgroup.CallEvent) *Recorder {
+	return &Recorder{
+		opts:   opts,
+		frames: frames,
+		events: events,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+		parts:  make(map[string]int),
+	}
+}
+
+// Run records until Stop is called or the frame channel closes.
+func (r *Recorder) Run() {
+	defer close(r.done)
+	defer r.finish(time.Now())
+	for {
+		select {
+		case pcm, ok := <-r.frames:
+			if !ok {
+				return
+			}
+			r.audio(pcm)
+		case e := <-r.events:
+			r.event(e)
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// Wait blocks until Run returns.
+func (r *Recorder) Wait() {
+	<-r.done
+}
+
+// Stop finishes the recording in progress and waits for Run to return.
+func (r *Recorder) Stop() {
+	select {
+	case <-r.stop:
+	default:
+		close(r.stop)
+	}
+	<-r.done
+}
+
+func (r *Recorder) audio(pcm []byte) {
+	if r.cur != nil && !r.paused {
+		if err := r.cur.wav.Write(pcm); err != nil {
+			log.Printf("[%s] Recording %s failed: %v", r.opts.Receiver, r.cur.path, err)
+			r.abort()
+		}
+		return
+	}
+	if r.skip != "" {
+		return
+	}
+	now := time.Now()
+	r.pending = append(r.pending, frame{at: now, pcm: pcm})
+	for len(r.pending) > 0 && now.Sub(r.pending[0].at) > preroll {
+		r.pending = r.pending[1:]
+	}
+}
+
+func (r *Recorder) event(e talkgroup.CallEvent) {
+	c := e.Call
+	switch {
+	case r.cur != nil && r.cur.call == c.ID:
+		r.update(c)
+		// Keep the file open through a pause in case the call gets the
+		// stream back before another one does
+		r.paused = !c.Playing
+		if e.Type == talkgroup.CallEnd {
+			r.finish(time.Now())
+			delete(r.parts, c.ID)
+		}
+	case e.Type == talkgroup.CallEnd:
+		delete(r.parts, c.ID)
+		if r.skip == c.ID {
+			r.skip = ""
+		}
+	case r.skip == c.ID:
+		if !c.Playing {
+			r.skip = ""
+		}
+	case c.Playing:
+		// Another call took over the audio stream
+		r.finish(time.Now())
+		r.skip = ""
+		r.begin(c)
+	}
+}
+
+func (r *Recorder) begin(c talkgroup.CallRecord) {
+	entry := r.opts.Lookup(c.Tgid)
+	if !r.opts.Config.Wants(c.Tgid, entry.Category) {
+		r.skip = c.ID
+		r.pending = nil
+		return
+	}
+	name := entry.Name
+	if name == "" {
+		name = c.Tag
+	}
+	start := c.Start.Local()
+	dir := filepath.Join(r.opts.Dir(), start.Format("2006"), start.Format("01"), start.Format("02"))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Printf("[%s] Failed to create recordings directory: %v", r.opts.Receiver, err)
+		r.skip = c.ID
+		return
+	}
+	id := c.ID
+	// Receivers share a system's recordings directory and may hear the
+	// same call, so the receiver is part of the name
+	base := safeName(r.opts.Receiver) + "_" + c.ID
+	part := r.parts[c.ID] + 1
+	if part > 1 {
+		id = fmt.Sprintf("%s_%d", c.ID, part)
+		base = fmt.Sprintf("%s_%d", base, part)
+	}
+	var path string
+	var wav *wavWriter
+	var err error
+	for n := 1; n <= maxNameTries; n++ {
+		// A name already taken, e.g. by a copied-in file, gets a suffix
+		// rather than being overwritten
+		name := base
+		if n > 1 {
+			name = fmt.Sprintf("%s~%d", base, n)
+		}
+		path = filepath.Join(dir, name+".wav")
+		if wav, err = createWAV(path, r.opts.SampleRate, r.opts.Channels); !errors.Is(err, fs.ErrExist) {
+			base = name
+			break
+		}
+	}
+	if err != nil {
+		log.Printf("[%s] Failed to start recording: %v", r.opts.Receiver, err)
+		r.skip = c.ID
+		return
+	}
+	r.parts[c.ID] = part
+	r.paused = false
+	r.cur = &recording{
+		call: c.ID,
+		path: path,
+		wav:  wav,
+		meta: Metadata{
+			ID:         id,
+			Receiver:   r.opts.Receiver,
+			Tgid:       c.Tgid,
+			Talkgroup:  name,
+			Category:   entry.Category,
+			Start:      c.Start,
+			Site:       r.opts.Site(),
+			Audio:      base + ".wav",
+			SampleRate: r.opts.SampleRate,
+		},
+	}
+	if part > 1 {
+		r.cur.meta.Part = part
+	}
+	r.update(c)
+	for _, f := range r.pending {
+		r.audio(f.pcm)
+	}
+	r.pending = nil
+}
+
+// update copies what the call event knows now into the sidecar.
+func (r *Recorder) update(c talkgroup.CallRecord) {
+	m := &r.cur.meta
+	m.Sources = append([]int{}, c.Sources...)
+	m.Frequency, m.Slot = c.Frequency, c.Slot
+	m.Emergency, m.Encrypted = c.Emergency, c.Encrypted
+	if m.Talkgroup == "" {
+		m.Talkgroup = c.Tag
+	}
+	if c.End != nil {
+		m.End = *c.End
+	}
+}
+
+// finish closes the recording in progress and writes its sidecar, or
+// drops it when it is shorter than min_duration.
+func (r *Recorder) finish(now time.Time) {
+	rec := r.cur
+	if rec == nil {
+		return
+	}
+	r.cur = nil
+	bytesPerSecond := float64(r.opts.SampleRate * r.opts.Channels * 2)
+	rec.meta.Duration = float64(rec.wav.bytes) / bytesPerSecond
+	if rec.meta.Duration < r.opts.Config.MinDuration.Seconds() {
+		rec.wav.Discard()
+		return
+	}
+	if rec.meta.End.IsZero() {
+		rec.meta.End = now
+	}
+	// Sidecar first: a WAV that shows up always has its metadata
+	if err := writeSidecar(SidecarPath(rec.path), rec.meta); err != nil {
+		log.Printf("[%s] Failed to write recording metadata: %v", r.opts.Receiver, err)
+		rec.wav.Discard()
+		return
+	}
+	if err := rec.wav.Close(); err != nil {
+		log.Printf("[%s] Failed to close recording %s: %v", r.opts.Receiver, rec.path, err)
+		os.Remove(SidecarPath(rec.path))
+		return
+	}
+	log.Printf("[%s] Recorded talkgroup %d (%.1fs) to %s", r.opts.Receiver, rec.meta.Tgid, rec.meta.Duration, rec.path)
+	if r.opts.Recorded != nil {
+		r.opts.Recorded(rec.path, rec.meta)
+	}
+}
+
+// abort drops the recording in progress after a write error.
+func (r *Recorder) abort() {
+	rec := r.cur
+	r.cur = nil
+	r.skip = rec.call
+	rec.wav.Discard()
+}
+
+// safeName makes a receiver ID safe to use in a file name.
+func safeName(id string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == os.PathSeparator {
+			return '_'
+		}
+		return r
+	}, id)
+}
+
+// SidecarPath returns the JSON sidecar path for a recording.
+func SidecarPath(wavPath string) string {
+	return wavPath[:len(wavPath)-len(filepath.Ext(wavPath))] + ".json"
+}
+
+// writeSidecar writes the metadata through a temporary file so readers
+// never see half a sidecar.
+func writeSidecar(path string, m Metadata) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Dir returns the recordings directory for a system, or a top-level
+// recordings directory when the trunk file isn't under systems/<id>/.
+func Dir(systemID string) string {
+	if systemID == "" {
+		return "recordings"
+	}
+	return filepath.Join("systems", systemID, "recordings")
+}
+
+// SiteFrom builds a Site from the receiver config and, when known, the
+// system state reported by OP25.
+func SiteFrom(rc *config.ReceiverConfig, state *talkgroup.SystemState) *Site {
+	site := &Site{SystemID: rc.SystemID(), SiteID: rc.SiteID()}
+	if state != nil {
+		site.System = state.Name
+		site.Nac, site.Wacn, site.Sysid = state.Nac, state.Wacn, state.Sysid
+		site.Rfss, site.Site = state.Rfss, state.Site
+	}
+	if *site == (Site{}) {
+		return nil
+	}
+	return site
+}
@@ -0,0 +1,103 @@
+package recorder
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"controller25/config"
+	"controller25/talkgroup"
+)
+
+// record runs a recorder for receiver through one call with seconds of
+// audio and returns the recordings it reported.
+func record(t *testing.T, receiver, dir string, c talkgroup.CallRecord, seconds int) []string {
+	t.Helper()
+	frames := make(chan []byte)
+	events := make(chan talkgroup.CallEvent)
+	var written []string
+	r := New(Options{
+		Receiver:   receiver,
+		Config:     config.RecordingConfig{Enabled: true},
+		SampleRate: 8000,
+		Channels:   1,
+		Dir:        func() string { return dir },
+		Lookup:     func(int) talkgroup.Entry { return talkgroup.Entry{} },
+		Site:       func() *Site { return nil },
+		Recorded:   func(path string, m Metadata) { written = append(written, path) },
+	}, frames, events)
+	go r.Run()
+
+	c.Playing = true
+	events <- talkgroup.CallEvent{Type: talkgroup.CallStart, Time: c.Start, Call: c}
+	for i := 0; i < 10*seconds; i++ {
+		frames <- make([]byte, 1600) // 100 ms
+	}
+	end := c.Start.Add(time.Duration(seconds) * time.Second)
+	c.Playing, c.End = false, &end
+	events <- talkgroup.CallEvent{Type: talkgroup.CallEnd, Time: end, Call: c}
+	close(frames)
+	r.Wait()
+	return written
+}
+
+// Receivers sharing a system's recordings directory, and a name already
+// taken, never overwrite a recording.
+func TestRecordingNamesDontCollide(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2026, 10, 16, 12, 0, 0, 0, time.Local)
+	id := fmt.Sprintf("1001-%d", start.UnixMilli())
+	c := talkgroup.CallRecord{ID: id, Tgid: 1001, Start: start, LastSeen: start}
+
+	north := record(t, "north", dir, c, 2)
+	south := record(t, "south", dir, c, 1)
+	again := record(t, "north", dir, c, 1)
+	if len(north) != 1 || len(south) != 1 || len(again) != 1 {
+		t.Fatalf("recorded %v, %v and %v, want one file each", north, south, again)
+	}
+	want := []string{"north_" + id + ".wav", "south_" + id + ".wav", "north_" + id + "~2.wav"}
+	for i, path := range []string{north[0], south[0], again[0]} {
+		if filepath.Base(path) != want[i] {
+			t.Errorf("recording %d is %s, want %s", i, filepath.Base(path), want[i])
+		}
+	}
+
+	// The first recording is intact: 2 s of audio and its own sidecar
+	info, err := os.Stat(north[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := info.Size(); size != 44+2*16000 {
+		t.Errorf("first recording is %d bytes, want %d", size, 44+2*16000)
+	}
+	for i, path := range []string{north[0], south[0], again[0]} {
+		data, err := os.ReadFile(SidecarPath(path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var m Metadata
+		if err := json.Unmarshal(data, &m); err != nil {
+			t.Fatal(err)
+		}
+		if m.Audio != filepath.Base(path) || m.Receiver != []string{"north", "south", "north"}[i] {
+			t.Errorf("sidecar of %s: audio %s, receiver %s", filepath.Base(path), m.Audio, m.Receiver)
+		}
+	}
+
+	// Without sidecars, the library still reads the talkgroup and start
+	// time from the name
+	for _, path := range []string{north[0], again[0]} {
+		os.Remove(SidecarPath(path))
+		info, _ := os.Stat(path)
+		rec, err := readRecording(path, info)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec.Tgid != 1001 || !rec.Start.Equal(start) {
+			t.Errorf("%s: tgid %d start %v, want 1001 %v", filepath.Base(path), rec.Tgid, rec.Start, start)
+		}
+	}
+}
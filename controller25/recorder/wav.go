@@ -0,0 +1,92 @@
+package recorder
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io/fs"
+	"os"
+)
+
+// wavWriter writes 16-bit PCM to a WAV file and fills in the sizes on
+// close. The file is written as <path>.part and only appears under its
+// own name once complete, so nothing picks up a recording in progress.
+type wavWriter struct {
+	path  string
+	f     *os.File
+	w     *bufio.Writer
+	bytes int64
+}
+
+// createWAV starts a recording at path. It fails with fs.ErrExist when
+// path, or a recording in progress for it, is already there.
+func createWAV(path string, sampleRate, channels int) (*wavWriter, error) {
+	if _, err := os.Lstat(path); err == nil {
+		return nil, fs.ErrExist
+	}
+	f, err := os.OpenFile(path+".part", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, err
+	}
+	ww := &wavWriter{path: path, f: f, w: bufio.NewWriterSize(f, 32*1024)}
+	if _, err := ww.w.Write(wavHeader(sampleRate, channels, 0)); err != nil {
+		ww.Discard()
+		return nil, err
+	}
+	return ww, nil
+}
+
+func (ww *wavWriter) Write(pcm []byte) error {
+	n, err := ww.w.Write(pcm)
+	ww.bytes += int64(n)
+	return err
+}
+
+// Close patches the RIFF and data chunk sizes and moves the file into
+// place. On error the partial file is removed.
+func (ww *wavWriter) Close() error {
+	if err := ww.finish(); err != nil {
+		ww.Discard()
+		return err
+	}
+	return os.Rename(ww.path+".part", ww.path)
+}
+
+func (ww *wavWriter) finish() error {
+	if err := ww.w.Flush(); err != nil {
+		return err
+	}
+	var size [4]byte
+	binary.LittleEndian.PutUint32(size[:], uint32(36+ww.bytes))
+	if _, err := ww.f.WriteAt(size[:], 4); err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint32(size[:], uint32(ww.bytes))
+	if _, err := ww.f.WriteAt(size[:], 40); err != nil {
+		return err
+	}
+	return ww.f.Close()
+}
+
+// Discard closes and removes the partial file.
+func (ww *wavWriter) Discard() {
+	ww.f.Close()
+	os.Remove(ww.path + ".part")
+}
+
+func wavHeader(sampleRate, channels int, dataSize uint32) []byte {
+	header := make([]byte, 44)
+	copy(header[0:4], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:8], 36+dataSize)
+	copy(header[8:12], "WAVE")
+	copy(header[12:16], "fmt ")
+	binary.LittleEndian.PutUint32(header[16:20], 16)
+	binary.LittleEndian.PutUint16(header[20:22], 1)
+	binary.LittleEndian.PutUint16(header[22:24], uint16(channels))
+	binary.LittleEndian.PutUint32(header[24:28], uint32(sampleRate))
+	binary.LittleEndian.PutUint32(header[28:32], uint32(sampleRate*channels*2))
+	binary.LittleEndian.PutUint16(header[32:34], uint16(channels*2))
+	binary.LittleEndian.PutUint16(header[34:36], 16)
+	copy(header[36:40], "data")
+	binary.LittleEndian.PutUint32(header[40:44], dataSize)
+	return header
+}
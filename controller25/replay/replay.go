@@ -0,0 +1,334 @@
+// Package replay keeps the last few minutes of live audio in memory so a
+// listener can hear a call again after missing the start of it.
+package replay
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"controller25/talkgroup"
+)
+
+// preroll is how much audio heard just before a call is known to be
+// playing counts as part of it, as in the recorder.
+const preroll = 2 * time.Second
+
+// Call is a call whose audio is, at least partly, still in the buffer.
+type Call struct {
+	ID        string     `json:"id"`
+	Tgid      int        `json:"tgid"`
+	Tag       string     `json:"tag,omitempty"`
+	Sources   []int      `json:"sources"`
+	Start     time.Time  `json:"start"`
+	End       *time.Time `json:"end,omitempty"` // nil while it is still playing
+	Duration  float64    `json:"duration"`      // seconds of audio held
+	Truncated bool       `json:"truncated"`     // its start has been overwritten
+
+	// Stretches of the stream the call was heard in. A call that loses the
+	// stream to another one and gets it back has more than one
+	spans []span
+}
+
+// span is a stretch of the stream, in byte positions.
+type span struct {
+	from, to int64
+}
+
+type chunk struct {
+	pos int64
+	at  time.Time
+	pcm []byte
+}
+
+// Buffer is a ring of the most recent audio, indexed by time and call.
+// Only audio is kept: the gaps between transmissions take no room.
+type Buffer struct {
+	mu         sync.Mutex
+	max        int64 // bytes
+	sampleRate int
+	channels   int
+	chunks     []chunk
+	end        int64   // position after the newest byte
+	calls      []*Call // oldest first
+	open       *Call   // the call being played, if any
+}
+
+// NewBuffer creates a buffer holding d of audio.
+func NewBuffer(d time.Duration, sampleRate, channels int) *Buffer {
+	return &Buffer{
+		max:        int64(d.Seconds() * float64(sampleRate*channels*2)),
+		sampleRate: sampleRate,
+		channels:   channels,
+	}
+}
+
+// Size returns how much audio the buffer holds when full.
+func (b *Buffer) Size() time.Duration {
+	return b.duration(b.max)
+}
+
+// Write appends PCM from the live stream.
+func (b *Buffer) Write(pcm []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.chunks = append(b.chunks, chunk{pos: b.end, at: time.Now(), pcm: pcm})
+	b.end += int64(len(pcm))
+	if b.open != nil {
+		b.open.spans[len(b.open.spans)-1].to = b.end
+	}
+
+	for len(b.chunks) > 0 && b.end-b.chunks[0].pos > b.max {
+		b.chunks[0] = chunk{}
+		b.chunks = b.chunks[1:]
+	}
+	start := b.startLocked()
+	n := 0
+	for _, c := range b.calls {
+		for len(c.spans) > 1 && c.spans[0].to <= start {
+			c.spans = c.spans[1:]
+		}
+		if c.spans[0].to > start || c == b.open {
+			b.calls[n] = c
+			n++
+		}
+	}
+	clear(b.calls[n:])
+	b.calls = b.calls[:n]
+}
+
+// Observe follows call events to know which call each stretch of audio
+// belongs to.
+func (b *Buffer) Observe(e talkgroup.CallEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c := e.Call
+	over := !c.Playing || e.Type == talkgroup.CallEnd
+	switch {
+	case b.open != nil && b.open.ID == c.ID:
+		b.open.Tag = c.Tag
+		b.open.Sources = append([]int{}, c.Sources...)
+		if over {
+			b.closeLocked(c.End)
+		}
+	case c.Playing && !over:
+		// Another call took over the audio stream
+		if b.open != nil {
+			now := time.Now()
+			b.closeLocked(&now)
+		}
+		from := b.prerollLocked()
+		if i := b.indexLocked(c.ID); i >= 0 {
+			// Back on the air: it becomes the latest call again
+			b.open = b.calls[i]
+			b.calls = append(append(b.calls[:i], b.calls[i+1:]...), b.open)
+			b.open.End = nil
+			b.open.Sources = append([]int{}, c.Sources...)
+			b.open.spans = append(b.open.spans, span{from: from, to: b.end})
+			return
+		}
+		b.open = &Call{
+			ID:      c.ID,
+			Tgid:    c.Tgid,
+			Tag:     c.Tag,
+			Sources: append([]int{}, c.Sources...),
+			Start:   c.Start,
+			spans:   []span{{from: from, to: b.end}},
+		}
+		b.calls = append(b.calls, b.open)
+	}
+}
+
+func (b *Buffer) indexLocked(id string) int {
+	for i := len(b.calls) - 1; i >= 0; i-- {
+		if b.calls[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (b *Buffer) closeLocked(end *time.Time) {
+	if end == nil {
+		now := time.Now()
+		end = &now
+	}
+	b.open.End = end
+	b.open = nil
+}
+
+// prerollLocked returns where a call starting now begins: up to preroll
+// back, but not into the previous call.
+func (b *Buffer) prerollLocked() int64 {
+	from := b.end
+	var prev int64
+	if len(b.calls) > 0 {
+		last := b.calls[len(b.calls)-1]
+		prev = last.spans[len(last.spans)-1].to
+	}
+	cutoff := time.Now().Add(-preroll)
+	for i := len(b.chunks) - 1; i >= 0; i-- {
+		c := b.chunks[i]
+		if c.at.Before(cutoff) || c.pos < prev {
+			break
+		}
+		from = c.pos
+	}
+	return from
+}
+
+func (b *Buffer) startLocked() int64 {
+	if len(b.chunks) == 0 {
+		return b.end
+	}
+	return b.chunks[0].pos
+}
+
+// Calls returns the calls in the buffer, newest first.
+func (b *Buffer) Calls() []Call {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	list := make([]Call, 0, len(b.calls))
+	for i := len(b.calls) - 1; i >= 0; i-- {
+		list = append(list, b.snapshotLocked(b.calls[i]))
+	}
+	return list
+}
+
+// findLocked returns the newest call matching keep.
+func (b *Buffer) findLocked(keep func(*Call) bool) (Call, bool) {
+	for i := len(b.calls) - 1; i >= 0; i-- {
+		if keep(b.calls[i]) {
+			return b.snapshotLocked(b.calls[i]), true
+		}
+	}
+	return Call{}, false
+}
+
+// snapshotLocked copies c with its spans clipped to what the buffer
+// still holds.
+func (b *Buffer) snapshotLocked(c *Call) Call {
+	s := *c
+	s.Sources = append([]int{}, c.Sources...)
+	s.spans = nil
+	start := b.startLocked()
+	var held int64
+	for _, sp := range c.spans {
+		if sp.to <= start {
+			s.Truncated = true
+			continue
+		}
+		if sp.from < start {
+			sp.from = start
+			s.Truncated = true
+		}
+		s.spans = append(s.spans, sp)
+		held += sp.to - sp.from
+	}
+	s.Duration = b.duration(held).Seconds()
+	return s
+}
+
+// readLocked copies the audio between two stream positions.
+func (b *Buffer) readLocked(from, to int64) []byte {
+	i := sort.Search(len(b.chunks), func(i int) bool {
+		c := b.chunks[i]
+		return c.pos+int64(len(c.pcm)) > from
+	})
+	out := make([]byte, 0, max(to-from, 0))
+	for ; i < len(b.chunks) && b.chunks[i].pos < to; i++ {
+		c := b.chunks[i]
+		lo := max(from-c.pos, 0)
+		hi := min(to-c.pos, int64(len(c.pcm)))
+		out = append(out, c.pcm[lo:hi]...)
+	}
+	return out
+}
+
+func (b *Buffer) duration(bytes int64) time.Duration {
+	bytesPerSecond := float64(b.sampleRate * b.channels * 2)
+	return time.Duration(float64(bytes) / bytesPerSecond * float64(time.Second))
+}
+
+// ServeAudio writes a WAV file of buffered audio, chosen by query:
+// ?seconds=N for the last N seconds, ?tgid=X for the last call on a
+// talkgroup, ?call=<id> for a call by ID, or nothing for the last call.
+func (b *Buffer) ServeAudio(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var pcm []byte
+	var call *Call
+
+	b.mu.Lock()
+	switch {
+	case q.Get("seconds") != "":
+		secs, err := strconv.ParseFloat(q.Get("seconds"), 64)
+		if err != nil || secs <= 0 {
+			b.mu.Unlock()
+			http.Error(w, "Invalid seconds", http.StatusBadRequest)
+			return
+		}
+		bytes := int64(secs*float64(b.sampleRate*b.channels)) * 2
+		pcm = b.readLocked(max(b.end-bytes, b.startLocked()), b.end)
+	default:
+		keep := func(*Call) bool { return true }
+		if s := q.Get("tgid"); s != "" {
+			tgid, err := strconv.Atoi(s)
+			if err != nil {
+				b.mu.Unlock()
+				http.Error(w, "Invalid tgid", http.StatusBadRequest)
+				return
+			}
+			keep = func(c *Call) bool { return c.Tgid == tgid }
+		} else if id := q.Get("call"); id != "" {
+			keep = func(c *Call) bool { return c.ID == id }
+		}
+		c, ok := b.findLocked(keep)
+		if !ok {
+			b.mu.Unlock()
+			http.Error(w, "No matching call in the replay buffer", http.StatusNotFound)
+			return
+		}
+		call = &c
+		for _, sp := range c.spans {
+			pcm = append(pcm, b.readLocked(sp.from, sp.to)...)
+		}
+	}
+	b.mu.Unlock()
+
+	w.Header().Set("Content-Type", "audio/wav")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Content-Length", strconv.Itoa(44+len(pcm)))
+	if call != nil {
+		w.Header().Set("X-Call-ID", call.ID)
+		w.Header().Set("X-Talkgroup-ID", fmt.Sprintf("%d", call.Tgid))
+		if len(call.Sources) > 0 {
+			w.Header().Set("X-Source-ID", fmt.Sprintf("%d", call.Sources[len(call.Sources)-1]))
+		}
+	}
+	w.Write(wavHeader(b.sampleRate, b.channels, len(pcm)))
+	if r.Method != http.MethodHead {
+		w.Write(pcm)
+	}
+}
+
+func wavHeader(sampleRate, channels, dataSize int) []byte {
+	header := make([]byte, 44)
+	copy(header[0:4], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:8], uint32(36+dataSize))
+	copy(header[8:12], "WAVE")
+	copy(header[12:16], "fmt ")
+	binary.LittleEndian.PutUint32(header[16:20], 16)
+	binary.LittleEndian.PutUint16(header[20:22], 1)
+	binary.LittleEndian.PutUint16(header[22:24], uint16(channels))
+	binary.LittleEndian.PutUint32(header[24:28], uint32(sampleRate))
+	binary.LittleEndian.PutUint32(header[28:32], uint32(sampleRate*channels*2))
+	binary.LittleEndian.PutUint16(header[32:34], uint16(channels*2))
+	binary.LittleEndian.PutUint16(header[34:36], 16)
+	copy(header[36:40], "data")
+	binary.LittleEndian.PutUint32(header[40:44], uint32(dataSize))
+	return header
+}
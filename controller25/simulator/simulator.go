@@ -0,0 +1,385 @@
+package simulator
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"controller25/config"
+)
+
+// Launcher stands in for rx.py. It accepts the same flags, writes boatbod
+// style log lines to stdout/stderr and sends synthetic 8 kHz PCM calls to
+// the UDP audio port, so the controller and the app can be exercised on a
+// machine with no SDR attached.
+type Launcher struct{}
+
+func NewLauncher() *Launcher {
+	return &Launcher{}
+}
+
+func (l *Launcher) Name() string {
+	return config.LauncherSimulator
+}
+
+// Talkgroup is one entry the simulator generates calls on.
+type Talkgroup struct {
+	Tgid int
+	Name string
+}
+
+// options are the rx.py flags the simulator honours.
+type options struct {
+	audioHost string
+	audioPort int
+	trunkFile string
+	terminal  string
+}
+
+func parseFlags(flags []string) options {
+	opts := options{audioHost: "127.0.0.1", audioPort: 23456}
+	for i := 0; i < len(flags); i++ {
+		next := func() string {
+			if i+1 < len(flags) {
+				i++
+				return flags[i]
+			}
+			return ""
+		}
+		switch flags[i] {
+		case "-W":
+			opts.audioHost = next()
+		case "-u":
+			if port, err := strconv.Atoi(next()); err == nil {
+				opts.audioPort = port
+			}
+		case "-T":
+			opts.trunkFile = next()
+		case "-l":
+			opts.terminal = next()
+		}
+	}
+	return opts
+}
+
+func (l *Launcher) Launch(flags []string) (config.Process, io.ReadCloser, io.ReadCloser, error) {
+	opts := parseFlags(flags)
+
+	conn, err := net.Dial("udp", net.JoinHostPort(opts.audioHost, strconv.Itoa(opts.audioPort)))
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("simulator: failed to open audio socket: %v", err)
+	}
+	// Like OP25, audio of the other TDMA slot goes 2 ports up
+	slotConn, err := net.Dial("udp", net.JoinHostPort(opts.audioHost, strconv.Itoa(opts.audioPort+2)))
+	if err != nil {
+		conn.Close()
+		return nil, nil, nil, fmt.Errorf("simulator: failed to open audio socket: %v", err)
+	}
+
+	stdoutR, stdoutW := io.Pipe()
+	stderrR, stderrW := io.Pipe()
+	p := &process{
+		args:     append([]string{"simulator"}, flags...),
+		opts:     opts,
+		conn:     conn,
+		slotConn: slotConn,
+		stdout:   stdoutW,
+		stderr:   stderrW,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+		skip:     make(chan struct{}, 1),
+		recent:   make(map[channel]*activity),
+		rng:      rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	p.system = loadSystem(opts.trunkFile)
+	p.locked = p.loadBlacklist()
+	p.startTerminal()
+	go p.run()
+	log.Printf("Simulated OP25 started (audio to %s:%d)", opts.audioHost, opts.audioPort)
+	return p, stdoutR, stderrR, nil
+}
+
+// process is an in-process simulated rx.py.
+type process struct {
+	args     []string
+	opts     options
+	system   system
+	conn     net.Conn
+	slotConn net.Conn // the other slot of a TDMA channel
+	stdout   *io.PipeWriter
+	stderr   *io.PipeWriter
+	rng      *rand.Rand
+
+	mu   sync.Mutex
+	sig  syscall.Signal
+	stop chan struct{}
+	done chan struct{}
+
+	// Terminal controlled state, see terminal.go
+	ctl     sync.Mutex
+	hold    int // held talkgroup, 0 when scanning
+	current int // talkgroup of the call in progress
+	locked  map[int]bool
+	inCall  *activity             // call in progress, nil when idle
+	recent  map[channel]*activity // last call per voice channel
+	queue   []interface{}         // messages for the next terminal reply
+	tsbks   int64
+	ticks   int
+	skip    chan struct{}
+}
+
+func (p *process) Pid() int {
+	return 0
+}
+
+func (p *process) Args() []string {
+	return p.args
+}
+
+func (p *process) Signal(sig syscall.Signal) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	select {
+	case <-p.stop:
+	default:
+		p.sig = sig
+		close(p.stop)
+	}
+	return nil
+}
+
+func (p *process) Wait() (int, error) {
+	<-p.done
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return -1, fmt.Errorf("signal: %s", p.sig)
+}
+
+// system describes what the simulated decoder is tuned to.
+type system struct {
+	name       string
+	nac        int
+	sysid      int
+	wacn       int
+	control    float64   // MHz
+	voice      []float64 // MHz; the upper half are two-slot TDMA channels
+	talkgroups []Talkgroup
+	dir        string // where the trunk file lives, for the blacklist
+}
+
+// loadSystem builds a system from the trunk file and the talkgroups file
+// next to it, falling back to made-up values when they are missing.
+func loadSystem(trunkFile string) system {
+	sys := system{
+		name:    "Simulated",
+		nac:     0x293,
+		sysid:   0x3a1,
+		wacn:    0xbee00,
+		control: 851.0125,
+	}
+	if trunkFile != "" {
+		if ts, err := config.ReadTrunkSystem(trunkFile); err == nil {
+			sys.name = ts.SysName
+			first := strings.Split(ts.ControlChannel, ",")[0]
+			if f, err := strconv.ParseFloat(strings.TrimSpace(first), 64); err == nil {
+				sys.control = f
+			}
+		}
+		sys.dir = filepath.Dir(trunkFile)
+		sys.talkgroups = loadTalkgroups(sys.dir)
+	}
+	if len(sys.talkgroups) == 0 {
+		sys.talkgroups = []Talkgroup{
+			{Tgid: 1001, Name: "Fire Dispatch"},
+			{Tgid: 1002, Name: "Fire Tac 2"},
+			{Tgid: 2001, Name: "Sheriff Dispatch"},
+			{Tgid: 2010, Name: "Sheriff Car-to-Car"},
+			{Tgid: 3001, Name: "EMS Dispatch"},
+			{Tgid: 4005, Name: "Public Works"},
+		}
+	}
+	for i := 1; i <= 4; i++ {
+		sys.voice = append(sys.voice, sys.control+0.25*float64(i)+0.0125)
+	}
+	return sys
+}
+
+func loadTalkgroups(dir string) []Talkgroup {
+	matches, _ := filepath.Glob(filepath.Join(dir, "*_talkgroups.tsv"))
+	if len(matches) == 0 {
+		return nil
+	}
+	f, err := os.Open(matches[0])
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.Comma = '\t'
+	r.LazyQuotes = true
+	r.FieldsPerRecord = -1
+	var tgs []Talkgroup
+	for {
+		row, err := r.Read()
+		if err != nil {
+			break
+		}
+		if len(row) < 2 {
+			continue
+		}
+		tgid, err := strconv.Atoi(strings.TrimSpace(row[0]))
+		if err != nil {
+			continue
+		}
+		tgs = append(tgs, Talkgroup{Tgid: tgid, Name: strings.TrimSpace(row[1])})
+	}
+	return tgs
+}
+
+// logf writes one boatbod-style log line ("MM/DD/YY HH:MM:SS.ffffff [0] ...").
+func (p *process) logf(w io.Writer, format string, args ...interface{}) {
+	ts := time.Now().Format("01/02/06 15:04:05.000000")
+	fmt.Fprintf(w, "%s [0] %s\n", ts, fmt.Sprintf(format, args...))
+}
+
+func (p *process) run() {
+	defer close(p.done)
+	defer p.conn.Close()
+	defer p.slotConn.Close()
+	defer p.stdout.Close()
+	defer p.stderr.Close()
+
+	fmt.Fprintln(p.stdout, "gr-osmosdr 0.2.0.0 (0.2.0) gnuradio 3.10.1.1")
+	fmt.Fprintln(p.stdout, "built-in source types: file fcd rtl rtl_tcp uhd hackrf bladerf rfspace airspy soapy redpitaya")
+	fmt.Fprintln(p.stderr, "Using device #0 Simulated RTL2838UHIDIR SN: 00000001")
+	fmt.Fprintf(p.stderr, "Terminal type: %s\n", p.opts.terminal)
+	p.logf(p.stderr, "Reconfiguring NAC from 0x000 to 0x%03x", p.system.nac)
+	p.logf(p.stderr, "set control channel: %.6f", p.system.control)
+
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+
+	for {
+		// Quiet period between calls
+		if !p.sleep(time.Duration(1000+p.rng.Intn(4000))*time.Millisecond, tick.C) {
+			return
+		}
+		if !p.call(tick.C) {
+			return
+		}
+	}
+}
+
+// sleep waits for d while emitting control channel chatter.
+// It returns false if the process was stopped.
+func (p *process) sleep(d time.Duration, tick <-chan time.Time) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			return true
+		case <-tick:
+			p.tick()
+		case <-p.stop:
+			return false
+		}
+	}
+}
+
+// outcome is how a transmission ended.
+type outcome int
+
+const (
+	finished outcome = iota
+	skipped
+	stopped
+)
+
+// call simulates one voice transmission with one or more speakers.
+func (p *process) call(tick <-chan time.Time) bool {
+	tg, ch, ok := p.nextTalkgroup()
+	if !ok {
+		// Everything is locked out or busy; the control channel stays busy
+		return p.sleep(5*time.Second, tick)
+	}
+	p.logGrant(tg.Tgid, ch)
+	p.beginCall(tg, ch)
+	defer p.endCall()
+
+	reason := "timeout"
+	speakers := 1 + p.rng.Intn(3)
+	for i := 0; i < speakers; i++ {
+		srcid := 1000000 + p.rng.Intn(9000)
+		p.logf(p.stderr, "set tgid=%d, srcaddr=%d", tg.Tgid, srcid)
+		p.setSource(srcid)
+		dur := time.Duration(1500+p.rng.Intn(5000)) * time.Millisecond
+		res := p.transmit(srcid, dur, tick)
+		if res == stopped {
+			return false
+		}
+		if res == skipped {
+			reason = "skip"
+			break
+		}
+		if i < speakers-1 && !p.sleep(time.Duration(300+p.rng.Intn(900))*time.Millisecond, tick) {
+			return false
+		}
+	}
+	p.logRelease(tg.Tgid, ch, reason)
+	return true
+}
+
+// transmit streams synthetic voice to the audio port in real time.
+func (p *process) transmit(srcid int, dur time.Duration, tick <-chan time.Time) outcome {
+	const frameSamples = 160 // 20 ms at 8 kHz
+	voice := newVoice(srcid, p.rng)
+	frame := make([]byte, frameSamples*2)
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+	deadline := time.Now().Add(dur)
+	for time.Now().Before(deadline) {
+		select {
+		case <-ticker.C:
+			voice.fill(frame)
+			p.conn.Write(frame)
+			p.transmitOtherSlot(frame)
+		case <-tick:
+			p.tick()
+		case <-p.skip:
+			return skipped
+		case <-p.stop:
+			return stopped
+		}
+	}
+	return finished
+}
+
+// transmitOtherSlot sends a frame of the call on the other slot of the
+// TDMA channel being followed, if there is one: OP25 decodes both.
+func (p *process) transmitOtherSlot(frame []byte) {
+	p.ctl.Lock()
+	defer p.ctl.Unlock()
+	if p.inCall == nil || p.system.slots(p.inCall.hz) < 2 {
+		return
+	}
+	a, ok := p.recent[channel{hz: p.inCall.hz, slot: 1 - p.inCall.slot}]
+	if !ok || a.until.IsZero() || time.Now().After(a.until) {
+		return
+	}
+	if a.voice == nil {
+		a.voice = newVoice(a.srcid, p.rng)
+	}
+	a.voice.fill(frame)
+	p.slotConn.Write(frame)
+}
@@ -0,0 +1,353 @@
+package simulator
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"controller25/terminal"
+)
+
+// startTerminal serves the boatbod HTTP terminal protocol on the -l
+// address so live control can be exercised without OP25.
+func (p *process) startTerminal() {
+	addr, ok := strings.CutPrefix(p.opts.terminal, "http:")
+	if !ok {
+		return
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		fmt.Fprintf(p.stderr, "http terminal: failed to listen on %s: %v\n", addr, err)
+		return
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(p.serveTerminal)}
+	go srv.Serve(ln)
+	go func() {
+		<-p.done
+		srv.Close()
+	}()
+}
+
+func (p *process) serveTerminal(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	var cmds []terminal.Command
+	if err := json.Unmarshal(body, &cmds); err != nil {
+		fmt.Fprintf(p.stderr, "post_req: error processing input: %s:\n", body)
+	}
+	for _, cmd := range cmds {
+		p.command(cmd)
+	}
+
+	// Like rx.py, reply with whatever is queued for the terminal
+	p.ctl.Lock()
+	reply := p.queue
+	p.queue = nil
+	p.ctl.Unlock()
+	if reply == nil {
+		reply = []interface{}{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reply)
+}
+
+// command applies one terminal command the way boatbod's trunking does.
+func (p *process) command(cmd terminal.Command) {
+	p.ctl.Lock()
+	defer p.ctl.Unlock()
+	switch cmd.Command {
+	case terminal.CmdUpdate:
+		p.enqueueLocked(p.trunkUpdateLocked())
+	case terminal.CmdHold:
+		switch {
+		case cmd.Arg1 > 0:
+			p.hold = int(cmd.Arg1)
+		case p.hold != 0:
+			p.hold = 0
+		default:
+			p.hold = p.current
+		}
+		if p.hold != 0 {
+			p.logf(p.stderr, "set hold tg(%d)", p.hold)
+			// Calls on other talkgroups are dropped right away
+			if p.current != 0 && p.current != p.hold {
+				p.skipCallLocked()
+			}
+		} else {
+			p.logf(p.stderr, "clear hold")
+		}
+	case terminal.CmdSkip:
+		p.skipCallLocked()
+	case terminal.CmdLockout:
+		tgid := int(cmd.Arg1)
+		if tgid == 0 {
+			tgid = p.current
+		}
+		if tgid == 0 {
+			return
+		}
+		p.locked[tgid] = true
+		p.logf(p.stderr, "blacklisting tg(%d)", tgid)
+		if p.current == tgid {
+			p.skipCallLocked()
+		}
+	case terminal.CmdReload:
+		p.locked = p.loadBlacklist()
+		p.logf(p.stderr, "reloaded blacklist: %d talkgroup(s)", len(p.locked))
+	}
+}
+
+// skipCallLocked ends the call in progress, if any. Caller must hold p.ctl.
+func (p *process) skipCallLocked() {
+	if p.current == 0 {
+		return
+	}
+	select {
+	case p.skip <- struct{}{}:
+	default:
+	}
+}
+
+// nextTalkgroup picks the talkgroup and channel of the next call,
+// honouring a hold and lockouts, and reserves the talkgroup so no
+// background call starts on it. It returns false when there is nothing
+// left to hear or no channel is free.
+func (p *process) nextTalkgroup() (Talkgroup, channel, bool) {
+	p.ctl.Lock()
+	defer p.ctl.Unlock()
+	ch, ok := p.freeChannelLocked()
+	if !ok {
+		return Talkgroup{}, channel{}, false
+	}
+	tg, ok := p.pickTalkgroupLocked()
+	if ok {
+		p.current = tg.Tgid
+	}
+	return tg, ch, ok
+}
+
+func (p *process) pickTalkgroupLocked() (Talkgroup, bool) {
+	if p.hold != 0 {
+		for _, tg := range p.system.talkgroups {
+			if tg.Tgid == p.hold {
+				return tg, true
+			}
+		}
+		return Talkgroup{Tgid: p.hold}, true
+	}
+	var open []Talkgroup
+	for _, tg := range p.system.talkgroups {
+		if !p.locked[tg.Tgid] && !p.onAirLocked(tg.Tgid) {
+			open = append(open, tg)
+		}
+	}
+	if len(open) == 0 {
+		return Talkgroup{}, false
+	}
+	return open[p.rng.Intn(len(open))], true
+}
+
+// loadBlacklist reads the *_blacklist.tsv next to the trunk file, the way
+// OP25 does at startup and on reload.
+func (p *process) loadBlacklist() map[int]bool {
+	locked := make(map[int]bool)
+	if p.system.dir == "" {
+		return locked
+	}
+	matches, _ := filepath.Glob(filepath.Join(p.system.dir, "*_blacklist.tsv"))
+	for _, path := range matches {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if tgid, err := strconv.Atoi(strings.TrimSpace(line)); err == nil {
+				locked[tgid] = true
+			}
+		}
+	}
+	return locked
+}
+
+func (p *process) beginCall(tg Talkgroup, ch channel) {
+	p.ctl.Lock()
+	defer p.ctl.Unlock()
+	p.current = tg.Tgid
+	p.inCall = &activity{channel: ch, tgid: tg.Tgid, tag: tg.Name, last: time.Now()}
+	p.recent[ch] = p.inCall
+	p.startOtherSlotLocked(ch)
+	var tdma interface{}
+	if p.system.slots(ch.hz) > 1 {
+		tdma = ch.slot
+	}
+	p.enqueueLocked(map[string]interface{}{
+		"json_type": terminal.TypeChangeFreq,
+		"fc":        ch.hz,
+		"tgid":      tg.Tgid,
+		"tag":       tg.Name,
+		"offset":    0,
+		"nac":       p.system.nac,
+		"system":    p.system.name,
+		"tdma":      tdma,
+		"encrypted": 0,
+		"mode":      0,
+	})
+	// A skip sent while idle doesn't carry over to this call
+	select {
+	case <-p.skip:
+	default:
+	}
+}
+
+func (p *process) endCall() {
+	p.ctl.Lock()
+	defer p.ctl.Unlock()
+	p.current = 0
+	if p.inCall != nil {
+		p.inCall.last = time.Now()
+		p.inCall = nil
+	}
+}
+
+// enqueueLocked queues a message for the terminal, dropping the oldest
+// when nobody is polling. Caller must hold p.ctl.
+func (p *process) enqueueLocked(msg interface{}) {
+	const maxQueue = 32
+	p.queue = append(p.queue, msg)
+	if len(p.queue) > maxQueue {
+		p.queue = p.queue[len(p.queue)-maxQueue:]
+	}
+}
+
+func (p *process) setSource(srcid int) {
+	p.ctl.Lock()
+	defer p.ctl.Unlock()
+	if p.inCall != nil {
+		p.inCall.srcid = srcid
+		p.inCall.last = time.Now()
+	}
+}
+
+// activity is the last call seen on a voice channel.
+type activity struct {
+	channel
+	tgid  int
+	tag   string
+	srcid int
+	voice *voice // for a call heard on the other slot of the followed channel
+	last  time.Time
+	// until is when a background call ends; zero once it has, and for
+	// the call the decoder is tuned to
+	until time.Time
+}
+
+// busy reports whether a call is still going on the channel. Caller must
+// hold p.ctl.
+func (p *process) busyLocked(a *activity) bool {
+	return a == p.inCall || !a.until.IsZero()
+}
+
+// trunkUpdateLocked builds a boatbod style trunk_update from the simulated
+// system. Caller must hold p.ctl.
+func (p *process) trunkUpdateLocked() map[string]interface{} {
+	p.tsbks += 40 + int64(p.rng.Intn(20))
+	control := int64(p.system.control * 1e6)
+	frequencies := map[string]interface{}{}
+	frequencyData := map[string]interface{}{}
+	byFreq := map[int64][]*activity{}
+	for ch, a := range p.recent {
+		if !p.busyLocked(a) && time.Since(a.last) > 60*time.Second {
+			delete(p.recent, ch)
+			continue
+		}
+		slots, ok := byFreq[ch.hz]
+		if !ok {
+			slots = make([]*activity, p.system.slots(ch.hz))
+		}
+		slots[ch.slot] = a
+		byFreq[ch.hz] = slots
+	}
+	for hz, slots := range byFreq {
+		// TDMA frequencies list one entry per slot, an idle slot as null
+		var (
+			tgids    []interface{}
+			tags     []string
+			srcaddrs []interface{}
+			srctags  []string
+			lines    []string
+		)
+		age := 60.0
+		for i, a := range slots {
+			if a == nil {
+				tgids, tags, srcaddrs, srctags = append(tgids, nil), append(tags, ""), append(srcaddrs, nil), append(srctags, "")
+				continue
+			}
+			aAge := time.Since(a.last).Seconds()
+			if p.busyLocked(a) {
+				aAge = 0
+			}
+			age = math.Min(age, aAge)
+			var srcaddr interface{}
+			if a.srcid != 0 {
+				srcaddr = a.srcid
+			}
+			tgids = append(tgids, a.tgid)
+			tags = append(tags, a.tag)
+			srcaddrs = append(srcaddrs, srcaddr)
+			srctags = append(srctags, "")
+			lines = append(lines, fmt.Sprintf("[%d] tgid %d %s", i, a.tgid, a.tag))
+		}
+		key := strconv.FormatInt(hz, 10)
+		frequencies[key] = fmt.Sprintf("%.6f  last seen: %6.1f sec  %s", float64(hz)/1e6, age, strings.Join(lines, " "))
+		frequencyData[key] = map[string]interface{}{
+			"type":          "voice",
+			"tgids":         tgids,
+			"tags":          tags,
+			"srcaddrs":      srcaddrs,
+			"srctags":       srctags,
+			"last_activity": fmt.Sprintf("%.1f", age),
+			"counter":       0,
+			"mode":          0,
+		}
+	}
+	update := map[string]interface{}{
+		"json_type": terminal.TypeTrunkUpdate,
+		strconv.Itoa(p.system.nac): map[string]interface{}{
+			"system":         p.system.name,
+			"top_line":       fmt.Sprintf("WACN 0x%x SYSID 0x%x %.6f/%.6f tsbks %d", p.system.wacn, p.system.sysid, p.system.control, p.system.control-45, p.tsbks),
+			"syid":           p.system.sysid,
+			"rfid":           1,
+			"stid":           1,
+			"sysid":          p.system.sysid,
+			"wacn":           p.system.wacn,
+			"rxchan":         control,
+			"txchan":         control - 45000000,
+			"secondary":      []int64{},
+			"tsbks":          p.tsbks,
+			"last_tsbk":      float64(time.Now().UnixNano()) / 1e9,
+			"frequencies":    frequencies,
+			"frequency_data": frequencyData,
+			"adjacent_data":  map[string]interface{}{},
+		},
+		"srcaddr":   0,
+		"grpaddr":   0,
+		"encrypted": 0,
+		"nac":       p.system.nac,
+	}
+	if p.inCall != nil {
+		update["grpaddr"] = p.inCall.tgid
+		update["srcaddr"] = p.inCall.srcid
+	}
+	return update
+}
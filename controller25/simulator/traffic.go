@@ -0,0 +1,156 @@
+package simulator
+
+import (
+	"time"
+)
+
+// channel is a voice frequency and TDMA slot. FDMA channels use slot 0.
+type channel struct {
+	hz   int64
+	slot int
+}
+
+// slots returns how many TDMA slots a voice frequency carries.
+func (s system) slots(hz int64) int {
+	for i, f := range s.voice {
+		if int64(f*1e6) == hz && i >= len(s.voice)/2 {
+			return 2
+		}
+	}
+	return 1
+}
+
+// maxBackground is how many calls the simulator runs on channels the
+// decoder isn't following.
+const maxBackground = 2
+
+// tick emits once-a-second control channel traffic: status broadcasts,
+// voice channel updates for every call in progress and grants for
+// background calls on other channels. Background calls carry no audio,
+// like the calls a single-channel OP25 sees granted but doesn't follow.
+func (p *process) tick() {
+	p.ctl.Lock()
+	defer p.ctl.Unlock()
+	p.ticks++
+	if p.ticks%5 == 0 {
+		p.logf(p.stderr, "tsbk(0x3a) rfss_sts_bcst: syid: %x rfid 1 stid 1 ch1 %x(%.6f)", p.system.sysid, 0x1005, p.system.control)
+	}
+
+	now := time.Now()
+	background := 0
+	for ch, a := range p.recent {
+		switch {
+		case a == p.inCall:
+			p.logVoiceUpdate(a.tgid, ch)
+		case a.until.IsZero():
+		case now.After(a.until):
+			a.until = time.Time{}
+			a.last = now
+			p.logRelease(a.tgid, ch, "timeout")
+		default:
+			a.last = now
+			background++
+			p.logVoiceUpdate(a.tgid, ch)
+		}
+	}
+
+	if background >= maxBackground || p.rng.Intn(4) != 0 {
+		return
+	}
+	var open []Talkgroup
+	for _, tg := range p.system.talkgroups {
+		if !p.locked[tg.Tgid] && tg.Tgid != p.current && !p.onAirLocked(tg.Tgid) {
+			open = append(open, tg)
+		}
+	}
+	ch, ok := p.freeChannelLocked()
+	if len(open) == 0 || !ok {
+		return
+	}
+	tg := open[p.rng.Intn(len(open))]
+	p.logGrant(tg.Tgid, ch)
+	p.recent[ch] = &activity{
+		channel: ch,
+		tgid:    tg.Tgid,
+		tag:     tg.Name,
+		srcid:   1000000 + p.rng.Intn(9000),
+		last:    now,
+		until:   now.Add(time.Duration(3000+p.rng.Intn(8000)) * time.Millisecond),
+	}
+}
+
+// onAirLocked reports whether tgid has a background call in progress.
+// Caller must hold p.ctl.
+func (p *process) onAirLocked(tgid int) bool {
+	for _, a := range p.recent {
+		if a.tgid == tgid && p.busyLocked(a) {
+			return true
+		}
+	}
+	return false
+}
+
+// freeChannelLocked picks an idle voice channel at random. Caller must
+// hold p.ctl.
+func (p *process) freeChannelLocked() (channel, bool) {
+	var free []channel
+	for _, f := range p.system.voice {
+		hz := int64(f * 1e6)
+		for slot := 0; slot < p.system.slots(hz); slot++ {
+			ch := channel{hz: hz, slot: slot}
+			if a, ok := p.recent[ch]; !ok || !p.busyLocked(a) {
+				free = append(free, ch)
+			}
+		}
+	}
+	if len(free) == 0 {
+		return channel{}, false
+	}
+	return free[p.rng.Intn(len(free))], true
+}
+
+func (p *process) logGrant(tgid int, ch channel) {
+	p.logf(p.stderr, "tsbk(0x00) grp_v_ch_grant: freq %.6f tgid %d", float64(ch.hz)/1e6, tgid)
+	p.logVoiceUpdate(tgid, ch)
+}
+
+func (p *process) logVoiceUpdate(tgid int, ch channel) {
+	p.logf(p.stderr, "voice update:  tg(%d), freq(%d), slot(%d), prio(%d)", tgid, ch.hz, ch.slot, 3)
+}
+
+func (p *process) logRelease(tgid int, ch channel, reason string) {
+	p.logf(p.stderr, "releasing:  tg(%d), freq(%d), slot(%d), reason(%s)", tgid, ch.hz, ch.slot, reason)
+}
+
+// startOtherSlotLocked sometimes starts a call on the other slot of a TDMA
+// channel the decoder tunes to, so both slots carry audio at once. Caller
+// must hold p.ctl.
+func (p *process) startOtherSlotLocked(ch channel) {
+	if p.system.slots(ch.hz) < 2 || p.rng.Intn(2) != 0 {
+		return
+	}
+	other := channel{hz: ch.hz, slot: 1 - ch.slot}
+	if a, ok := p.recent[other]; ok && p.busyLocked(a) {
+		return
+	}
+	var open []Talkgroup
+	for _, tg := range p.system.talkgroups {
+		if !p.locked[tg.Tgid] && tg.Tgid != p.current && !p.onAirLocked(tg.Tgid) {
+			open = append(open, tg)
+		}
+	}
+	if len(open) == 0 {
+		return
+	}
+	tg := open[p.rng.Intn(len(open))]
+	now := time.Now()
+	p.logGrant(tg.Tgid, other)
+	p.recent[other] = &activity{
+		channel: other,
+		tgid:    tg.Tgid,
+		tag:     tg.Name,
+		srcid:   1000000 + p.rng.Intn(9000),
+		last:    now,
+		until:   now.Add(time.Duration(3000+p.rng.Intn(5000)) * time.Millisecond),
+	}
+}
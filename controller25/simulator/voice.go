@@ -0,0 +1,69 @@
+package simulator
+
+import (
+	"encoding/binary"
+	"math"
+	"math/rand"
+)
+
+const sampleRate = 8000
+
+// voice synthesizes a crude but speech-like signal: a harmonic buzz at a
+// per-speaker pitch, shaped by two formants and chopped into syllables.
+type voice struct {
+	f0       float64 // fundamental, Hz
+	formant1 float64
+	formant2 float64
+	syllable float64 // syllables per second
+	phase    float64
+	t        float64
+	rng      *rand.Rand
+}
+
+func newVoice(srcid int, rng *rand.Rand) *voice {
+	// Seed pitch from the source ID so a unit always sounds the same
+	local := rand.New(rand.NewSource(int64(srcid)))
+	return &voice{
+		f0:       95 + local.Float64()*120,
+		formant1: 500 + local.Float64()*300,
+		formant2: 1400 + local.Float64()*800,
+		syllable: 3 + local.Float64()*2,
+		rng:      rng,
+	}
+}
+
+// fill writes len(buf)/2 little-endian S16 samples.
+func (v *voice) fill(buf []byte) {
+	for i := 0; i+1 < len(buf); i += 2 {
+		// Slow pitch drift gives some intonation
+		f0 := v.f0 * (1 + 0.08*math.Sin(2*math.Pi*0.7*v.t))
+		v.phase += 2 * math.Pi * f0 / sampleRate
+		if v.phase > 2*math.Pi {
+			v.phase -= 2 * math.Pi
+		}
+
+		var s float64
+		for k := 1; float64(k)*f0 < 3400; k++ {
+			h := float64(k) * f0
+			gain := formantGain(h, v.formant1, 150) + 0.6*formantGain(h, v.formant2, 250)
+			s += gain * math.Sin(float64(k)*v.phase) / float64(k)
+		}
+
+		env := math.Sin(math.Pi * v.syllable * v.t)
+		env = env * env
+		s = s*env*0.35 + (v.rng.Float64()-0.5)*0.01
+
+		if s > 1 {
+			s = 1
+		} else if s < -1 {
+			s = -1
+		}
+		binary.LittleEndian.PutUint16(buf[i:], uint16(int16(s*32767)))
+		v.t += 1.0 / sampleRate
+	}
+}
+
+func formantGain(f, center, width float64) float64 {
+	d := (f - center) / width
+	return 1 / (1 + d*d)
+}
@@ -0,0 +1,178 @@
+package supervisor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Registry records every process the controller launches in a PID file
+// directory. Each record carries the kernel start time of the process, so a
+// recycled PID is never mistaken for one of ours. On a shared box this is
+// what keeps the controller away from OP25 instances it did not start.
+type Registry struct {
+	dir string
+}
+
+// Record is one PID file.
+type Record struct {
+	Pid            int       `json:"pid"`
+	StartTime      uint64    `json:"start_time"` // clock ticks since boot, from /proc/<pid>/stat
+	Args           []string  `json:"args"`
+	Launched       time.Time `json:"launched"`
+	Controller     int       `json:"controller_pid"`
+	ControllerTime uint64    `json:"controller_start_time"`
+}
+
+func NewRegistry(dir string) *Registry {
+	return &Registry{dir: dir}
+}
+
+func (r *Registry) path(pid int) string {
+	return filepath.Join(r.dir, fmt.Sprintf("op25-%d.json", pid))
+}
+
+// Add writes a PID file for a freshly started process.
+func (r *Registry) Add(pid int, args []string) error {
+	if err := os.MkdirAll(r.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create pid dir: %v", err)
+	}
+	startTime, err := procStartTime(pid)
+	if err != nil {
+		return err
+	}
+	self := os.Getpid()
+	selfTime, _ := procStartTime(self)
+	rec := Record{
+		Pid:            pid,
+		StartTime:      startTime,
+		Args:           args,
+		Launched:       time.Now(),
+		Controller:     self,
+		ControllerTime: selfTime,
+	}
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(r.path(pid), data, 0644)
+}
+
+// Remove deletes the PID file once the process has been reaped.
+func (r *Registry) Remove(pid int) {
+	if err := os.Remove(r.path(pid)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("Warning: failed to remove pid file for %d: %v", pid, err)
+	}
+}
+
+// owned reports whether rec still describes a live process we launched.
+func (rec *Record) owned() bool {
+	startTime, err := procStartTime(rec.Pid)
+	return err == nil && startTime == rec.StartTime
+}
+
+// controllerAlive reports whether the controller that wrote rec is still
+// running, i.e. rec belongs to a live controller and is not an orphan.
+func (rec *Record) controllerAlive() bool {
+	if rec.Controller == os.Getpid() {
+		return true
+	}
+	startTime, err := procStartTime(rec.Controller)
+	return err == nil && startTime == rec.ControllerTime
+}
+
+// ReapOrphans stops processes left behind by a previous controller run.
+// Only processes with a matching PID file and start time are touched;
+// stale PID files are removed.
+func (r *Registry) ReapOrphans(timeout time.Duration) {
+	log.Println("Checking for orphaned OP25 processes...")
+	entries, err := os.ReadDir(r.dir)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Warning: Failed to read pid dir %s: %v", r.dir, err)
+		}
+		log.Println("No orphaned OP25 processes found")
+		return
+	}
+
+	reaped := 0
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, "op25-") || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		path := filepath.Join(r.dir, name)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var rec Record
+		if err := json.Unmarshal(data, &rec); err != nil || rec.Pid <= 0 {
+			log.Printf("Warning: Removing unreadable pid file %s", path)
+			os.Remove(path)
+			continue
+		}
+		if rec.controllerAlive() {
+			continue
+		}
+		if !rec.owned() {
+			// Process is gone or its PID was recycled by something else
+			os.Remove(path)
+			continue
+		}
+		log.Printf("Stopping orphaned OP25 process (PID %d, launched %s)", rec.Pid, rec.Launched.Format(time.RFC3339))
+		signal := func(sig syscall.Signal) error { return syscall.Kill(-rec.Pid, sig) }
+		terminate(signal, func() bool { return !rec.owned() }, timeout)
+		os.Remove(path)
+		reaped++
+	}
+	if reaped == 0 {
+		log.Println("No orphaned OP25 processes found")
+	} else {
+		log.Printf("Reaped %d orphaned OP25 process(es)", reaped)
+	}
+}
+
+// terminate sends SIGTERM, waits up to timeout for gone() to report true,
+// then falls back to SIGKILL.
+func terminate(signal func(syscall.Signal) error, gone func() bool, timeout time.Duration) {
+	if err := signal(syscall.SIGTERM); err != nil && err != syscall.ESRCH {
+		log.Printf("Warning: SIGTERM failed: %v", err)
+	}
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if gone() {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	log.Printf("Process did not exit within %s, sending SIGKILL", timeout)
+	signal(syscall.SIGKILL)
+}
+
+// procStartTime returns field 22 (starttime) of /proc/<pid>/stat.
+func procStartTime(pid int) (uint64, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, err
+	}
+	// The command name (field 2) may contain spaces, so parse after its ')'
+	s := string(data)
+	end := strings.LastIndexByte(s, ')')
+	if end < 0 {
+		return 0, fmt.Errorf("malformed /proc/%d/stat", pid)
+	}
+	fields := strings.Fields(s[end+1:])
+	// fields[0] is field 3 (state), so starttime is fields[19]
+	if len(fields) < 20 {
+		return 0, fmt.Errorf("malformed /proc/%d/stat", pid)
+	}
+	return strconv.ParseUint(fields[19], 10, 64)
+}
@@ -0,0 +1,168 @@
+package supervisor
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"controller25/pubsub"
+)
+
+// State is a step in the OP25 process lifecycle.
+type State string
+
+const (
+	StateStopped    State = "stopped"
+	StateStarting   State = "starting"
+	StateRunning    State = "running"
+	StateStopping   State = "stopping"
+	StateCrashed    State = "crashed"
+	StateRestarting State = "restarting"
+)
+
+// Transition records one state change.
+type Transition struct {
+	From   State     `json:"from"`
+	To     State     `json:"to"`
+	Reason string    `json:"reason"`
+	Time   time.Time `json:"time"`
+}
+
+// maxTransitions bounds the transition history kept for the status API.
+const maxTransitions = 50
+
+// setStateLocked moves to a new state and notifies event subscribers.
+// Caller must hold s.mu.
+func (s *Supervisor) setStateLocked(to State, reason string) {
+	t := Transition{
+		From:   s.state,
+		To:     to,
+		Reason: reason,
+		Time:   time.Now(),
+	}
+	s.state = to
+	s.stateSince = t.Time
+	s.stateReason = reason
+	s.transitions = append(s.transitions, t)
+	if len(s.transitions) > maxTransitions {
+		s.transitions = s.transitions[len(s.transitions)-maxTransitions:]
+	}
+	for q := range s.subscribers {
+		q.Push(t)
+	}
+}
+
+// MarkStarting flags that a start has been requested, before any of the
+// slower preparation work is done. It is a no-op if already starting.
+func (s *Supervisor) MarkStarting(reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state != StateStarting {
+		s.setStateLocked(StateStarting, reason)
+	}
+}
+
+// MarkFailed reports a start that failed before OP25 was launched, e.g.
+// because its audio port is taken, the way a failed launch is reported.
+func (s *Supervisor) MarkFailed(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.proc == nil {
+		s.setStateLocked(StateCrashed, fmt.Sprintf("start failed: %v", err))
+	}
+}
+
+// Subscribe returns a channel receiving every transition from now on and a
+// function to unsubscribe, which closes the channel. No transition is
+// dropped, however slow the reader: a missed crash would leave a stale
+// hold in place.
+func (s *Supervisor) Subscribe() (<-chan Transition, func()) {
+	q := pubsub.NewQueue[Transition]()
+	s.mu.Lock()
+	s.subscribers[q] = struct{}{}
+	s.mu.Unlock()
+	return q.C(), func() {
+		s.mu.Lock()
+		delete(s.subscribers, q)
+		s.mu.Unlock()
+		q.Close()
+	}
+}
+
+// maxClientBacklog is how many transitions an SSE client may fall behind
+// by before it is cut off. Reconnecting, it gets the current state again.
+const maxClientBacklog = 256
+
+// ServeEvents streams state transitions as Server-Sent Events. The current
+// state is sent first so clients don't have to poll /api/op25/status. A
+// client sees every transition after it, or is disconnected once too far
+// behind.
+func (s *Supervisor) ServeEvents(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	q := pubsub.NewBoundedQueue[Transition](maxClientBacklog)
+	s.mu.Lock()
+	current := Transition{From: s.state, To: s.state, Reason: s.stateReason, Time: s.stateSince}
+	s.subscribers[q] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.subscribers, q)
+		s.mu.Unlock()
+		q.Close()
+	}()
+
+	// Cut a client that falls too far behind off even in the middle of a
+	// write it is stuck in
+	rc := http.NewResponseController(w)
+	stop, cut := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(cut)
+		select {
+		case <-q.Done():
+			rc.SetWriteDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+	defer func() {
+		close(stop)
+		<-cut
+	}()
+
+	writeEvent(w, current)
+	flusher.Flush()
+
+	keepalive := time.NewTicker(15 * time.Second)
+	defer keepalive.Stop()
+	notify := r.Context().Done()
+	for {
+		select {
+		case t, ok := <-q.C():
+			if !ok {
+				return
+			}
+			writeEvent(w, t)
+			flusher.Flush()
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+			flusher.Flush()
+		case <-notify:
+			return
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, t Transition) {
+	data, _ := json.Marshal(t)
+	fmt.Fprintf(w, "event: state\ndata: %s\n\n", data)
+}
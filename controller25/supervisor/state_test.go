@@ -0,0 +1,126 @@
+package supervisor
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSubscribeLossless(t *testing.T) {
+	s := New(nil, DefaultOptions())
+	events, unsubscribe := s.Subscribe()
+
+	const n = 200 // well past the old 16-slot buffer
+	states := []State{StateStarting, StateRunning, StateCrashed, StateRestarting}
+	s.mu.Lock()
+	for i := 0; i < n; i++ {
+		s.setStateLocked(states[i%len(states)], "test")
+	}
+	s.mu.Unlock()
+
+	for i := 0; i < n; i++ {
+		select {
+		case tr := <-events:
+			if want := states[i%len(states)]; tr.To != want {
+				t.Fatalf("transition %d: got %s, want %s", i, tr.To, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got %d transitions, want %d", i, n)
+		}
+	}
+	unsubscribe()
+	if _, ok := <-events; ok {
+		t.Fatal("channel not closed on unsubscribe")
+	}
+
+	st := s.Status()
+	if len(st.Transitions) != maxTransitions {
+		t.Errorf("status has %d transitions, want %d", len(st.Transitions), maxTransitions)
+	}
+	if last := st.Transitions[len(st.Transitions)-1]; last.To != st.State {
+		t.Errorf("last transition is to %s, state is %s", last.To, st.State)
+	}
+}
+
+func TestServeEvents(t *testing.T) {
+	s := New(nil, DefaultOptions())
+	srv := httptest.NewServer(http.HandlerFunc(s.ServeEvents))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	lines := bufio.NewScanner(resp.Body)
+	next := func() string {
+		t.Helper()
+		for lines.Scan() {
+			if data, ok := strings.CutPrefix(lines.Text(), "data: "); ok {
+				return data
+			}
+		}
+		t.Fatalf("stream ended: %v", lines.Err())
+		return ""
+	}
+
+	if data := next(); !strings.Contains(data, `"to":"stopped"`) {
+		t.Fatalf("first event %s, want the current state", data)
+	}
+	s.MarkStarting("test")
+	if data := next(); !strings.Contains(data, `"to":"starting"`) {
+		t.Fatalf("got %s, want the move to starting", data)
+	}
+}
+
+// A client that stops reading is cut off once too far behind, rather than
+// transitions queueing for it without end.
+func TestServeEventsStalledClient(t *testing.T) {
+	s := New(nil, DefaultOptions())
+	srv := httptest.NewServer(http.HandlerFunc(s.ServeEvents))
+	t.Cleanup(srv.Close) // after the client has hung up
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	// Far more than fits in the socket buffers
+	const n = 50000
+	states := []State{StateStarting, StateRunning}
+	s.mu.Lock()
+	for i := 0; i < n; i++ {
+		s.setStateLocked(states[i%len(states)], strings.Repeat("x", 100))
+	}
+	s.mu.Unlock()
+
+	// Catching up, the client finds the stream cut short
+	done := make(chan int)
+	go func() {
+		count := 0
+		lines := bufio.NewScanner(resp.Body)
+		for lines.Scan() {
+			if strings.HasPrefix(lines.Text(), "data: ") {
+				count++
+			}
+		}
+		done <- count
+	}()
+	select {
+	case count := <-done:
+		if count >= 1+n {
+			t.Errorf("got all %d events, want the stream cut off", count)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("stream still open")
+	}
+	s.mu.Lock()
+	left := len(s.subscribers)
+	s.mu.Unlock()
+	if left != 0 {
+		t.Errorf("%d subscribers left after the client was cut off", left)
+	}
+}
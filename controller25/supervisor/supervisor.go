@@ -0,0 +1,337 @@
+package supervisor
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"controller25/config"
+	"controller25/pubsub"
+)
+
+// LogSink receives the output of every launched process.
+type LogSink interface {
+	Attach(stdout, stderr io.Reader) <-chan struct{}
+	Tail(prefix string, n int) []string
+	Notice(line string)
+}
+
+// Options controls restart behaviour.
+type Options struct {
+	InitialBackoff time.Duration // Delay before the first restart
+	MaxBackoff     time.Duration // Upper bound for the doubling delay
+	StableAfter    time.Duration // Uptime after which the backoff resets
+	MaxRestarts    int           // Restarts allowed within RestartWindow
+	RestartWindow  time.Duration // Window used for crash-loop detection
+	StopTimeout    time.Duration // Grace period between SIGTERM and SIGKILL
+}
+
+// DefaultOptions returns the restart policy used when config.ini has no overrides.
+func DefaultOptions() Options {
+	return Options{
+		InitialBackoff: 1 * time.Second,
+		MaxBackoff:     60 * time.Second,
+		StableAfter:    2 * time.Minute,
+		MaxRestarts:    5,
+		RestartWindow:  10 * time.Minute,
+		StopTimeout:    5 * time.Second,
+	}
+}
+
+// stderrTailLines is how much of the dying process's stderr is kept.
+const stderrTailLines = 20
+
+// CrashInfo describes the most recent unexpected exit.
+type CrashInfo struct {
+	Time     time.Time `json:"time"`
+	ExitCode int       `json:"exit_code"`
+	Reason   string    `json:"reason"`
+	Stderr   []string  `json:"stderr,omitempty"`
+}
+
+// Status is a snapshot of the supervised process.
+type Status struct {
+	State        State        `json:"state"`
+	Since        time.Time    `json:"since"`
+	Reason       string       `json:"reason,omitempty"`
+	Running      bool         `json:"running"`
+	Pid          int          `json:"pid,omitempty"`
+	Flags        []string     `json:"flags"`
+	RestartCount int          `json:"restart_count"`
+	LastCrash    *CrashInfo   `json:"last_crash,omitempty"`
+	CrashLoop    bool         `json:"crash_loop"`
+	Transitions  []Transition `json:"transitions"` // recent, oldest first
+}
+
+// Supervisor keeps one OP25 process alive, restarting it with exponential
+// backoff when it exits on its own and giving up once it is crash-looping.
+// A single Supervisor lives for the whole controller run; Start and Stop
+// may be called any number of times.
+type Supervisor struct {
+	launcher config.Launcher
+	opts     Options
+	registry *Registry
+
+	mu          sync.Mutex
+	logs        LogSink
+	flags       []string
+	proc        config.Process
+	exited      chan struct{} // closed when the current process has been reaped
+	stop        chan struct{} // closed by Stop to cancel watchers and pending restarts
+	startedAt   time.Time
+	restarts    int
+	consecutive int
+	recent      []time.Time
+	lastCrash   *CrashInfo
+	crashLoop   bool
+
+	state       State
+	stateSince  time.Time
+	stateReason string
+	transitions []Transition
+	subscribers map[*pubsub.Queue[Transition]]struct{}
+}
+
+func New(launcher config.Launcher, opts Options) *Supervisor {
+	return &Supervisor{
+		launcher:    launcher,
+		opts:        opts,
+		state:       StateStopped,
+		stateSince:  time.Now(),
+		subscribers: make(map[*pubsub.Queue[Transition]]struct{}),
+	}
+}
+
+// SetRegistry makes the supervisor record every process it launches, so
+// orphans can be identified after a controller crash.
+func (s *Supervisor) SetRegistry(r *Registry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.registry = r
+}
+
+// Start launches the process with the given flags and begins supervising it.
+// Output of this and every restarted process goes to logs.
+func (s *Supervisor) Start(flags []string, logs LogSink) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.proc != nil {
+		return errors.New("OP25 already running")
+	}
+	if s.state != StateStarting {
+		s.setStateLocked(StateStarting, "start requested")
+	}
+	s.logs = logs
+	s.flags = flags
+	s.stop = make(chan struct{})
+	s.restarts = 0
+	s.consecutive = 0
+	s.recent = nil
+	s.crashLoop = false
+	if err := s.launchLocked(); err != nil {
+		s.flags = nil
+		s.setStateLocked(StateCrashed, fmt.Sprintf("start failed: %v", err))
+		return err
+	}
+	s.setStateLocked(StateRunning, fmt.Sprintf("started %s", describeProc(s.proc)))
+	return nil
+}
+
+func (s *Supervisor) launchLocked() error {
+	proc, stdout, stderr, err := s.launcher.Launch(s.flags)
+	if err != nil {
+		return err
+	}
+	s.proc = proc
+	s.startedAt = time.Now()
+	s.exited = make(chan struct{})
+	if s.registry != nil && proc.Pid() > 0 {
+		if err := s.registry.Add(proc.Pid(), proc.Args()); err != nil {
+			log.Printf("Warning: failed to record OP25 PID %d: %v", proc.Pid(), err)
+		}
+	}
+	readers := s.logs.Attach(stdout, stderr)
+	go s.watch(proc, readers, s.exited, s.stop)
+	return nil
+}
+
+// watch reaps the process and decides whether to restart it.
+func (s *Supervisor) watch(proc config.Process, readers <-chan struct{}, exited, stop chan struct{}) {
+	// Wait must not be called before the pipes are drained.
+	<-readers
+	exitCode, err := proc.Wait()
+
+	s.mu.Lock()
+	if s.registry != nil && proc.Pid() > 0 {
+		s.registry.Remove(proc.Pid())
+	}
+	uptime := time.Since(s.startedAt)
+	s.proc = nil
+	close(exited)
+	if isClosed(stop) {
+		s.mu.Unlock()
+		return
+	}
+
+	crash := &CrashInfo{
+		Time:     time.Now(),
+		ExitCode: exitCode,
+		Reason:   describeExit(err),
+		Stderr:   s.logs.Tail("[stderr]", stderrTailLines),
+	}
+	if n := len(crash.Stderr); n > 0 {
+		crash.Reason = fmt.Sprintf("%s: %s", crash.Reason, crash.Stderr[n-1])
+	}
+	s.lastCrash = crash
+	if uptime >= s.opts.StableAfter {
+		s.consecutive = 0
+	}
+	log.Printf("OP25 exited unexpectedly after %s (%s)", uptime.Round(time.Second), crash.Reason)
+	s.logs.Notice(fmt.Sprintf("OP25 exited unexpectedly: %s", crash.Reason))
+	s.setStateLocked(StateCrashed, crash.Reason)
+	s.mu.Unlock()
+
+	s.restartLoop(stop)
+}
+
+// restartLoop relaunches the process, backing off between failed attempts.
+func (s *Supervisor) restartLoop(stop chan struct{}) {
+	for {
+		s.mu.Lock()
+		cutoff := time.Now().Add(-s.opts.RestartWindow)
+		kept := s.recent[:0]
+		for _, t := range s.recent {
+			if t.After(cutoff) {
+				kept = append(kept, t)
+			}
+		}
+		s.recent = kept
+		if len(s.recent) >= s.opts.MaxRestarts {
+			s.crashLoop = true
+			msg := fmt.Sprintf("OP25 crashed %d times within %s, giving up", len(s.recent), s.opts.RestartWindow)
+			log.Print(msg)
+			s.logs.Notice(msg)
+			s.setStateLocked(StateCrashed, msg)
+			s.mu.Unlock()
+			return
+		}
+		delay := s.backoffLocked()
+		s.consecutive++
+		s.setStateLocked(StateRestarting, fmt.Sprintf("restart #%d in %s", s.restarts+1, delay))
+		s.mu.Unlock()
+
+		log.Printf("Restarting OP25 in %s", delay)
+		select {
+		case <-time.After(delay):
+		case <-stop:
+			return
+		}
+
+		s.mu.Lock()
+		if isClosed(stop) {
+			s.mu.Unlock()
+			return
+		}
+		s.restarts++
+		s.recent = append(s.recent, time.Now())
+		err := s.launchLocked()
+		if err == nil {
+			s.logs.Notice(fmt.Sprintf("OP25 restarted (restart #%d)", s.restarts))
+			s.setStateLocked(StateRunning, fmt.Sprintf("restarted %s", describeProc(s.proc)))
+			s.mu.Unlock()
+			return
+		}
+		s.lastCrash = &CrashInfo{Time: time.Now(), ExitCode: -1, Reason: err.Error()}
+		log.Printf("OP25 restart failed: %v", err)
+		s.setStateLocked(StateCrashed, fmt.Sprintf("restart failed: %v", err))
+		s.mu.Unlock()
+	}
+}
+
+func (s *Supervisor) backoffLocked() time.Duration {
+	delay := s.opts.InitialBackoff
+	for i := 0; i < s.consecutive && delay < s.opts.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > s.opts.MaxBackoff {
+		delay = s.opts.MaxBackoff
+	}
+	return delay
+}
+
+// Stop asks the process group to exit with SIGTERM, escalates to SIGKILL
+// after the stop timeout and waits for it to be reaped. No restart is
+// attempted afterwards.
+func (s *Supervisor) Stop(reason string) {
+	s.mu.Lock()
+	if s.stop != nil && !isClosed(s.stop) {
+		close(s.stop)
+	}
+	proc := s.proc
+	exited := s.exited
+	timeout := s.opts.StopTimeout
+	s.setStateLocked(StateStopping, reason)
+	s.mu.Unlock()
+
+	if proc != nil {
+		log.Println("Terminating OP25 process...")
+		terminate(proc.Signal, func() bool { return isClosed(exited) }, timeout)
+		<-exited
+		log.Println("OP25 process terminated")
+	}
+
+	s.mu.Lock()
+	s.flags = nil
+	s.setStateLocked(StateStopped, reason)
+	s.mu.Unlock()
+}
+
+// Status returns a snapshot for the status API.
+func (s *Supervisor) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := Status{
+		State:        s.state,
+		Since:        s.stateSince,
+		Reason:       s.stateReason,
+		Running:      s.proc != nil,
+		Flags:        s.flags,
+		RestartCount: s.restarts,
+		CrashLoop:    s.crashLoop,
+		Transitions:  append([]Transition{}, s.transitions...),
+	}
+	if s.proc != nil {
+		st.Pid = s.proc.Pid()
+	}
+	if s.lastCrash != nil {
+		c := *s.lastCrash
+		st.LastCrash = &c
+	}
+	return st
+}
+
+func describeProc(proc config.Process) string {
+	if proc.Pid() > 0 {
+		return fmt.Sprintf("with PID %d", proc.Pid())
+	}
+	return "in-process"
+}
+
+func describeExit(err error) string {
+	if err == nil {
+		return "exit status 0"
+	}
+	return err.Error()
+}
+
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,185 @@
+package talkgroup
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Call is one voice transmission in progress on a voice frequency/slot.
+// On Phase II systems both slots of a frequency can carry calls at once.
+type Call struct {
+	Tgid      int       `json:"tgid"`
+	Srcid     int       `json:"srcid"`
+	Tag       string    `json:"tag,omitempty"`
+	Frequency int64     `json:"frequency"` // Hz
+	Slot      int       `json:"slot"`      // TDMA slot, 0 for FDMA
+	Emergency bool      `json:"emergency"`
+	Encrypted bool      `json:"encrypted"`
+	Start     time.Time `json:"start"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// CallKey identifies a voice channel.
+type CallKey struct {
+	Frequency int64
+	Slot      int
+}
+
+// callSet holds concurrent calls keyed by voice channel. Callers lock.
+type callSet struct {
+	calls map[CallKey]*Call
+}
+
+func newCallSet() callSet {
+	return callSet{calls: make(map[CallKey]*Call)}
+}
+
+// touch records activity on key. A different talkgroup on the same
+// channel starts a new call.
+func (s *callSet) touch(key CallKey, tgid, srcid int, tag string, now time.Time) *Call {
+	c, ok := s.calls[key]
+	if !ok || c.Tgid != tgid {
+		c = &Call{Tgid: tgid, Frequency: key.Frequency, Slot: key.Slot, Start: now}
+		s.calls[key] = c
+	}
+	if srcid > 0 {
+		c.Srcid = srcid
+	}
+	if tag != "" {
+		c.Tag = tag
+	}
+	c.LastSeen = now
+	return c
+}
+
+// rekey moves tgid's call from one channel to another, e.g. once the slot
+// of a granted call is known.
+func (s *callSet) rekey(from, to CallKey, tgid int) {
+	c, ok := s.calls[from]
+	if !ok || c.Tgid != tgid || from == to {
+		return
+	}
+	if _, taken := s.calls[to]; taken {
+		return
+	}
+	delete(s.calls, from)
+	c.Frequency, c.Slot = to.Frequency, to.Slot
+	s.calls[to] = c
+}
+
+func (s *callSet) end(key CallKey) {
+	delete(s.calls, key)
+}
+
+// retain drops every call not in keep, for sources that report a full
+// snapshot of the voice channels.
+func (s *callSet) retain(keep map[CallKey]bool) {
+	for key := range s.calls {
+		if !keep[key] {
+			delete(s.calls, key)
+		}
+	}
+}
+
+// expire drops calls with no activity for longer than after.
+func (s *callSet) expire(after time.Duration) {
+	for key, c := range s.calls {
+		if time.Since(c.LastSeen) > after {
+			delete(s.calls, key)
+		}
+	}
+}
+
+func (s *callSet) clear() {
+	s.calls = make(map[CallKey]*Call)
+}
+
+// list returns copies of the calls seen within after, by frequency and slot.
+func (s *callSet) list(after time.Duration) []Call {
+	calls := []Call{}
+	for _, c := range s.calls {
+		if time.Since(c.LastSeen) <= after {
+			calls = append(calls, *c)
+		}
+	}
+	sort.Slice(calls, func(i, j int) bool {
+		if calls[i].Frequency != calls[j].Frequency {
+			return calls[i].Frequency < calls[j].Frequency
+		}
+		return calls[i].Slot < calls[j].Slot
+	})
+	return calls
+}
+
+// latest returns the most recently active call on tgid, or nil.
+func (s *callSet) latest(tgid int) *Call {
+	var found *Call
+	for _, c := range s.calls {
+		if c.Tgid == tgid && (found == nil || c.LastSeen.After(found.LastSeen)) {
+			found = c
+		}
+	}
+	return found
+}
+
+// find returns a copy of the most recently active call on tgid, or nil.
+func (s *callSet) find(tgid int) *Call {
+	found := s.latest(tgid)
+	if found == nil {
+		return nil
+	}
+	c := *found
+	return &c
+}
+
+// playing returns the call for the talkgroup being decoded, synthesizing
+// one from tg when no grant for it was seen.
+func (s *callSet) playing(tg *TalkgroupInfo, since time.Time) *Call {
+	if tg == nil {
+		return nil
+	}
+	freq := int64(0)
+	if f, err := strconv.ParseFloat(tg.Frequency, 64); err == nil {
+		freq = int64(math.Round(f * 1e6))
+	}
+	// After a channel change the old channel lingers for a moment; the
+	// decoder is on the one it was last tuned to
+	var found *Call
+	for _, c := range s.calls {
+		if c.Tgid != tg.Tgid {
+			continue
+		}
+		if found == nil || betterPlaying(c, found, freq) {
+			found = c
+		}
+	}
+	if found != nil {
+		c := *found
+		if c.Srcid == 0 {
+			c.Srcid = tg.Srcid
+		}
+		if c.Tag == "" {
+			c.Tag = tg.Tag
+		}
+		return &c
+	}
+	c := &Call{Tgid: tg.Tgid, Srcid: tg.Srcid, Tag: tg.Tag, Frequency: freq, Start: since, LastSeen: tg.LastUpdate}
+	if c.Start.IsZero() {
+		c.Start = tg.LastUpdate
+	}
+	return c
+}
+
+// betterPlaying reports whether a is more likely than b to be the call
+// the decoder is tuned to at freq.
+func betterPlaying(a, b *Call, freq int64) bool {
+	if (a.Frequency == freq) != (b.Frequency == freq) {
+		return a.Frequency == freq
+	}
+	if !a.Start.Equal(b.Start) {
+		return a.Start.After(b.Start)
+	}
+	return a.LastSeen.After(b.LastSeen)
+}
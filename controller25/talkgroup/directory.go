@@ -0,0 +1,126 @@
+package talkgroup
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry is what a system's files say about one talkgroup.
+type Entry struct {
+	Tgid     int    `json:"tgid"`
+	Name     string `json:"name,omitempty"`
+	Category string `json:"category,omitempty"`
+	Tag      string `json:"tag,omitempty"`
+	Priority int    `json:"priority,omitempty"` // third column of the talkgroups file
+}
+
+// DefaultPriority is OP25's priority for talkgroups the talkgroups file
+// gives none. Lower numbers are more important.
+const DefaultPriority = 3
+
+// Directory looks talkgroups up in systems/<id>/<id>_talkgroups.tsv and
+// the RadioReference metadata next to it, re-reading them when they change.
+type Directory struct {
+	mu      sync.Mutex
+	systems map[string]*systemDirectory
+}
+
+type systemDirectory struct {
+	modTimes [2]time.Time
+	entries  map[int]Entry
+}
+
+func NewDirectory() *Directory {
+	return &Directory{systems: make(map[string]*systemDirectory)}
+}
+
+// Lookup returns the entry for tgid in systemID. Unknown talkgroups come
+// back with only Tgid set.
+func (d *Directory) Lookup(systemID string, tgid int) Entry {
+	if systemID == "" {
+		return Entry{Tgid: tgid}
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	sys := d.loadLocked(systemID)
+	if e, ok := sys.entries[tgid]; ok {
+		return e
+	}
+	return Entry{Tgid: tgid}
+}
+
+// Category returns tgid's category in systemID, or "".
+func (d *Directory) Category(systemID string, tgid int) string {
+	return d.Lookup(systemID, tgid).Category
+}
+
+// Priority returns tgid's priority in systemID, DefaultPriority when the
+// talkgroups file gives none.
+func (d *Directory) Priority(systemID string, tgid int) int {
+	if p := d.Lookup(systemID, tgid).Priority; p > 0 {
+		return p
+	}
+	return DefaultPriority
+}
+
+func (d *Directory) loadLocked(systemID string) *systemDirectory {
+	dir := filepath.Join("systems", systemID)
+	names := filepath.Join(dir, systemID+"_talkgroups.tsv")
+	meta := filepath.Join(dir, systemID+"_talkgroups_meta.json")
+	modTimes := [2]time.Time{modTime(names), modTime(meta)}
+
+	sys, ok := d.systems[systemID]
+	if ok && sys.modTimes == modTimes {
+		return sys
+	}
+	sys = &systemDirectory{modTimes: modTimes, entries: make(map[int]Entry)}
+	d.systems[systemID] = sys
+
+	if data, err := os.ReadFile(names); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			parts := strings.Split(strings.TrimSpace(line), "\t")
+			if len(parts) < 2 {
+				continue
+			}
+			tgid, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+			if err != nil {
+				continue
+			}
+			e := Entry{Tgid: tgid, Name: strings.Trim(strings.TrimSpace(parts[1]), `"`)}
+			if len(parts) > 2 {
+				e.Priority, _ = strconv.Atoi(strings.TrimSpace(parts[2]))
+			}
+			sys.entries[tgid] = e
+		}
+	}
+	if data, err := os.ReadFile(meta); err == nil {
+		var m map[string]struct {
+			Category string `json:"category"`
+			Tag      string `json:"tag"`
+		}
+		if json.Unmarshal(data, &m) == nil {
+			for key, v := range m {
+				tgid, err := strconv.Atoi(key)
+				if err != nil {
+					continue
+				}
+				e := sys.entries[tgid]
+				e.Tgid, e.Category, e.Tag = tgid, v.Category, v.Tag
+				sys.entries[tgid] = e
+			}
+		}
+	}
+	return sys
+}
+
+func modTime(path string) time.Time {
+	if info, err := os.Stat(path); err == nil {
+		return info.ModTime()
+	}
+	return time.Time{}
+}
@@ -0,0 +1,344 @@
+package talkgroup
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"controller25/pubsub"
+)
+
+// DefaultHangTime is how long a talkgroup may go quiet before its call is
+// considered over.
+const DefaultHangTime = 5 * time.Second
+
+// CallEventType is a step in a call's life.
+type CallEventType string
+
+const (
+	CallStart  CallEventType = "call_start"
+	CallUpdate CallEventType = "call_update"
+	CallEnd    CallEventType = "call_end"
+)
+
+// CallRecord is a call as a listener hears it: the transmissions on one
+// talkgroup until it stays quiet for longer than the hang time.
+type CallRecord struct {
+	ID        string     `json:"id"`
+	Tgid      int        `json:"tgid"`
+	Tag       string     `json:"tag,omitempty"`
+	Srcid     int        `json:"srcid"`   // most recent source
+	Sources   []int      `json:"sources"` // every source heard, in order
+	Frequency int64      `json:"frequency"`
+	Slot      int        `json:"slot"`
+	Emergency bool       `json:"emergency"`
+	Encrypted bool       `json:"encrypted"`
+	Playing   bool       `json:"playing"` // the audio stream is carrying it
+	Start     time.Time  `json:"start"`
+	LastSeen  time.Time  `json:"last_seen"`
+	End       *time.Time `json:"end,omitempty"`
+	Duration  float64    `json:"duration"` // seconds
+}
+
+// CallEvent reports a call starting, changing or ending.
+type CallEvent struct {
+	Type CallEventType `json:"type"`
+	Time time.Time     `json:"time"`
+	Call CallRecord    `json:"call"`
+}
+
+// CallDetector turns snapshots of the calls in progress into call events.
+type CallDetector struct {
+	mu          sync.Mutex
+	hang        time.Duration
+	active      map[int]*CallRecord // by tgid
+	ended       map[int]string      // ID of each talkgroup's last call
+	subscribers map[*pubsub.Queue[CallEvent]]struct{}
+}
+
+func NewCallDetector(hang time.Duration) *CallDetector {
+	if hang <= 0 {
+		hang = DefaultHangTime
+	}
+	return &CallDetector{
+		hang:        hang,
+		active:      make(map[int]*CallRecord),
+		ended:       make(map[int]string),
+		subscribers: make(map[*pubsub.Queue[CallEvent]]struct{}),
+	}
+}
+
+// HangTime returns how long a talkgroup may go quiet mid-call.
+func (d *CallDetector) HangTime() time.Duration {
+	return d.hang
+}
+
+// Observe compares the calls in progress, and the one being played, with
+// the calls already known and emits what changed.
+func (d *CallDetector) Observe(calls []Call, playing *Call) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+
+	// A talkgroup shows up on its old channel for a moment after moving
+	// to a new one; go with the channel it moved to
+	latest := map[int]Call{}
+	if playing != nil {
+		calls = append(calls, *playing)
+	}
+	for _, c := range calls {
+		if c.Tgid == 0 || now.Sub(c.LastSeen) > d.hang {
+			continue
+		}
+		prev, ok := latest[c.Tgid]
+		if !ok || c.Start.After(prev.Start) || (c.Start.Equal(prev.Start) && c.LastSeen.After(prev.LastSeen)) {
+			if ok && c.Srcid == 0 {
+				c.Srcid = prev.Srcid
+			}
+			latest[c.Tgid] = c
+		}
+	}
+
+	tgids := make([]int, 0, len(latest))
+	for tgid := range latest {
+		tgids = append(tgids, tgid)
+	}
+	sort.Ints(tgids)
+	for _, tgid := range tgids {
+		c := latest[tgid]
+		isPlaying := playing != nil && playing.Tgid == tgid
+		rec, ok := d.active[tgid]
+		if !ok {
+			// A call kept alive by updates after it was ended is a new call,
+			// not the old one again
+			id := fmt.Sprintf("%d-%d", tgid, c.Start.UnixMilli())
+			if d.ended[tgid] == id {
+				c.Start = c.LastSeen
+				id = fmt.Sprintf("%d-%d", tgid, c.Start.UnixMilli())
+			}
+			rec = &CallRecord{
+				ID:        id,
+				Tgid:      tgid,
+				Tag:       c.Tag,
+				Srcid:     c.Srcid,
+				Sources:   []int{},
+				Frequency: c.Frequency,
+				Slot:      c.Slot,
+				Emergency: c.Emergency,
+				Encrypted: c.Encrypted,
+				Playing:   isPlaying,
+				Start:     c.Start,
+				LastSeen:  c.LastSeen,
+			}
+			if c.Srcid > 0 {
+				rec.Sources = append(rec.Sources, c.Srcid)
+			}
+			d.active[tgid] = rec
+			d.publishLocked(CallStart, rec, now)
+			continue
+		}
+
+		changed := false
+		if c.Srcid > 0 && c.Srcid != rec.Srcid {
+			rec.Srcid = c.Srcid
+			if !slices.Contains(rec.Sources, c.Srcid) {
+				rec.Sources = append(rec.Sources, c.Srcid)
+			}
+			changed = true
+		}
+		if c.Frequency != 0 && (c.Frequency != rec.Frequency || c.Slot != rec.Slot) {
+			rec.Frequency, rec.Slot = c.Frequency, c.Slot
+			changed = true
+		}
+		if c.Tag != "" && c.Tag != rec.Tag {
+			rec.Tag = c.Tag
+			changed = true
+		}
+		// Flags stick for the rest of the call
+		if c.Emergency && !rec.Emergency {
+			rec.Emergency = true
+			changed = true
+		}
+		if c.Encrypted && !rec.Encrypted {
+			rec.Encrypted = true
+			changed = true
+		}
+		if isPlaying != rec.Playing {
+			rec.Playing = isPlaying
+			changed = true
+		}
+		if c.LastSeen.After(rec.LastSeen) {
+			rec.LastSeen = c.LastSeen
+		}
+		if changed {
+			d.publishLocked(CallUpdate, rec, now)
+		}
+	}
+
+	for tgid, rec := range d.active {
+		if _, ok := latest[tgid]; !ok && now.Sub(rec.LastSeen) > d.hang {
+			d.endLocked(rec, now)
+		}
+	}
+}
+
+// EndAll ends every call in progress, e.g. when OP25 stops, and returns
+// them in the order their call_end events were sent.
+func (d *CallDetector) EndAll() []CallRecord {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	ended := []CallRecord{}
+	for _, rec := range d.active {
+		d.endLocked(rec, now)
+		ended = append(ended, snapshot(rec, now))
+	}
+	return ended
+}
+
+// Active returns the calls in progress, oldest first.
+func (d *CallDetector) Active() []CallRecord {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.activeLocked()
+}
+
+func (d *CallDetector) activeLocked() []CallRecord {
+	list := []CallRecord{}
+	for _, rec := range d.active {
+		list = append(list, snapshot(rec, time.Now()))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Start.Before(list[j].Start) })
+	return list
+}
+
+// Subscribe returns a channel receiving every call event from now on and a
+// function to unsubscribe, which closes the channel. No event is dropped,
+// however slow the reader: the recorder and the call log rely on seeing
+// every call end.
+func (d *CallDetector) Subscribe() (<-chan CallEvent, func()) {
+	q := pubsub.NewQueue[CallEvent]()
+	d.mu.Lock()
+	d.subscribers[q] = struct{}{}
+	d.mu.Unlock()
+	return q.C(), func() {
+		d.mu.Lock()
+		delete(d.subscribers, q)
+		d.mu.Unlock()
+		q.Close()
+	}
+}
+
+// maxClientBacklog is how many events an SSE client may fall behind by
+// before it is cut off. Reconnecting, it starts over from the calls in
+// progress.
+const maxClientBacklog = 1024
+
+// ServeEvents streams call events as Server-Sent Events. Calls already in
+// progress are sent first as call_start so clients don't have to poll
+// /api/talkgroup. A client sees every event after those, or is
+// disconnected once too far behind.
+func (d *CallDetector) ServeEvents(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	q := pubsub.NewBoundedQueue[CallEvent](maxClientBacklog)
+	d.mu.Lock()
+	current := d.activeLocked()
+	d.subscribers[q] = struct{}{}
+	d.mu.Unlock()
+	defer func() {
+		d.mu.Lock()
+		delete(d.subscribers, q)
+		d.mu.Unlock()
+		q.Close()
+	}()
+
+	// Cut a client that falls too far behind off even in the middle of a
+	// write it is stuck in
+	rc := http.NewResponseController(w)
+	stop, cut := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(cut)
+		select {
+		case <-q.Done():
+			rc.SetWriteDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+	defer func() {
+		close(stop)
+		<-cut
+	}()
+
+	now := time.Now()
+	for _, rec := range current {
+		writeCallEvent(w, CallEvent{Type: CallStart, Time: now, Call: rec})
+	}
+	flusher.Flush()
+
+	keepalive := time.NewTicker(15 * time.Second)
+	defer keepalive.Stop()
+	notify := r.Context().Done()
+	for {
+		select {
+		case e, ok := <-q.C():
+			if !ok {
+				return
+			}
+			writeCallEvent(w, e)
+			flusher.Flush()
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+			flusher.Flush()
+		case <-notify:
+			return
+		}
+	}
+}
+
+func (d *CallDetector) endLocked(rec *CallRecord, now time.Time) {
+	delete(d.active, rec.Tgid)
+	d.ended[rec.Tgid] = rec.ID
+	end := rec.LastSeen
+	rec.End = &end
+	rec.Playing = false
+	d.publishLocked(CallEnd, rec, now)
+}
+
+// publishLocked sends an event to every subscriber. Caller must hold d.mu.
+func (d *CallDetector) publishLocked(t CallEventType, rec *CallRecord, now time.Time) {
+	e := CallEvent{Type: t, Time: now, Call: snapshot(rec, now)}
+	for q := range d.subscribers {
+		q.Push(e)
+	}
+}
+
+// snapshot copies rec with its duration so far.
+func snapshot(rec *CallRecord, now time.Time) CallRecord {
+	c := *rec
+	c.Sources = append([]int{}, rec.Sources...)
+	end := now
+	if rec.End != nil {
+		end = *rec.End
+	}
+	c.Duration = end.Sub(rec.Start).Seconds()
+	return c
+}
+
+func writeCallEvent(w http.ResponseWriter, e CallEvent) {
+	data, _ := json.Marshal(e)
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+}
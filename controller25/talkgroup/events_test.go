@@ -0,0 +1,187 @@
+package talkgroup
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A subscriber that doesn't read for a while still gets every event,
+// ends included, once it does.
+func TestSubscribeLossless(t *testing.T) {
+	d := NewCallDetector(time.Second)
+	events, unsubscribe := d.Subscribe()
+
+	const n = 500 // far beyond any channel buffer
+	now := time.Now()
+	var calls []Call
+	for tgid := 1; tgid <= n; tgid++ {
+		calls = append(calls, Call{Tgid: tgid, Srcid: 100 + tgid, Start: now, LastSeen: now})
+	}
+	d.Observe(calls, nil)
+	d.EndAll()
+
+	starts, ends := 0, 0
+	timeout := time.After(5 * time.Second)
+	for starts+ends < 2*n {
+		select {
+		case e := <-events:
+			switch e.Type {
+			case CallStart:
+				starts++
+			case CallEnd:
+				ends++
+			}
+		case <-timeout:
+			t.Fatalf("got %d starts and %d ends, want %d of each", starts, ends, n)
+		}
+	}
+
+	unsubscribe()
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Fatal("event after unsubscribe")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("channel not closed on unsubscribe")
+	}
+}
+
+// sseClient connects to the call event stream and returns its events as
+// they arrive; the channel closes when the server ends the stream.
+func sseClient(t *testing.T, url string) <-chan CallEvent {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	events := make(chan CallEvent)
+	go func() {
+		defer close(events)
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+			if !ok {
+				continue
+			}
+			var e CallEvent
+			if err := json.Unmarshal([]byte(data), &e); err != nil {
+				t.Error(err)
+				return
+			}
+			events <- e
+		}
+	}()
+	return events
+}
+
+// observeCalls starts calls on talkgroups from up to to, then ends every
+// call.
+func observeCalls(d *CallDetector, from, to int) {
+	now := time.Now()
+	var calls []Call
+	for tgid := from; tgid < to; tgid++ {
+		calls = append(calls, Call{Tgid: tgid, Start: now, LastSeen: now})
+	}
+	d.Observe(calls, nil)
+	d.EndAll()
+}
+
+// A client that reads slowly gets the calls in progress and then every
+// event, in order.
+func TestServeEventsSlowClient(t *testing.T) {
+	d := NewCallDetector(time.Second)
+	now := time.Now()
+	d.Observe([]Call{{Tgid: 1, Start: now, LastSeen: now}}, nil)
+	srv := httptest.NewServer(http.HandlerFunc(d.ServeEvents))
+	t.Cleanup(srv.Close) // after the client has hung up
+	events := sseClient(t, srv.URL)
+
+	if e := <-events; e.Type != CallStart || e.Call.Tgid != 1 {
+		t.Fatalf("first event %s %d, want the call in progress", e.Type, e.Call.Tgid)
+	}
+	observeCalls(d, 2, 200)
+
+	var got []string
+	timeout := time.After(5 * time.Second)
+	for len(got) < 1+2*198 {
+		time.Sleep(time.Millisecond)
+		select {
+		case e, ok := <-events:
+			if !ok {
+				t.Fatalf("disconnected after %d events", len(got))
+			}
+			got = append(got, fmt.Sprintf("%s %d", e.Type, e.Call.Tgid))
+		case <-timeout:
+			t.Fatalf("got %d events, want %d", len(got), 1+2*198)
+		}
+	}
+	// The starts in talkgroup order, then every call's end
+	ends := map[string]bool{}
+	for i, e := range got {
+		if i < 198 {
+			if want := fmt.Sprintf("call_start %d", i+2); e != want {
+				t.Fatalf("event %d is %s, want %s", i, e, want)
+			}
+		} else {
+			ends[e] = true
+		}
+	}
+	for tgid := 1; tgid < 200; tgid++ {
+		if !ends[fmt.Sprintf("call_end %d", tgid)] {
+			t.Errorf("no end for talkgroup %d", tgid)
+		}
+	}
+}
+
+// A client that stops reading doesn't hold the detector up, and is cut off
+// once too far behind rather than queueing events without end.
+func TestServeEventsStalledClient(t *testing.T) {
+	d := NewCallDetector(time.Second)
+	srv := httptest.NewServer(http.HandlerFunc(d.ServeEvents))
+	t.Cleanup(srv.Close) // after the client has hung up
+	events := sseClient(t, srv.URL)
+
+	const n = 50000 // far more than fits in the socket buffers
+	done := make(chan struct{})
+	go func() {
+		observeCalls(d, 1, 1+n)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("a stalled client blocked the detector")
+	}
+
+	// Catching up, the client finds the stream cut short
+	count := 0
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case _, ok := <-events:
+			if !ok {
+				if count >= 2*n {
+					t.Errorf("got all %d events, want the stream cut off", count)
+				}
+				d.mu.Lock()
+				left := len(d.subscribers)
+				d.mu.Unlock()
+				if left != 0 {
+					t.Errorf("%d subscribers left after the client was cut off", left)
+				}
+				return
+			}
+			count++
+		case <-timeout:
+			t.Fatalf("stream still open after %d events", count)
+		}
+	}
+}
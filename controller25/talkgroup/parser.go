@@ -1,6 +1,7 @@
 package talkgroup
 
 import (
+	"math"
 	"regexp"
 	"strconv"
 	"sync"
@@ -13,6 +14,7 @@ type TalkgroupInfo struct {
 	Frequency    string    `json:"frequency"`
 	LastUpdate   time.Time `json:"last_update"`
 	Active       bool      `json:"active"`
+	Tag          string    `json:"tag,omitempty"` // talkgroup alias, when OP25 reports it
 }
 
 func (t *TalkgroupInfo) GetTgid() int {
@@ -26,29 +28,85 @@ func (t *TalkgroupInfo) GetSrcid() int {
 type Parser struct {
 	mu              sync.RWMutex
 	activeTalkgroup *TalkgroupInfo
+	activeSince     time.Time
 	controlChannel  string
+	calls           callSet
+	hangTime        time.Duration // how long a talkgroup outlives its last activity
 	
 	// Regex patterns
 	tgidRegex   *regexp.Regexp
 	srcRegex    *regexp.Regexp
 	freqRegex   *regexp.Regexp
 	ccRegex     *regexp.Regexp
+	grantRegex  *regexp.Regexp
+	voiceRegex  *regexp.Regexp
+	releaseRegex *regexp.Regexp
+	emergencyRegex *regexp.Regexp
 }
 
 func NewParser() *Parser {
 	return &Parser{
+		calls:     newCallSet(),
+		hangTime:  DefaultHangTime,
 		tgidRegex: regexp.MustCompile(`tgid[=:]?\s*(\d+)`),
 		srcRegex:  regexp.MustCompile(`(?:src|source|srcaddr)[=:]?\s*(\d+)`),
 		freqRegex: regexp.MustCompile(`freq[=:]?\s*([\d.]+)`),
 		ccRegex:   regexp.MustCompile(`(?i)(?:control|tracking).*?([\d.]+)\s*(?:MHz|Hz)?`),
+		grantRegex:   regexp.MustCompile(`grp_v_ch_grant: freq ([\d.]+) tgid (\d+)`),
+		voiceRegex:   regexp.MustCompile(`voice update:\s+tg\((\d+)\), freq\((\d+)\), slot\((\d+)\)`),
+		releaseRegex: regexp.MustCompile(`releasing:\s+tg\((\d+)\), freq\((\d+)\), slot\((\d+)\)`),
+		emergencyRegex: regexp.MustCompile(`(?i)\bemergency\b`),
 	}
 }
 
+// SetHangTime sets how long a talkgroup stays active after its last
+// activity. Zero restores DefaultHangTime.
+func (p *Parser) SetHangTime(d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if d <= 0 {
+		d = DefaultHangTime
+	}
+	p.hangTime = d
+}
+
 // ParseLine processes a log line and extracts talkgroup information
 func (p *Parser) ParseLine(line string) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	
+	// Grants and voice channel updates describe every call on the system,
+	// not just the one being decoded, so they only feed the call list
+	if match := p.voiceRegex.FindStringSubmatch(line); match != nil {
+		tgid, _ := strconv.Atoi(match[1])
+		freq, _ := strconv.ParseInt(match[2], 10, 64)
+		slot, _ := strconv.Atoi(match[3])
+		key := CallKey{Frequency: freq, Slot: slot}
+		p.calls.rekey(CallKey{Frequency: freq}, key, tgid)
+		c := p.calls.touch(key, tgid, 0, "", time.Now())
+		c.Emergency = c.Emergency || p.emergencyRegex.MatchString(line)
+		return
+	}
+	if match := p.grantRegex.FindStringSubmatch(line); match != nil {
+		mhz, _ := strconv.ParseFloat(match[1], 64)
+		tgid, _ := strconv.Atoi(match[2])
+		key := CallKey{Frequency: int64(math.Round(mhz * 1e6))}
+		// The grant doesn't carry the slot; a following voice update does
+		if c := p.calls.latest(tgid); c == nil || c.Frequency != key.Frequency {
+			p.calls.touch(key, tgid, 0, "", time.Now())
+		}
+		if p.emergencyRegex.MatchString(line) {
+			p.calls.latest(tgid).Emergency = true
+		}
+		return
+	}
+	if match := p.releaseRegex.FindStringSubmatch(line); match != nil {
+		freq, _ := strconv.ParseInt(match[2], 10, 64)
+		slot, _ := strconv.Atoi(match[3])
+		p.calls.end(CallKey{Frequency: freq, Slot: slot})
+		return
+	}
+	
 	// Extract talkgroup ID
 	if match := p.tgidRegex.FindStringSubmatch(line); match != nil {
 		tgid, _ := strconv.Atoi(match[1])
@@ -65,6 +123,16 @@ func (p *Parser) ParseLine(line string) {
 			freq = freqMatch[1]
 		}
 		
+		if p.activeTalkgroup == nil || p.activeTalkgroup.Tgid != tgid {
+			p.activeSince = time.Now()
+		}
+		if c := p.calls.latest(tgid); c != nil {
+			if srcid > 0 {
+				c.Srcid = srcid
+			}
+			c.LastSeen = time.Now()
+		}
+		
 		// Update or create active talkgroup
 		if p.activeTalkgroup == nil || p.activeTalkgroup.Tgid != tgid || (srcid > 0 && p.activeTalkgroup.Srcid != srcid) {
 			p.activeTalkgroup = &TalkgroupInfo{
@@ -105,7 +173,7 @@ func (p *Parser) GetActiveTalkgroup() interface{
 	}
 	
 	// Check if talkgroup has expired (5 seconds of inactivity)
-	if time.Since(p.activeTalkgroup.LastUpdate) > 5*time.Second {
+	if time.Since(p.activeTalkgroup.LastUpdate) > p.hangTime {
 		return nil
 	}
 	
@@ -123,7 +191,7 @@ func (p *Parser) GetActiveTalkgroupData() *TalkgroupInfo {
 	}
 	
 	// Check if talkgroup has expired (5 seconds of inactivity)
-	if time.Since(p.activeTalkgroup.LastUpdate) > 5*time.Second {
+	if time.Since(p.activeTalkgroup.LastUpdate) > p.hangTime {
 		return nil
 	}
 	
@@ -144,7 +212,42 @@ func (p *Parser) ClearExpired() {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	
-	if p.activeTalkgroup != nil && time.Since(p.activeTalkgroup.LastUpdate) > 5*time.Second {
+	if p.activeTalkgroup != nil && time.Since(p.activeTalkgroup.LastUpdate) > p.hangTime {
 		p.activeTalkgroup = nil
 	}
+	p.calls.expire(p.hangTime)
+}
+
+// Calls returns the calls in progress, including the one being decoded
+func (p *Parser) Calls() []Call {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	
+	calls := p.calls.list(p.hangTime)
+	if playing := p.playingLocked(); playing != nil && p.calls.latest(playing.Tgid) == nil {
+		calls = append(calls, *playing)
+	}
+	return calls
+}
+
+// emergency reports whether OP25 logged tgid's current call as an emergency
+func (p *Parser) emergency(tgid int) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	c := p.calls.latest(tgid)
+	return c != nil && c.Emergency
+}
+
+// Playing returns the call the audio stream is carrying, or nil
+func (p *Parser) Playing() *Call {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.playingLocked()
+}
+
+func (p *Parser) playingLocked() *Call {
+	if p.activeTalkgroup == nil || time.Since(p.activeTalkgroup.LastUpdate) > p.hangTime {
+		return nil
+	}
+	return p.calls.playing(p.activeTalkgroup, p.activeSince)
 }
@@ -0,0 +1,72 @@
+package talkgroup
+
+// Source answers talkgroup questions from the terminal tracker while it is
+// live and falls back to the log parser otherwise, e.g. when OP25 was
+// started without a reachable terminal.
+type Source struct {
+	Tracker *Tracker
+	Parser  *Parser
+}
+
+func NewSource(tracker *Tracker, parser *Parser) *Source {
+	return &Source{Tracker: tracker, Parser: parser}
+}
+
+// Name reports where answers currently come from: "terminal" or "log".
+func (s *Source) Name() string {
+	if s.Tracker.Live() {
+		return "terminal"
+	}
+	return "log"
+}
+
+func (s *Source) GetActiveTalkgroup() interface {
+	GetTgid() int
+	GetSrcid() int
+} {
+	if tg := s.GetActiveTalkgroupData(); tg != nil {
+		return tg
+	}
+	return nil
+}
+
+func (s *Source) GetActiveTalkgroupData() *TalkgroupInfo {
+	if s.Tracker.Live() {
+		return s.Tracker.GetActiveTalkgroupData()
+	}
+	return s.Parser.GetActiveTalkgroupData()
+}
+
+// Calls returns the calls in progress on the system.
+func (s *Source) Calls() []Call {
+	if s.Tracker.Live() {
+		// The terminal doesn't report emergencies; the log does
+		calls := s.Tracker.Calls()
+		for i := range calls {
+			calls[i].Emergency = s.Parser.emergency(calls[i].Tgid)
+		}
+		return calls
+	}
+	return s.Parser.Calls()
+}
+
+// Playing returns the call the audio stream is carrying, or nil.
+func (s *Source) Playing() *Call {
+	if s.Tracker.Live() {
+		c := s.Tracker.Playing()
+		if c != nil {
+			c.Emergency = s.Parser.emergency(c.Tgid)
+		}
+		return c
+	}
+	return s.Parser.Playing()
+}
+
+func (s *Source) GetControlChannel() string {
+	if s.Tracker.Live() {
+		if cc := s.Tracker.GetControlChannel(); cc != "" {
+			return cc
+		}
+	}
+	return s.Parser.GetControlChannel()
+}
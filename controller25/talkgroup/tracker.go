@@ -0,0 +1,402 @@
+package talkgroup
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"controller25/terminal"
+)
+
+// staleAfter is how long terminal data is trusted without a fresh update.
+const staleAfter = 5 * time.Second
+
+// callIdleAfter is how long after its last activity trunk_update's
+// frequency_data still counts a voice channel as carrying a call.
+const callIdleAfter = 2.0 // seconds
+
+// SystemState is what OP25 reports about the system it is following.
+type SystemState struct {
+	Name           string         `json:"name"`
+	Nac            int            `json:"nac"`
+	Wacn           int            `json:"wacn"`
+	Sysid          int            `json:"sysid"`
+	Rfss           int            `json:"rfss"`
+	Site           int            `json:"site"`
+	ControlChannel int64          `json:"control_channel"` // Hz
+	VoiceChannels  []VoiceChannel `json:"voice_channels"`
+	UpdatedAt      time.Time      `json:"updated_at"`
+}
+
+// VoiceChannel is one voice frequency, or one slot of a TDMA frequency.
+type VoiceChannel struct {
+	Frequency int64  `json:"frequency"` // Hz
+	Slot      *int   `json:"slot"`      // TDMA slot, nil for FDMA
+	Tgid      int    `json:"tgid"`
+	Tag       string `json:"tag"`
+	Srcid     int    `json:"srcid"`
+	SrcTag    string `json:"src_tag,omitempty"`
+	// Seconds since OP25 last saw activity, when it reports it
+	LastActivity *float64 `json:"last_activity,omitempty"`
+}
+
+// Tracker keeps OP25 state decoded from the terminal's trunk_update,
+// channel_update and change_freq messages.
+type Tracker struct {
+	mu       sync.RWMutex
+	system   *SystemState
+	active   *TalkgroupInfo
+	since    time.Time // when the active talkgroup last changed
+	lastSeen time.Time // last message that carried state
+	// channel_update has per-slot detail, so trunk_update doesn't
+	// overwrite its voice channels while it keeps coming
+	channelsAt time.Time
+	calls      callSet
+	tuned      map[CallKey]bool // channels in the last channel_update
+	lastErr    error
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{calls: newCallSet()}
+}
+
+// Update applies one terminal reply.
+func (t *Tracker) Update(msgs []terminal.Message) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lastErr = nil
+	for _, m := range msgs {
+		switch m.Type {
+		case terminal.TypeTrunkUpdate:
+			if tu, err := m.TrunkUpdate(); err == nil {
+				t.applyTrunkUpdate(tu)
+			}
+		case terminal.TypeChannelUpdate:
+			if cu, err := m.ChannelUpdate(); err == nil {
+				t.applyChannelUpdate(cu)
+			}
+		case terminal.TypeChangeFreq:
+			if cf, err := m.ChangeFreq(); err == nil {
+				t.applyChangeFreq(cf)
+			}
+		}
+	}
+}
+
+// Fail records a failed poll. State goes stale on its own.
+func (t *Tracker) Fail(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lastErr = err
+}
+
+// Reset forgets everything, e.g. when OP25 stops.
+func (t *Tracker) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.system = nil
+	t.active = nil
+	t.since = time.Time{}
+	t.lastSeen = time.Time{}
+	t.channelsAt = time.Time{}
+	t.calls.clear()
+	t.tuned = nil
+	t.lastErr = nil
+}
+
+// Live reports whether the terminal delivered state recently.
+func (t *Tracker) Live() bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.liveLocked()
+}
+
+func (t *Tracker) liveLocked() bool {
+	return !t.lastSeen.IsZero() && time.Since(t.lastSeen) < staleAfter
+}
+
+// State returns a copy of the system state, or nil if there is none or it
+// is stale.
+func (t *Tracker) State() *SystemState {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.system == nil || !t.liveLocked() {
+		return nil
+	}
+	s := *t.system
+	s.VoiceChannels = append([]VoiceChannel(nil), t.system.VoiceChannels...)
+	return &s
+}
+
+// GetActiveTalkgroup mirrors Parser.GetActiveTalkgroup.
+func (t *Tracker) GetActiveTalkgroup() interface {
+	GetTgid() int
+	GetSrcid() int
+} {
+	if tg := t.GetActiveTalkgroupData(); tg != nil {
+		return tg
+	}
+	return nil
+}
+
+// GetActiveTalkgroupData mirrors Parser.GetActiveTalkgroupData.
+func (t *Tracker) GetActiveTalkgroupData() *TalkgroupInfo {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.active == nil || time.Since(t.active.LastUpdate) > staleAfter {
+		return nil
+	}
+	tg := *t.active
+	return &tg
+}
+
+// Calls returns the calls in progress on the system's voice channels.
+func (t *Tracker) Calls() []Call {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if !t.liveLocked() {
+		return []Call{}
+	}
+	return t.calls.list(staleAfter)
+}
+
+// Playing returns the call the audio stream is carrying, or nil.
+func (t *Tracker) Playing() *Call {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.active == nil || time.Since(t.active.LastUpdate) > staleAfter {
+		return nil
+	}
+	return t.calls.playing(t.active, t.since)
+}
+
+// GetControlChannel returns the control channel in MHz, formatted like
+// the log parser's.
+func (t *Tracker) GetControlChannel() string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.system == nil || t.system.ControlChannel == 0 {
+		return ""
+	}
+	return formatMHz(t.system.ControlChannel)
+}
+
+func (t *Tracker) applyTrunkUpdate(tu *terminal.TrunkUpdate) {
+	// OP25 can follow several systems; the top-level nac says which one
+	// the current call is on
+	sys, ok := tu.Systems[tu.Nac]
+	nac := tu.Nac
+	if !ok {
+		for n, s := range tu.Systems {
+			if sys == nil || n < nac {
+				sys, nac = s, n
+			}
+		}
+	}
+	if sys == nil {
+		return
+	}
+
+	now := time.Now()
+	state := &SystemState{
+		Name:           sys.SystemName,
+		Nac:            nac,
+		Wacn:           sys.Wacn,
+		Sysid:          sys.Sysid,
+		Rfss:           sys.Rfid,
+		Site:           sys.Stid,
+		ControlChannel: sys.RxChan,
+		UpdatedAt:      now,
+	}
+	channels := voiceChannels(sys.FrequencyData)
+	if t.system != nil && time.Since(t.channelsAt) < staleAfter {
+		state.VoiceChannels = t.system.VoiceChannels
+	} else {
+		state.VoiceChannels = channels
+	}
+	t.system = state
+	t.lastSeen = now
+
+	// frequency_data covers every voice channel, so it is the snapshot of
+	// calls on the system; channels we are tuned to come from
+	// channel_update and stay until it says otherwise
+	keep := map[CallKey]bool{}
+	for key := range t.tuned {
+		keep[key] = true
+	}
+	for _, vc := range channels {
+		if vc.Tgid == 0 || (vc.LastActivity != nil && *vc.LastActivity > callIdleAfter) {
+			continue
+		}
+		key := CallKey{Frequency: vc.Frequency, Slot: max(slotOf(vc), 0)}
+		t.calls.touch(key, vc.Tgid, vc.Srcid, vc.Tag, now)
+		keep[key] = true
+	}
+	t.calls.retain(keep)
+
+	if tu.Grpaddr != 0 {
+		t.setActive(tu.Grpaddr, tu.Srcaddr, 0, "", now)
+		if c := t.calls.latest(tu.Grpaddr); c != nil {
+			c.Encrypted = tu.Encrypted != 0
+		}
+	}
+}
+
+func (t *Tracker) applyChannelUpdate(cu *terminal.ChannelUpdate) {
+	now := time.Now()
+	if t.system == nil {
+		t.system = &SystemState{}
+	}
+	var channels []VoiceChannel
+	tuned := map[CallKey]bool{}
+	ids := make([]string, 0, len(cu.Channels))
+	for id := range cu.Channels {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		ch := cu.Channels[id]
+		vc := VoiceChannel{
+			Frequency: ch.Freq,
+			Slot:      ch.Tdma,
+			Tag:       ch.Tag,
+			SrcTag:    ch.Srctag,
+		}
+		if ch.Tgid != nil {
+			vc.Tgid = *ch.Tgid
+		}
+		if ch.Srcaddr != nil {
+			vc.Srcid = *ch.Srcaddr
+		}
+		if t.system.Name == "" {
+			t.system.Name = ch.System
+		}
+		channels = append(channels, vc)
+		key := CallKey{Frequency: vc.Frequency, Slot: max(slotOf(vc), 0)}
+		if vc.Tgid != 0 {
+			c := t.calls.touch(key, vc.Tgid, vc.Srcid, vc.Tag, now)
+			c.Encrypted = ch.Encrypted != 0
+			tuned[key] = true
+		} else {
+			t.calls.end(key)
+		}
+		if vc.Tgid != 0 && (t.active == nil || t.active.Tgid == vc.Tgid || time.Since(t.active.LastUpdate) > staleAfter) {
+			t.setActive(vc.Tgid, vc.Srcid, vc.Frequency, vc.Tag, now)
+		}
+	}
+	for key := range t.tuned {
+		if !tuned[key] {
+			t.calls.end(key)
+		}
+	}
+	t.tuned = tuned
+	t.system.VoiceChannels = channels
+	t.system.UpdatedAt = now
+	t.channelsAt = now
+	t.lastSeen = now
+}
+
+func (t *Tracker) applyChangeFreq(cf *terminal.ChangeFreq) {
+	if cf.Tgid == nil || *cf.Tgid == 0 {
+		return
+	}
+	srcid := 0
+	if cf.Srcaddr != nil {
+		srcid = *cf.Srcaddr
+	}
+	slot := 0
+	if cf.Tdma != nil {
+		slot = *cf.Tdma
+	}
+	now := time.Now()
+	c := t.calls.touch(CallKey{Frequency: cf.Freq, Slot: slot}, *cf.Tgid, srcid, cf.Tag, now)
+	c.Encrypted = cf.Encrypted != 0
+	t.setActive(*cf.Tgid, srcid, cf.Freq, cf.Tag, now)
+}
+
+// setActive updates the active talkgroup, keeping details a sparser
+// message doesn't carry.
+func (t *Tracker) setActive(tgid, srcid int, freq int64, tag string, now time.Time) {
+	if t.active == nil || t.active.Tgid != tgid {
+		t.active = &TalkgroupInfo{Tgid: tgid, Active: true}
+		t.since = now
+	}
+	if srcid > 0 {
+		t.active.Srcid = srcid
+	}
+	if freq > 0 {
+		t.active.Frequency = formatMHz(freq)
+	}
+	if tag != "" {
+		t.active.Tag = tag
+	}
+	t.active.LastUpdate = now
+}
+
+// voiceChannels flattens trunk_update frequency_data. TDMA frequencies
+// list one talkgroup per slot.
+func voiceChannels(data map[string]*terminal.FrequencyData) []VoiceChannel {
+	var channels []VoiceChannel
+	for key, fd := range data {
+		if fd == nil || (fd.Type != "" && fd.Type != "voice") {
+			continue
+		}
+		freq, err := strconv.ParseInt(key, 10, 64)
+		if err != nil {
+			continue
+		}
+		var lastActivity *float64
+		if v, err := strconv.ParseFloat(fd.LastActivity, 64); err == nil {
+			lastActivity = &v
+		}
+		tdma := len(fd.Tgids) > 1
+		for i, tgid := range fd.Tgids {
+			vc := VoiceChannel{Frequency: freq, LastActivity: lastActivity}
+			if tdma {
+				slot := i
+				vc.Slot = &slot
+			}
+			if tgid != nil {
+				vc.Tgid = *tgid
+			}
+			if i < len(fd.Tags) {
+				vc.Tag = fd.Tags[i]
+			}
+			if i < len(fd.Srcaddrs) && fd.Srcaddrs[i] != nil {
+				vc.Srcid = *fd.Srcaddrs[i]
+			}
+			if i < len(fd.Srctags) {
+				vc.SrcTag = fd.Srctags[i]
+			}
+			channels = append(channels, vc)
+		}
+	}
+	sort.Slice(channels, func(i, j int) bool {
+		if channels[i].Frequency != channels[j].Frequency {
+			return channels[i].Frequency < channels[j].Frequency
+		}
+		return slotOf(channels[i]) < slotOf(channels[j])
+	})
+	return channels
+}
+
+func slotOf(vc VoiceChannel) int {
+	if vc.Slot == nil {
+		return -1
+	}
+	return *vc.Slot
+}
+
+func formatMHz(hz int64) string {
+	return fmt.Sprintf("%.6f", float64(hz)/1e6)
+}
+
+// LastError returns the error from the most recent failed poll, or nil if
+// the last poll succeeded.
+func (t *Tracker) LastError() error {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.lastErr
+}
@@ -0,0 +1,139 @@
+// Package terminal talks to the HTTP terminal rx.py serves when started with
+// -l http:<host>:<port>. The boatbod terminal takes a POSTed JSON array of
+// commands and answers with a JSON array of whatever messages OP25 queued
+// for the terminal since the last request (trunk_update, change_freq, ...).
+package terminal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Command names understood by rx.py's terminal queue.
+const (
+	CmdUpdate    = "update"
+	CmdHold      = "hold"
+	CmdSkip      = "skip"
+	CmdLockout   = "lockout"
+	CmdSetFreq   = "set_freq"
+	CmdWhitelist = "whitelist"
+	CmdReload    = "reload"
+)
+
+// Command is one entry of the POSTed array. rx.py turns it into a gr.message
+// with arg1/arg2 as its integer arguments.
+type Command struct {
+	Command string `json:"command"`
+	Arg1    int64  `json:"arg1"`
+	Arg2    int64  `json:"arg2"`
+}
+
+// Client sends commands to one rx.py terminal.
+type Client struct {
+	url  string
+	http *http.Client
+}
+
+// NewClient returns a client for the terminal at addr, e.g. "127.0.0.1:8080".
+func NewClient(addr string) *Client {
+	return &Client{
+		url:  "http://" + addr + "/",
+		http: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// Send posts cmds in one request and returns the messages OP25 replied with.
+func (c *Client) Send(ctx context.Context, cmds ...Command) ([]Message, error) {
+	body, err := json.Marshal(cmds)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("terminal request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read terminal response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("terminal returned %s", resp.Status)
+	}
+	return ParseMessages(data)
+}
+
+// Update asks OP25 for fresh trunk and channel state.
+func (c *Client) Update(ctx context.Context) ([]Message, error) {
+	return c.Send(ctx, Command{Command: CmdUpdate})
+}
+
+// Hold locks the receiver onto tgid. A tgid of 0 toggles: it holds the
+// active talkgroup, or releases the hold if one is set.
+func (c *Client) Hold(ctx context.Context, tgid int) error {
+	_, err := c.Send(ctx, Command{Command: CmdHold, Arg1: int64(tgid)})
+	return err
+}
+
+// Skip drops the current call and resumes scanning.
+func (c *Client) Skip(ctx context.Context) error {
+	_, err := c.Send(ctx, Command{Command: CmdSkip})
+	return err
+}
+
+// Lockout blacklists tgid for the running session. A tgid of 0 locks out
+// the active talkgroup.
+func (c *Client) Lockout(ctx context.Context, tgid int) error {
+	_, err := c.Send(ctx, Command{Command: CmdLockout, Arg1: int64(tgid)})
+	return err
+}
+
+// SetFreq retunes a conventional (non-trunked) receiver to hz.
+func (c *Client) SetFreq(ctx context.Context, hz int64) error {
+	_, err := c.Send(ctx, Command{Command: CmdSetFreq, Arg1: hz})
+	return err
+}
+
+// Whitelist adds tgid to the session whitelist.
+func (c *Client) Whitelist(ctx context.Context, tgid int) error {
+	_, err := c.Send(ctx, Command{Command: CmdWhitelist, Arg1: int64(tgid)})
+	return err
+}
+
+// Reload makes OP25 re-read its whitelist and blacklist files.
+func (c *Client) Reload(ctx context.Context) error {
+	_, err := c.Send(ctx, Command{Command: CmdReload})
+	return err
+}
+
+// Poll sends update every interval until ctx is done and hands each reply
+// message to fn. Errors are passed to fn as well so the caller can tell a
+// dead terminal from a quiet one; polling continues regardless.
+func (c *Client) Poll(ctx context.Context, interval time.Duration, fn func([]Message, error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		msgs, err := c.Update(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		fn(msgs, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
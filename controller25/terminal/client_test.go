@@ -0,0 +1,182 @@
+package terminal_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"controller25/terminal"
+	"controller25/terminal/terminaltest"
+)
+
+func TestUpdate(t *testing.T) {
+	srv := terminaltest.NewServer()
+	defer srv.Close()
+	c := terminal.NewClient(srv.Addr())
+	ctx := context.Background()
+
+	// First reply: trunk_update with an active call, then change_freq
+	msgs, err := c.Update(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 2 || msgs[0].Type != terminal.TypeTrunkUpdate || msgs[1].Type != terminal.TypeChangeFreq {
+		t.Fatalf("got %v, want trunk_update and change_freq", types(msgs))
+	}
+	tu, err := msgs[0].TrunkUpdate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tu.Nac != 659 || tu.Grpaddr != 2001 || tu.Srcaddr != 1004378 {
+		t.Errorf("top level: nac %d grpaddr %d srcaddr %d, want 659 2001 1004378", tu.Nac, tu.Grpaddr, tu.Srcaddr)
+	}
+	sys := tu.Systems[659]
+	if sys == nil {
+		t.Fatalf("no system for NAC 659 in %v", tu.Systems)
+	}
+	if sys.SystemName != "Simulated County P25" || sys.Sysid != 929 || sys.Wacn != 781824 || sys.RxChan != 851012500 {
+		t.Errorf("system: %+v", sys)
+	}
+	if len(sys.FrequencyData) != 3 {
+		t.Fatalf("got %d voice channels, want 3", len(sys.FrequencyData))
+	}
+	tdma := sys.FrequencyData["852012500"]
+	if tdma == nil || len(tdma.Tgids) != 2 || *tdma.Tgids[0] != 3001 || *tdma.Tgids[1] != 4005 {
+		t.Errorf("TDMA channel: %+v", tdma)
+	}
+	if fire := sys.FrequencyData["851512500"]; fire == nil || fire.Srcaddrs[0] != nil {
+		t.Errorf("a null srcaddr should decode as nil: %+v", fire)
+	}
+	if _, err := msgs[0].ChangeFreq(); err == nil {
+		t.Error("trunk_update decoded as change_freq")
+	}
+	cf, err := msgs[1].ChangeFreq()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cf.Freq != 851262500 || cf.Tgid == nil || *cf.Tgid != 2001 || cf.Tag != "Sheriff Dispatch" || cf.Tdma != nil {
+		t.Errorf("change_freq: %+v", cf)
+	}
+
+	// Second: channel_update, one TDMA slot per channel
+	msgs, err = c.Update(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 {
+		t.Fatalf("got %v, want channel_update", types(msgs))
+	}
+	cu, err := msgs[0].ChannelUpdate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cu.Channels) != 2 {
+		t.Fatalf("got %d channels, want 2", len(cu.Channels))
+	}
+	ch := cu.Channels["1"]
+	if ch == nil || ch.Freq != 852012500 || *ch.Tdma != 1 || *ch.Tgid != 4005 || *ch.Srcaddr != 1007731 || ch.HoldTgid != nil {
+		t.Errorf("channel 1: %+v", ch)
+	}
+
+	// Third: an idle system, then the replies start over
+	msgs, err = c.Update(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idle, err := msgs[0].TrunkUpdate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idle.Grpaddr != 0 || len(idle.Systems[659].FrequencyData) != 0 {
+		t.Errorf("idle system: %+v", idle)
+	}
+	msgs, err = c.Update(ctx)
+	if err != nil || len(msgs) != 2 {
+		t.Errorf("fourth update: %v %v, want the first reply again", types(msgs), err)
+	}
+}
+
+func TestCommands(t *testing.T) {
+	srv := terminaltest.NewServer()
+	defer srv.Close()
+	c := terminal.NewClient(srv.Addr())
+	ctx := context.Background()
+
+	calls := []func() error{
+		func() error { return c.Hold(ctx, 2001) },
+		func() error { return c.Hold(ctx, 0) },
+		func() error { return c.Skip(ctx) },
+		func() error { return c.Lockout(ctx, 4005) },
+		func() error { return c.SetFreq(ctx, 851262500) },
+		func() error { return c.Whitelist(ctx, 1001) },
+		func() error { return c.Reload(ctx) },
+	}
+	for _, call := range calls {
+		if err := call(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []terminal.Command{
+		{Command: terminal.CmdHold, Arg1: 2001},
+		{Command: terminal.CmdHold},
+		{Command: terminal.CmdSkip},
+		{Command: terminal.CmdLockout, Arg1: 4005},
+		{Command: terminal.CmdSetFreq, Arg1: 851262500},
+		{Command: terminal.CmdWhitelist, Arg1: 1001},
+		{Command: terminal.CmdReload},
+	}
+	if got := srv.Commands(); !reflect.DeepEqual(got, want) {
+		t.Errorf("server got %+v\nwant %+v", got, want)
+	}
+
+	// Commands other than update get an empty queue back
+	msgs, err := c.Send(ctx, terminal.Command{Command: terminal.CmdSkip})
+	if err != nil || len(msgs) != 0 {
+		t.Errorf("skip: %v %v, want no messages", types(msgs), err)
+	}
+}
+
+func TestPoll(t *testing.T) {
+	srv := terminaltest.NewServer()
+	defer srv.Close()
+	c := terminal.NewClient(srv.Addr())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var replies [][]string
+	c.Poll(ctx, 10*time.Millisecond, func(msgs []terminal.Message, err error) {
+		if err != nil {
+			t.Errorf("poll: %v", err)
+		}
+		replies = append(replies, types(msgs))
+		if len(replies) == 3 {
+			cancel()
+		}
+	})
+	want := [][]string{
+		{terminal.TypeTrunkUpdate, terminal.TypeChangeFreq},
+		{terminal.TypeChannelUpdate},
+		{terminal.TypeTrunkUpdate},
+	}
+	if !reflect.DeepEqual(replies, want) {
+		t.Errorf("got %v, want %v", replies, want)
+	}
+}
+
+func TestDeadTerminal(t *testing.T) {
+	srv := terminaltest.NewServer()
+	addr := srv.Addr()
+	srv.Close()
+	if _, err := terminal.NewClient(addr).Update(context.Background()); err == nil {
+		t.Error("no error from a terminal that isn't there")
+	}
+}
+
+func types(msgs []terminal.Message) []string {
+	var list []string
+	for _, m := range msgs {
+		list = append(list, m.Type)
+	}
+	return list
+}
@@ -0,0 +1,220 @@
+package terminal
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// Message types (the json_type field) sent by boatbod OP25.
+const (
+	TypeTrunkUpdate   = "trunk_update"
+	TypeChannelUpdate = "channel_update"
+	TypeChangeFreq    = "change_freq"
+	TypeRxUpdate      = "rx_update"
+)
+
+// Message is one element of a terminal reply. Raw holds the full object so
+// callers can decode types this package doesn't model.
+type Message struct {
+	Type string
+	Raw  json.RawMessage
+}
+
+// ParseMessages splits a terminal reply into its messages.
+func ParseMessages(data []byte) ([]Message, error) {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("invalid terminal response: %v", err)
+	}
+	msgs := make([]Message, 0, len(raw))
+	for _, r := range raw {
+		var head struct {
+			Type string `json:"json_type"`
+		}
+		if err := json.Unmarshal(r, &head); err != nil {
+			// rx.py never sends anything but objects; skip rather than fail
+			continue
+		}
+		msgs = append(msgs, Message{Type: head.Type, Raw: r})
+	}
+	return msgs, nil
+}
+
+// TrunkUpdate is the trunking state of every system OP25 follows. The
+// JSON mixes per-system objects, keyed by NAC in decimal, with a few
+// top-level fields describing the current call.
+type TrunkUpdate struct {
+	Systems   map[int]*TrunkSystem // by NAC
+	Srcaddr   int
+	Grpaddr   int
+	Encrypted int
+	Nac       int
+}
+
+// TrunkSystem is one system/site from a trunk_update.
+type TrunkSystem struct {
+	SystemName    string                    `json:"system"`
+	TopLine       string                    `json:"top_line"`
+	Syid          int                       `json:"syid"`
+	Rfid          int                       `json:"rfid"`
+	Stid          int                       `json:"stid"`
+	Sysid         int                       `json:"sysid"`
+	Wacn          int                       `json:"wacn"`
+	RxChan        int64                     `json:"rxchan"` // control channel, Hz
+	TxChan        int64                     `json:"txchan"`
+	Secondary     []int64                   `json:"secondary"`
+	Tsbks         int64                     `json:"tsbks"`
+	LastTsbk      float64                   `json:"last_tsbk"`
+	Frequencies   map[string]string         `json:"frequencies"`
+	FrequencyData map[string]*FrequencyData `json:"frequency_data"` // by frequency in Hz
+}
+
+// FrequencyData describes one voice channel. TDMA channels carry two
+// entries per list, one per slot.
+type FrequencyData struct {
+	Type         string   `json:"type"`
+	Tgids        []*int   `json:"tgids"`
+	Tags         []string `json:"tags"`
+	Srcaddrs     []*int   `json:"srcaddrs"`
+	Srctags      []string `json:"srctags"`
+	LastActivity string   `json:"last_activity"`
+	Counter      int64    `json:"counter"`
+	Mode         *int     `json:"mode"`
+}
+
+func (t *TrunkUpdate) UnmarshalJSON(data []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	t.Systems = make(map[int]*TrunkSystem)
+	for key, value := range fields {
+		var dst interface{}
+		switch key {
+		case "json_type":
+			continue
+		case "srcaddr":
+			dst = &t.Srcaddr
+		case "grpaddr":
+			dst = &t.Grpaddr
+		case "encrypted":
+			dst = &t.Encrypted
+		case "nac":
+			dst = &t.Nac
+		default:
+			nac, err := strconv.Atoi(key)
+			if err != nil {
+				continue
+			}
+			sys := &TrunkSystem{}
+			t.Systems[nac] = sys
+			dst = sys
+		}
+		// Values are sometimes null while OP25 is still acquiring
+		if err := json.Unmarshal(value, dst); err != nil {
+			return fmt.Errorf("trunk_update %s: %v", key, err)
+		}
+	}
+	return nil
+}
+
+// ChannelUpdate is the per-channel state sent by multi_rx style terminals.
+type ChannelUpdate struct {
+	Channels map[string]*Channel
+}
+
+// Channel is one voice channel (or TDMA slot) from a channel_update.
+type Channel struct {
+	Name      string `json:"name"`
+	System    string `json:"system"`
+	Freq      int64  `json:"freq"`
+	Tdma      *int   `json:"tdma"` // slot, or null for FDMA
+	Tgid      *int   `json:"tgid"`
+	Tag       string `json:"tag"`
+	Srcaddr   *int   `json:"srcaddr"`
+	Srctag    string `json:"srctag"`
+	Encrypted int    `json:"encrypted"`
+	Mode      *int   `json:"mode"`
+	HoldTgid  *int   `json:"hold_tgid"`
+	Stream    string `json:"stream"`
+	MsgqID    int    `json:"msgqid"`
+	Capture   bool   `json:"capture"`
+	AutoTrack bool   `json:"auto_tracking"`
+}
+
+func (c *ChannelUpdate) UnmarshalJSON(data []byte) error {
+	var head struct {
+		Channels []string `json:"channels"`
+	}
+	if err := json.Unmarshal(data, &head); err != nil {
+		return err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	c.Channels = make(map[string]*Channel)
+	for _, id := range head.Channels {
+		value, ok := fields[id]
+		if !ok {
+			continue
+		}
+		ch := &Channel{}
+		if err := json.Unmarshal(value, ch); err != nil {
+			return fmt.Errorf("channel_update %s: %v", id, err)
+		}
+		c.Channels[id] = ch
+	}
+	return nil
+}
+
+// ChangeFreq is sent by rx.py whenever it tunes to a new voice channel.
+type ChangeFreq struct {
+	Freq      int64  `json:"fc"`
+	Tgid      *int   `json:"tgid"`
+	Tag       string `json:"tag"`
+	Srcaddr   *int   `json:"srcaddr"`
+	Offset    int64  `json:"offset"`
+	Nac       int    `json:"nac"`
+	System    string `json:"system"`
+	Tdma      *int   `json:"tdma"`
+	Encrypted int    `json:"encrypted"`
+	Mode      *int   `json:"mode"`
+}
+
+// TrunkUpdate decodes a trunk_update message.
+func (m Message) TrunkUpdate() (*TrunkUpdate, error) {
+	if m.Type != TypeTrunkUpdate {
+		return nil, fmt.Errorf("not a %s message: %s", TypeTrunkUpdate, m.Type)
+	}
+	t := &TrunkUpdate{}
+	if err := json.Unmarshal(m.Raw, t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// ChannelUpdate decodes a channel_update message.
+func (m Message) ChannelUpdate() (*ChannelUpdate, error) {
+	if m.Type != TypeChannelUpdate {
+		return nil, fmt.Errorf("not a %s message: %s", TypeChannelUpdate, m.Type)
+	}
+	c := &ChannelUpdate{}
+	if err := json.Unmarshal(m.Raw, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// ChangeFreq decodes a change_freq message.
+func (m Message) ChangeFreq() (*ChangeFreq, error) {
+	if m.Type != TypeChangeFreq {
+		return nil, fmt.Errorf("not a %s message: %s", TypeChangeFreq, m.Type)
+	}
+	c := &ChangeFreq{}
+	if err := json.Unmarshal(m.Raw, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
@@ -0,0 +1,93 @@
+// Package terminaltest provides a stand-in for the rx.py HTTP terminal that
+// replays replies recorded from a boatbod OP25 instance.
+package terminaltest
+
+import (
+	"embed"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"controller25/terminal"
+)
+
+//go:embed fixtures/*.json
+var fixtures embed.FS
+
+// Fixture returns a recorded terminal reply by name (without .json):
+// "update_trunk", "update_channels" or "update_idle".
+func Fixture(name string) []byte {
+	data, err := fixtures.ReadFile("fixtures/" + name + ".json")
+	if err != nil {
+		panic("terminaltest: unknown fixture " + name)
+	}
+	return data
+}
+
+// Server answers update with recorded replies, in order and wrapping
+// around, and every other command with an empty array the way rx.py does
+// when it has nothing queued. All commands are kept for inspection.
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	replies  [][]byte
+	next     int
+	commands []terminal.Command
+}
+
+// NewServer starts a server replaying the default fixtures: an active
+// trunk_update with change_freq, a channel_update, then an idle system.
+func NewServer() *Server {
+	return NewServerWithReplies(Fixture("update_trunk"), Fixture("update_channels"), Fixture("update_idle"))
+}
+
+// NewServerWithReplies starts a server replaying replies to update.
+func NewServerWithReplies(replies ...[]byte) *Server {
+	s := &Server{replies: replies}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// Addr returns host:port, as passed to terminal.NewClient.
+func (s *Server) Addr() string {
+	return strings.TrimPrefix(s.URL, "http://")
+}
+
+// Commands returns every command received so far.
+func (s *Server) Commands() []terminal.Command {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]terminal.Command(nil), s.commands...)
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	var cmds []terminal.Command
+	if err := json.Unmarshal(body, &cmds); err != nil {
+		// rx.py logs the bad input and still answers with the queue
+		cmds = nil
+	}
+
+	s.mu.Lock()
+	s.commands = append(s.commands, cmds...)
+	reply := []byte("[]")
+	for _, cmd := range cmds {
+		if cmd.Command == terminal.CmdUpdate && len(s.replies) > 0 {
+			reply = s.replies[s.next%len(s.replies)]
+			s.next++
+			break
+		}
+	}
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(reply)
+}
@@ -0,0 +1,302 @@
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"controller25/audio"
+	"controller25/config"
+	"controller25/recorder"
+)
+
+const (
+	openMHzURL      = "https://api.openmhz.com"
+	broadcastifyURL = "https://api.broadcastify.com/call-upload"
+	// rdio-scanner takes calls here, under the URL its listeners open
+	rdioScannerPath = "/api/call-upload"
+)
+
+// endpointFor checks a target's settings and returns where calls are
+// posted.
+func endpointFor(c config.UploadConfig) (string, error) {
+	if c.APIKey == "" {
+		return "", errors.New("api_key is required")
+	}
+	if c.System == "" {
+		return "", errors.New("system is required")
+	}
+	switch c.Type {
+	case config.UploadOpenMHz:
+		base := c.URL
+		if base == "" {
+			base = openMHzURL
+		}
+		return strings.TrimSuffix(base, "/") + "/" + url.PathEscape(c.System) + "/upload", nil
+	case config.UploadBroadcastify:
+		if c.URL == "" {
+			return broadcastifyURL, nil
+		}
+		return c.URL, nil
+	default:
+		u, err := url.Parse(c.URL)
+		if err != nil || u.Host == "" {
+			return "", errors.New("url of the rdio-scanner server is required")
+		}
+		if u.Path == "" || u.Path == "/" {
+			u.Path = rdioScannerPath
+		}
+		return u.String(), nil
+	}
+}
+
+// uploadRdioScanner posts the WAV file with rdio-scanner's call-upload
+// fields, as its dirwatch and trunk-recorder's uploader do.
+func (u *Uploader) uploadRdioScanner(ctx context.Context, t *target, j *job) error {
+	wav, err := os.ReadFile(j.Audio)
+	if err != nil {
+		return err
+	}
+	m := j.Call
+	form := newForm()
+	form.field("key", t.cfg.APIKey)
+	form.field("system", t.cfg.System)
+	form.field("talkgroup", strconv.Itoa(m.Tgid))
+	form.field("dateTime", m.Start.UTC().Format(time.RFC3339))
+	form.field("frequency", strconv.FormatInt(m.Frequency, 10))
+	if len(m.Sources) > 0 {
+		form.field("source", strconv.Itoa(m.Sources[0]))
+	}
+	if m.Talkgroup != "" {
+		form.field("talkgroupLabel", m.Talkgroup)
+	}
+	if m.Category != "" {
+		form.field("talkgroupGroup", m.Category)
+	}
+	if m.Site != nil && m.Site.System != "" {
+		form.field("systemLabel", m.Site.System)
+	}
+	form.file("audio", filepath.Base(j.Audio), "audio/wav", wav)
+	form.field("audioName", filepath.Base(j.Audio))
+	form.field("audioType", "audio/wav")
+
+	resp, err := u.post(ctx, t.endpoint, form)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return statusError(resp)
+	}
+	return nil
+}
+
+// uploadOpenMHz posts the call as MP3 with the fields trunk-recorder's
+// OpenMHz uploader sends.
+func (u *Uploader) uploadOpenMHz(ctx context.Context, t *target, j *job) error {
+	mp3, err := encodeMP3(j.Audio)
+	if err != nil {
+		return err
+	}
+	m := j.Call
+	emergency := "0"
+	if m.Emergency {
+		emergency = "1"
+	}
+	form := newForm()
+	form.file("call", strings.TrimSuffix(filepath.Base(j.Audio), ".wav")+".mp3", "audio/mpeg", mp3)
+	form.field("freq", strconv.FormatInt(m.Frequency, 10))
+	form.field("error_count", "0")
+	form.field("spike_count", "0")
+	form.field("start_time", strconv.FormatInt(m.Start.Unix(), 10))
+	form.field("stop_time", strconv.FormatInt(m.End.Unix(), 10))
+	form.field("call_length", strconv.FormatFloat(m.Duration, 'f', 1, 64))
+	form.field("talkgroup_num", strconv.Itoa(m.Tgid))
+	form.field("emergency", emergency)
+	form.field("api_key", t.cfg.APIKey)
+	form.field("source_list", sourceList(m))
+
+	resp, err := u.post(ctx, t.endpoint, form)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return statusError(resp)
+	}
+	return nil
+}
+
+// uploadBroadcastify announces the call to Broadcastify Calls, which
+// answers with where to PUT the audio, or declines the call when another
+// feed on the system already sent it.
+func (u *Uploader) uploadBroadcastify(ctx context.Context, t *target, j *job) error {
+	mp3, err := encodeMP3(j.Audio)
+	if err != nil {
+		return err
+	}
+	m := j.Call
+	src := 0
+	if len(m.Sources) > 0 {
+		src = m.Sources[0]
+	}
+	form := newForm()
+	form.field("apiKey", t.cfg.APIKey)
+	form.field("systemId", t.cfg.System)
+	form.field("callDuration", strconv.FormatFloat(m.Duration, 'f', 1, 64))
+	form.field("ts", strconv.FormatInt(m.Start.Unix(), 10))
+	form.field("tg", strconv.Itoa(m.Tgid))
+	form.field("src", strconv.Itoa(src))
+	form.field("freq", strconv.FormatFloat(float64(m.Frequency)/1e6, 'f', 6, 64))
+	form.field("enc", "mp3")
+
+	resp, err := u.post(ctx, t.endpoint, form)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return statusError(resp)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err != nil {
+		return err
+	}
+	// "0 <upload URL>" on success, "1 <reason>" otherwise
+	code, detail, _ := strings.Cut(strings.TrimSpace(string(body)), " ")
+	switch {
+	case code == "0" && detail != "":
+	case strings.Contains(strings.ToLower(detail), "skip"), strings.Contains(strings.ToLower(detail), "duplicate"):
+		return nil
+	default:
+		return permanentError{fmt.Errorf("upload declined: %s", strings.TrimSpace(string(body)))}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, detail, bytes.NewReader(mp3))
+	if err != nil {
+		return permanentError{err}
+	}
+	req.Header.Set("Content-Type", "audio/mpeg")
+	put, err := u.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer put.Body.Close()
+	if put.StatusCode != http.StatusOK {
+		return statusError(put)
+	}
+	return nil
+}
+
+func (u *Uploader) post(ctx context.Context, endpoint string, form *form) (*http.Response, error) {
+	contentType, body, err := form.finish()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, body)
+	if err != nil {
+		return nil, permanentError{err}
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("User-Agent", "controller25")
+	return u.client.Do(req)
+}
+
+// statusError describes an unexpected response. Server errors, rate
+// limits and rejected API keys are retried, the last until the key is
+// fixed; any other client error means the service won't take the call.
+func statusError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err := fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	switch resp.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return err
+	}
+	if resp.StatusCode >= 500 {
+		return err
+	}
+	return permanentError{err}
+}
+
+// sourceList is OpenMHz's list of units heard. The recorder doesn't keep
+// when each one keyed up, so all are placed at the start of the call.
+func sourceList(m recorder.Metadata) string {
+	type source struct {
+		Pos float64 `json:"pos"`
+		Src int     `json:"src"`
+	}
+	list := make([]source, len(m.Sources))
+	for i, src := range m.Sources {
+		list[i] = source{Src: src}
+	}
+	data, _ := json.Marshal(list)
+	return string(data)
+}
+
+func encodeMP3(path string) ([]byte, error) {
+	pcm, sampleRate, channels, err := recorder.ReadPCM(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		// A damaged recording won't read any better next time
+		return nil, permanentError{err}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return audio.EncodeMP3(pcm, sampleRate, channels), nil
+}
+
+// form builds a multipart/form-data body.
+type form struct {
+	buf bytes.Buffer
+	w   *multipart.Writer
+	err error
+}
+
+func newForm() *form {
+	f := &form{}
+	f.w = multipart.NewWriter(&f.buf)
+	return f
+}
+
+func (f *form) field(name, value string) {
+	if f.err == nil {
+		f.err = f.w.WriteField(name, value)
+	}
+}
+
+func (f *form) file(name, filename, contentType string, data []byte) {
+	if f.err != nil {
+		return
+	}
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Disposition", fmt.Sprintf(`form-data; name=%q; filename=%q`, name, filename))
+	h.Set("Content-Type", contentType)
+	part, err := f.w.CreatePart(h)
+	if err != nil {
+		f.err = err
+		return
+	}
+	_, f.err = part.Write(data)
+}
+
+func (f *form) finish() (string, io.Reader, error) {
+	if f.err != nil {
+		return "", nil, f.err
+	}
+	if err := f.w.Close(); err != nil {
+		return "", nil, err
+	}
+	return f.w.FormDataContentType(), &f.buf, nil
+}
@@ -0,0 +1,329 @@
+package uploader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"controller25/config"
+	"controller25/recorder"
+)
+
+const (
+	// pollInterval is how often the queue is retried without new calls
+	pollInterval = 15 * time.Second
+	// A target that fails waits this long before the next try, doubling
+	// up to maxBackoff while it keeps failing
+	minBackoff = 30 * time.Second
+	maxBackoff = 30 * time.Minute
+	// uploadTimeout bounds one call's upload, including the audio
+	uploadTimeout = 2 * time.Minute
+	// failedDir holds the calls a service refused for good
+	failedDir = "failed"
+)
+
+// Status reports one upload target's queue and last result.
+type Status struct {
+	Name       string    `json:"name"`
+	Type       string    `json:"type"`
+	URL        string    `json:"url"`
+	Queued     int       `json:"queued"`
+	Uploaded   int       `json:"uploaded"` // since the controller started
+	Failed     int       `json:"failed"`   // refused by the service, kept under failed/
+	LastUpload time.Time `json:"last_upload,omitzero"`
+	LastError  string    `json:"last_error,omitempty"`
+	RetryAt    time.Time `json:"retry_at,omitzero"` // while the target is backing off
+}
+
+// Uploader sends recorded calls to call sharing services. Each call is
+// queued as a file under the queue directory before anything is sent, so
+// calls recorded while the network is down go out once it is back, even
+// across restarts.
+type Uploader struct {
+	dir     string
+	targets []*target
+	byName  map[string]*target
+	client  *http.Client
+	wake    chan struct{}
+	ctx     context.Context // canceled by Close
+	stop    context.CancelFunc
+	done    chan struct{} // closed when Run returns
+
+	mu sync.Mutex // guards the counters in each target
+}
+
+type target struct {
+	cfg      config.UploadConfig
+	endpoint string
+
+	queued     int
+	uploaded   int
+	failed     int
+	lastUpload time.Time
+	lastError  string
+	retryAt    time.Time
+	backoff    time.Duration
+}
+
+// job is one call queued for one target, stored as JSON.
+type job struct {
+	Target    string            `json:"target"`
+	Audio     string            `json:"audio"` // absolute path of the WAV file
+	Call      recorder.Metadata `json:"call"`
+	Queued    time.Time         `json:"queued"`
+	Attempts  int               `json:"attempts"`
+	LastError string            `json:"last_error,omitempty"`
+
+	file string
+}
+
+// permanentError is a refusal that retrying won't change.
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+// New opens the queue directory and sets up the targets. Targets with
+// incomplete settings are skipped with a warning.
+func New(dir string, targets []config.UploadConfig) (*Uploader, error) {
+	if err := os.MkdirAll(filepath.Join(dir, failedDir), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create upload queue: %v", err)
+	}
+	u := &Uploader{
+		dir:    dir,
+		byName: make(map[string]*target),
+		client: &http.Client{Timeout: uploadTimeout},
+		wake:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	u.ctx, u.stop = context.WithCancel(context.Background())
+	for _, c := range targets {
+		endpoint, err := endpointFor(c)
+		if err == nil && strings.ContainsAny(c.Name, `/\`) {
+			err = errors.New("name must not contain slashes")
+		}
+		if err != nil {
+			log.Printf("Warning: upload target %s: %v", c.Name, err)
+			continue
+		}
+		t := &target{cfg: c, endpoint: endpoint}
+		u.targets = append(u.targets, t)
+		u.byName[c.Name] = t
+	}
+	for _, j := range u.jobs() {
+		if t := u.byName[j.Target]; t != nil {
+			t.queued++
+		}
+	}
+	return u, nil
+}
+
+// Add queues a recorded call for every target that wants it.
+func (u *Uploader) Add(path string, m recorder.Metadata) {
+	audio, err := filepath.Abs(path)
+	if err != nil {
+		log.Printf("Warning: not uploading %s: %v", path, err)
+		return
+	}
+	queued := false
+	for _, t := range u.targets {
+		if !t.cfg.Wants(m.Receiver, m.Tgid) {
+			continue
+		}
+		j := &job{Target: t.cfg.Name, Audio: audio, Call: m, Queued: time.Now()}
+		// Named after the recording, which is unique where the call ID is
+		// not: receivers hearing the same call each record it
+		j.file = filepath.Join(u.dir, fmt.Sprintf("%019d-%s-%s.json", m.Start.UnixNano(), strings.TrimSuffix(filepath.Base(audio), ".wav"), t.cfg.Name))
+		if err := j.save(); err != nil {
+			log.Printf("Warning: failed to queue call %s for %s: %v", m.ID, t.cfg.Name, err)
+			continue
+		}
+		u.mu.Lock()
+		t.queued++
+		u.mu.Unlock()
+		queued = true
+	}
+	if queued {
+		select {
+		case u.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Run works through the queue, oldest call first, until Close.
+func (u *Uploader) Run() {
+	defer close(u.done)
+	if len(u.targets) == 0 {
+		return
+	}
+	for {
+		u.drain()
+		select {
+		case <-u.wake:
+		case <-time.After(pollInterval):
+		case <-u.ctx.Done():
+			return
+		}
+	}
+}
+
+// Close stops Run and waits for it. An upload in progress is abandoned;
+// its call stays queued for the next start.
+func (u *Uploader) Close() {
+	u.stop()
+	<-u.done
+}
+
+// Status returns every target's state, in config order.
+func (u *Uploader) Status() []Status {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	statuses := make([]Status, 0, len(u.targets))
+	for _, t := range u.targets {
+		s := Status{
+			Name:       t.cfg.Name,
+			Type:       t.cfg.Type,
+			URL:        t.endpoint,
+			Queued:     t.queued,
+			Uploaded:   t.uploaded,
+			Failed:     t.failed,
+			LastUpload: t.lastUpload,
+			LastError:  t.lastError,
+		}
+		if time.Now().Before(t.retryAt) {
+			s.RetryAt = t.retryAt
+		}
+		statuses = append(statuses, s)
+	}
+	return statuses
+}
+
+// drain tries every queued call whose target isn't backing off.
+func (u *Uploader) drain() {
+	for _, j := range u.jobs() {
+		if u.ctx.Err() != nil {
+			return
+		}
+		t := u.byName[j.Target]
+		if t == nil {
+			// The target was removed from config.ini; the file stays
+			continue
+		}
+		u.mu.Lock()
+		waiting := time.Now().Before(t.retryAt)
+		u.mu.Unlock()
+		if waiting {
+			continue
+		}
+
+		err := u.upload(t, j)
+		if u.ctx.Err() != nil {
+			// Cut short by Close; not the target's fault
+			return
+		}
+		var refused permanentError
+		u.mu.Lock()
+		switch {
+		case err == nil:
+			t.uploaded++
+			t.queued--
+			t.lastUpload = time.Now()
+			t.lastError = ""
+			t.backoff = 0
+			os.Remove(j.file)
+		case errors.Is(err, os.ErrNotExist):
+			// The recording was deleted; there is nothing left to send
+			t.queued--
+			os.Remove(j.file)
+			log.Printf("Upload to %s: dropped call %s: %v", t.cfg.Name, j.Call.ID, err)
+		case errors.As(err, &refused):
+			t.failed++
+			t.queued--
+			t.lastError = err.Error()
+			j.Attempts++
+			j.LastError = err.Error()
+			if err := j.save(); err == nil {
+				os.Rename(j.file, filepath.Join(u.dir, failedDir, filepath.Base(j.file)))
+			}
+			log.Printf("Upload to %s: %s refused call %s: %v", t.cfg.Name, t.cfg.Type, j.Call.ID, err)
+		default:
+			t.backoff = min(max(2*t.backoff, minBackoff), maxBackoff)
+			t.retryAt = time.Now().Add(t.backoff)
+			t.lastError = err.Error()
+			j.Attempts++
+			j.LastError = err.Error()
+			_ = j.save()
+			log.Printf("Upload to %s failed, retrying in %v: %v", t.cfg.Name, t.backoff, err)
+		}
+		u.mu.Unlock()
+	}
+}
+
+func (u *Uploader) upload(t *target, j *job) error {
+	if _, err := os.Stat(j.Audio); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(u.ctx, uploadTimeout)
+	defer cancel()
+	switch t.cfg.Type {
+	case config.UploadOpenMHz:
+		return u.uploadOpenMHz(ctx, t, j)
+	case config.UploadBroadcastify:
+		return u.uploadBroadcastify(ctx, t, j)
+	default:
+		return u.uploadRdioScanner(ctx, t, j)
+	}
+}
+
+// jobs reads the queue, oldest call first. Unreadable files are skipped.
+func (u *Uploader) jobs() []*job {
+	entries, err := os.ReadDir(u.dir)
+	if err != nil {
+		log.Printf("Warning: failed to read upload queue: %v", err)
+		return nil
+	}
+	var jobs []*job
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+		path := filepath.Join(u.dir, e.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		j := &job{file: path}
+		if err := json.Unmarshal(data, j); err != nil {
+			log.Printf("Warning: skipping unreadable upload %s: %v", e.Name(), err)
+			continue
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs
+}
+
+// save writes the job through a temporary file, like the recorder's
+// sidecars, so a crash never leaves half a job behind.
+func (j *job) save() error {
+	data, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := j.file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, j.file)
+}
@@ -0,0 +1,224 @@
+// Package wsaudio serves live audio over a WebSocket with the call
+// metadata in-band, so a client can tell which samples belong to which
+// call without polling /api/talkgroup.
+//
+// Binary messages are raw PCM (S16_LE). Text messages are JSON metadata;
+// each carries "sample", the position in the connection's audio, counted
+// in samples per channel from the first binary message, where it applies.
+package wsaudio
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"controller25/audio"
+	"controller25/talkgroup"
+)
+
+const (
+	// preroll is how far back a call start may be placed: audio is heard
+	// before the call detector learns who is talking
+	preroll = 2 * time.Second
+	// gap is the pause that separates one run of audio from the next
+	gap = 300 * time.Millisecond
+
+	keepaliveInterval = 15 * time.Second
+)
+
+// Message is a JSON metadata message.
+type Message struct {
+	Type       string `json:"type"` // hello, call_start, call_update, call_end or keepalive
+	Sample     int64  `json:"sample"`
+	Format     string `json:"format,omitempty"` // hello only
+	SampleRate int    `json:"sample_rate,omitempty"`
+	Channels   int    `json:"channels,omitempty"`
+	Call       *Call  `json:"call,omitempty"`
+}
+
+// Call describes the call being played.
+type Call struct {
+	ID        string `json:"id"`
+	Tgid      int    `json:"tgid"`
+	Srcid     int    `json:"srcid"`
+	AlphaTag  string `json:"alpha_tag,omitempty"`
+	Sources   []int  `json:"sources"`
+	Emergency bool   `json:"emergency"`
+	Encrypted bool   `json:"encrypted"`
+}
+
+// Options wire the handler to a receiver's audio.
+type Options struct {
+	Audio *audio.Broadcaster
+	// Calls are the calls Audio carries, its own rather than the
+	// receiver's: the mixed stream plays the most important of several
+	Calls audio.CallSource
+	// AlphaTag names a talkgroup, or returns ""
+	AlphaTag func(tgid int) string
+}
+
+// Handler returns the /ws/audio handler. Origin isn't checked: the app
+// connects from a native client that doesn't send one.
+func Handler(opts Options) http.Handler {
+	return websocket.Server{
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler:   func(ws *websocket.Conn) { serve(ws, opts) },
+	}
+}
+
+// conn tracks one client's position in the stream.
+type conn struct {
+	ws   *websocket.Conn
+	opts Options
+
+	sample    int64     // samples sent so far
+	runStart  int64     // where the current run of audio began
+	runAt     time.Time // when it began
+	lastFrame time.Time
+	playing   *Call // call holding the stream, as last announced
+	callStart int64 // where it began
+	prevEnd   int64 // where the previous call ended
+}
+
+func serve(ws *websocket.Conn, opts Options) {
+	defer ws.Close()
+	frames, unsubFrames := opts.Audio.Subscribe(256)
+	defer unsubFrames()
+	events, unsubEvents := opts.Calls.Subscribe()
+	defer unsubEvents()
+
+	// Nothing is expected from the client; reading is how a close is seen
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		var discard []byte
+		for websocket.Message.Receive(ws, &discard) == nil {
+		}
+	}()
+
+	c := &conn{ws: ws, opts: opts}
+	hello := Message{
+		Type:       "hello",
+		Format:     "s16le",
+		SampleRate: opts.Audio.SampleRate,
+		Channels:   opts.Audio.Channels,
+	}
+	for _, rec := range opts.Calls.Active() {
+		if rec.Playing {
+			c.playing = c.call(rec)
+			hello.Call = c.playing
+		}
+	}
+	if c.send(hello) != nil {
+		return
+	}
+
+	keepalive := time.NewTicker(keepaliveInterval)
+	defer keepalive.Stop()
+	for {
+		var err error
+		select {
+		case pcm, ok := <-frames:
+			if !ok {
+				return
+			}
+			err = c.audio(pcm)
+		case e := <-events:
+			err = c.event(e)
+		case <-keepalive.C:
+			err = c.send(Message{Type: "keepalive", Sample: c.sample})
+		case <-closed:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (c *conn) audio(pcm []byte) error {
+	now := time.Now()
+	if now.Sub(c.lastFrame) > gap {
+		c.runStart, c.runAt = c.sample, now
+	}
+	c.lastFrame = now
+	if err := websocket.Message.Send(c.ws, pcm); err != nil {
+		return err
+	}
+	c.sample += int64(len(pcm) / (2 * c.opts.Audio.Channels))
+	return nil
+}
+
+func (c *conn) event(e talkgroup.CallEvent) error {
+	rec := e.Call
+	ours := c.playing != nil && c.playing.ID == rec.ID
+	switch {
+	case ours && (!rec.Playing || e.Type == talkgroup.CallEnd):
+		return c.end()
+	case ours:
+		call := c.call(rec)
+		if call.Srcid == c.playing.Srcid && call.AlphaTag == c.playing.AlphaTag &&
+			call.Emergency == c.playing.Emergency && call.Encrypted == c.playing.Encrypted {
+			return nil
+		}
+		c.playing = call
+		return c.send(Message{Type: "call_update", Sample: c.sample, Call: call})
+	case rec.Playing && e.Type != talkgroup.CallEnd:
+		if c.playing != nil {
+			if err := c.end(); err != nil {
+				return err
+			}
+		}
+		// The call began with the run of audio it is part of, if that
+		// started recently enough to be its own
+		start := c.sample
+		if time.Since(c.lastFrame) < gap && time.Since(c.runAt) < preroll {
+			start = c.runStart
+		}
+		c.callStart = max(start, c.prevEnd)
+		c.playing = c.call(rec)
+		return c.send(Message{Type: "call_start", Sample: c.callStart, Call: c.playing})
+	}
+	return nil
+}
+
+// end announces the end of the playing call. If audio has moved on to a
+// new run since the call started, the call ended where that run began.
+func (c *conn) end() error {
+	end := c.sample
+	if time.Since(c.lastFrame) < gap && c.runStart > c.callStart {
+		end = c.runStart
+	}
+	call := c.playing
+	c.playing = nil
+	c.prevEnd = end
+	return c.send(Message{Type: "call_end", Sample: end, Call: call})
+}
+
+func (c *conn) call(rec talkgroup.CallRecord) *Call {
+	call := &Call{
+		ID:        rec.ID,
+		Tgid:      rec.Tgid,
+		Srcid:     rec.Srcid,
+		AlphaTag:  rec.Tag,
+		Sources:   rec.Sources,
+		Emergency: rec.Emergency,
+		Encrypted: rec.Encrypted,
+	}
+	if c.opts.AlphaTag != nil {
+		if name := c.opts.AlphaTag(rec.Tgid); name != "" {
+			call.AlphaTag = name
+		}
+	}
+	return call
+}
+
+func (c *conn) send(m Message) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return websocket.Message.Send(c.ws, string(data))
+}